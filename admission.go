@@ -0,0 +1,128 @@
+package blogproxy
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	sketchDepth = 4
+	sketchWidth = 1 << 12
+)
+
+var admissionRejected = metrics.Counter(
+	"blogproxy_admission_rejected_total",
+	"Fetched objects served but not admitted into the cache, by reason.",
+	"reason",
+)
+
+// Admission decides which fetched objects are worth caching. Objects over
+// the size cap are never cached, and with a popularity threshold an object
+// must be requested that many times within a window before it is admitted,
+// in the spirit of TinyLFU. One-off crawler hits then don't push out the
+// pages readers keep coming back to.
+type Admission struct {
+	maxBytes    int64
+	minRequests int
+	window      time.Duration
+	clock       Clock
+
+	mu      sync.Mutex
+	sketch  [sketchDepth][sketchWidth]uint8
+	resetAt time.Time
+}
+
+func NewAdmission(cfg AdmissionConfig, clock Clock) *Admission {
+	return &Admission{
+		maxBytes:    cfg.MaxObjectBytes,
+		minRequests: cfg.MinRequests,
+		window:      time.Duration(cfg.Window),
+		clock:       clock,
+		resetAt:     clock.Now().Add(time.Duration(cfg.Window)),
+	}
+}
+
+// Record counts a request for key.
+func (a *Admission) Record(key string) {
+	if a.minRequests <= 1 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.age()
+	for i, slot := range sketchSlots(key) {
+		if a.sketch[i][slot] < 255 {
+			a.sketch[i][slot]++
+		}
+	}
+}
+
+// Popular reports whether key was requested often enough within the window
+// to be admitted.
+func (a *Admission) Popular(key string) bool {
+	if a.minRequests <= 1 {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.popular(key)
+}
+
+// popular is Popular with a.mu held.
+func (a *Admission) popular(key string) bool {
+	estimate := uint8(255)
+	for i, slot := range sketchSlots(key) {
+		estimate = min(estimate, a.sketch[i][slot])
+	}
+	return int(estimate) >= a.minRequests
+}
+
+// Admit reports whether an object of size bytes stored under key may enter
+// the cache, and if not, why.
+func (a *Admission) Admit(key string, size int) (ok bool, reason string) {
+	if a.maxBytes > 0 && int64(size) > a.maxBytes {
+		return false, "size"
+	}
+	if a.minRequests <= 1 {
+		return true, ""
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.popular(key) {
+		return false, "popularity"
+	}
+	return true, ""
+}
+
+// age halves all counters once per window so popularity reflects recent
+// traffic.
+func (a *Admission) age() {
+	now := a.clock.Now()
+	if a.window <= 0 || now.Before(a.resetAt) {
+		return
+	}
+	for i := range a.sketch {
+		for j := range a.sketch[i] {
+			a.sketch[i][j] >>= 1
+		}
+	}
+	a.resetAt = now.Add(a.window)
+}
+
+func sketchSlots(key string) [sketchDepth]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	// derive the row hashes from the two halves of one hash
+	lo, hi := uint32(sum), uint32(sum>>32)
+	var slots [sketchDepth]uint32
+	for i := range slots {
+		slots[i] = (lo + uint32(i)*hi) % sketchWidth
+	}
+	return slots
+}
@@ -0,0 +1,104 @@
+package blogproxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdmissionSize(t *testing.T) {
+	admission := NewAdmission(AdmissionConfig{MaxObjectBytes: 100}, newFakeClock())
+	if ok, reason := admission.Admit("a", 100); !ok {
+		t.Errorf("Admit of an object at the cap refused for %s", reason)
+	}
+	if ok, reason := admission.Admit("a", 101); ok || reason != "size" {
+		t.Errorf("Admit of an object over the cap = %v, %q, want refused for size", ok, reason)
+	}
+
+	// the size cap holds for popular objects too
+	admission = NewAdmission(AdmissionConfig{MaxObjectBytes: 100, MinRequests: 2, Window: Duration(time.Hour)}, newFakeClock())
+	for range 3 {
+		admission.Record("a")
+	}
+	if ok, reason := admission.Admit("a", 101); ok || reason != "size" {
+		t.Errorf("Admit of a popular object over the cap = %v, %q, want refused for size", ok, reason)
+	}
+}
+
+func TestAdmissionPopularity(t *testing.T) {
+	admission := NewAdmission(AdmissionConfig{MinRequests: 3, Window: Duration(time.Hour)}, newFakeClock())
+	for i := 1; i < 3; i++ {
+		admission.Record("a")
+		if ok, reason := admission.Admit("a", 1); ok || reason != "popularity" {
+			t.Fatalf("Admit after %d requests = %v, %q, want refused for popularity", i, ok, reason)
+		}
+	}
+	admission.Record("a")
+	if ok, reason := admission.Admit("a", 1); !ok {
+		t.Fatalf("Admit after 3 requests refused for %s", reason)
+	}
+	if admission.Popular("b") {
+		t.Error("an object never requested is popular")
+	}
+
+	// without a threshold everything is admitted on first request
+	admission = NewAdmission(AdmissionConfig{MinRequests: 1}, newFakeClock())
+	if ok, reason := admission.Admit("a", 1); !ok {
+		t.Errorf("Admit without a threshold refused for %s", reason)
+	}
+}
+
+func TestAdmissionWindow(t *testing.T) {
+	clock := newFakeClock()
+	admission := NewAdmission(AdmissionConfig{MinRequests: 2, Window: Duration(time.Hour)}, clock)
+
+	// requests in different windows don't add up to admission
+	admission.Record("a")
+	clock.Advance(time.Hour)
+	admission.Record("a")
+	if admission.Popular("a") {
+		t.Fatal("one request per window made an object popular")
+	}
+	admission.Record("a")
+	if !admission.Popular("a") {
+		t.Fatal("two requests within the window didn't make an object popular")
+	}
+
+	// popularity fades once the requests stop
+	for range 2 {
+		admission.Record("a")
+	}
+	clock.Advance(time.Hour)
+	admission.Record("b")
+	if !admission.Popular("a") {
+		t.Fatal("four requests faded after one window")
+	}
+	clock.Advance(time.Hour)
+	admission.Record("b")
+	if admission.Popular("a") {
+		t.Fatal("four requests still count after two windows")
+	}
+}
+
+func TestProxyAdmission(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/big.html":   {Body: essay + essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Admission = AdmissionConfig{
+			MaxObjectBytes: int64(len(essay)),
+			MinRequests:    2,
+			Window:         Duration(time.Hour),
+		}
+	})
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		// admitted on the second request, served from the cache after
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/big.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/big.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/big.html", WantStatus: http.StatusOK, WantOriginRequests: 3},
+	})
+}
@@ -0,0 +1,168 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// defaultSchemes are the schemes bare hostnames of an allowlist match
+// unless the tenant restricts them.
+var defaultSchemes = []string{"https", "http"}
+
+// defaultPorts are dropped from origins, so "https://example.com:443" is
+// "https://example.com".
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Allowlist holds the origins a tenant may proxy. An entry is either an
+// origin, "https://paulgraham.com", matching that scheme only, or a bare
+// hostname, "paulgraham.com", matching each of the allowed schemes. Hosts
+// are compared case-insensitively and without their default port.
+type Allowlist struct {
+	origins map[string]struct{}
+	hosts   map[string]struct{}
+	schemes []string
+}
+
+func NewAllowlist(entries, schemes []string) (Allowlist, error) {
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	for _, scheme := range schemes {
+		if _, ok := defaultPorts[scheme]; !ok {
+			return Allowlist{}, fmt.Errorf("unknown scheme %q, want http or https", scheme)
+		}
+	}
+
+	a := Allowlist{origins: make(map[string]struct{}), hosts: make(map[string]struct{}), schemes: schemes}
+	for _, entry := range entries {
+		if !strings.Contains(entry, "://") {
+			host, ok := normalizeHost(entry, "")
+			if !ok {
+				return Allowlist{}, fmt.Errorf("invalid allowed host %q", entry)
+			}
+			a.hosts[host] = struct{}{}
+			continue
+		}
+		origin, ok := normalizeOrigin(entry)
+		if !ok {
+			return Allowlist{}, fmt.Errorf("invalid allowed host %q, want an origin like https://example.com or a hostname", entry)
+		}
+		a.origins[origin] = struct{}{}
+	}
+	return a, nil
+}
+
+// Allows reports whether hostName, the scheme and host of a target,
+// matches an entry.
+func (a Allowlist) Allows(hostName string) bool {
+	origin, ok := normalizeOrigin(hostName)
+	if !ok {
+		return false
+	}
+	if _, ok := a.origins[origin]; ok {
+		return true
+	}
+	scheme, host, _ := strings.Cut(origin, "://")
+	if !slices.Contains(a.schemes, scheme) {
+		return false
+	}
+	if _, ok := a.hosts[host]; ok {
+		return true
+	}
+	// a bare "example.com:443" names the default port of https explicitly
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return false
+	}
+	_, ok = a.hosts[net.JoinHostPort(strings.Trim(host, "[]"), defaultPorts[scheme])]
+	return ok
+}
+
+// canonicalHost returns hostName, "scheme://host", as normalizeOrigin
+// spells it, so every spelling of an origin is denied, limited, budgeted
+// and cached alike. A host name normalizeOrigin rejects is returned as it
+// is, for the allowlist to refuse.
+func canonicalHost(hostName string) string {
+	if origin, ok := normalizeOrigin(hostName); ok {
+		return origin
+	}
+	return hostName
+}
+
+// canonicalHostKeys returns m, a config setting keyed by host name, with
+// its keys spelled by canonicalHost.
+func canonicalHostKeys[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	canonical := make(map[string]V, len(m))
+	for hostName, v := range m {
+		canonical[canonicalHost(hostName)] = v
+	}
+	return canonical
+}
+
+// normalizeOrigin returns origin, "scheme://host[:port]", lowercased and
+// without its default port. ok is false for anything else, such as an
+// origin with a path or of another scheme.
+func normalizeOrigin(origin string) (normalized string, ok bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.User != nil || u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[scheme]; !ok {
+		return "", false
+	}
+	host, ok := normalizeHost(u.Host, scheme)
+	if !ok {
+		return "", false
+	}
+	return scheme + "://" + host, true
+}
+
+// normalizeHost lowercases host, "name[:port]", spells an internationalized
+// name in Unicode and drops the port when it is the default port of scheme.
+func normalizeHost(host, scheme string) (string, bool) {
+	host = strings.ToLower(host)
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// no port
+		name, port = host, ""
+	}
+	if name == "" || strings.ContainsAny(name, "/?#@ ") {
+		return "", false
+	}
+	name, ok := displayHost(name)
+	if !ok {
+		return "", false
+	}
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+	if port != "" {
+		return net.JoinHostPort(name, port), true
+	}
+	if strings.Contains(name, ":") && !strings.HasPrefix(name, "[") {
+		// an IPv6 address that lost its default port
+		return "[" + name + "]", true
+	}
+	return name, true
+}
+
+// Origins returns the origins of the entries, bare hostnames being given
+// the first of the allowed schemes.
+func (a Allowlist) Origins() []string {
+	origins := make([]string, 0, len(a.origins)+len(a.hosts))
+	for origin := range a.origins {
+		origins = append(origins, origin)
+	}
+	for host := range a.hosts {
+		if origin, ok := normalizeOrigin(a.schemes[0] + "://" + host); ok {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
@@ -0,0 +1,56 @@
+package blogproxy
+
+import "testing"
+
+func TestAllowlist(t *testing.T) {
+	allowlist, err := NewAllowlist([]string{
+		"paulgraham.com",
+		"localhost:8080",
+		"https://Blog.Example.com:443/",
+		"[::1]",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewAllowlist: %v", err)
+	}
+	httpsOnly, err := NewAllowlist([]string{"paulgraham.com:443", "http://legacy.example.com"}, []string{"https"})
+	if err != nil {
+		t.Fatalf("NewAllowlist: %v", err)
+	}
+
+	tests := []struct {
+		allowlist Allowlist
+		hostName  string
+		want      bool
+	}{
+		{allowlist, "https://paulgraham.com", true},
+		{allowlist, "http://paulgraham.com", true},
+		{allowlist, "https://PaulGraham.com:443", true},
+		{allowlist, "http://paulgraham.com:80", true},
+		{allowlist, "https://paulgraham.com:8443", false},
+		{allowlist, "https://www.paulgraham.com", false},
+		{allowlist, "http://localhost:8080", true},
+		{allowlist, "http://localhost", false},
+		{allowlist, "https://blog.example.com", true},
+		{allowlist, "http://blog.example.com", false},
+		{allowlist, "http://[::1]", true},
+		{allowlist, "https://[::1]:443", true},
+		{allowlist, "httpd://paulgraham.com", false},
+		{httpsOnly, "https://paulgraham.com", true},
+		{httpsOnly, "http://paulgraham.com", false},
+		{httpsOnly, "http://legacy.example.com", true},
+	}
+	for _, tt := range tests {
+		if got := tt.allowlist.Allows(tt.hostName); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.hostName, got, tt.want)
+		}
+	}
+
+	for _, entries := range [][]string{{"https://example.com/blog"}, {"ftp://example.com"}, {"user@example.com"}} {
+		if _, err := NewAllowlist(entries, nil); err == nil {
+			t.Errorf("NewAllowlist accepted %q", entries)
+		}
+	}
+	if _, err := NewAllowlist(nil, []string{"gopher"}); err == nil {
+		t.Error("NewAllowlist accepted the gopher scheme")
+	}
+}
@@ -0,0 +1,229 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPageAnnotations bounds the annotations of a tenant on one page.
+	maxPageAnnotations = 200
+	// maxAnnotations bounds the annotations of a tenant on all pages.
+	maxAnnotations = 10000
+	// maxAnnotationBody bounds the request body of POST /annotations.
+	maxAnnotationBody = 64 << 10
+)
+
+var errTooManyAnnotations = errors.New("too many annotations")
+
+// Annotation is a highlight, optionally with a note, on the readable text
+// of a page. Start and End are rune offsets into that text.
+type Annotation struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Start     int       `json:"start"`
+	End       int       `json:"end"`
+	Quote     string    `json:"quote"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Tenant is the name of the tenant that made the annotation, empty for
+	// the default tenant.
+	Tenant string `json:"-"`
+}
+
+// Annotations stores the highlights of all pages, persisted next to the
+// bookmarks. Every tenant only sees its own.
+type Annotations struct {
+	path  string
+	clock Clock
+
+	mu    sync.RWMutex
+	byID  map[string]*Annotation
+	byURL map[tenantURL][]*Annotation
+	// count is the number of annotations of every tenant
+	count map[string]int
+}
+
+// NewAnnotations loads the annotations persisted in dir. An empty dir keeps
+// them in memory only.
+func NewAnnotations(dir string, clock Clock) (*Annotations, error) {
+	a := &Annotations{
+		clock: clock,
+		byID:  make(map[string]*Annotation),
+		byURL: make(map[tenantURL][]*Annotation),
+		count: make(map[string]int),
+	}
+	if dir == "" {
+		return a, nil
+	}
+	a.path = filepath.Join(dir, "annotations.gob")
+
+	var annotations []*Annotation
+	if err := loadGob(a.path, &annotations); err != nil {
+		return nil, err
+	}
+	for _, annotation := range annotations {
+		a.index(annotation)
+	}
+	return a, nil
+}
+
+func (a *Annotations) index(annotation *Annotation) {
+	a.byID[annotation.ID] = annotation
+	key := tenantURL{annotation.Tenant, annotation.URL}
+	a.byURL[key] = append(a.byURL[key], annotation)
+	a.count[annotation.Tenant]++
+}
+
+// Add stores annotation. It fails with errTooManyAnnotations once its tenant
+// has maxPageAnnotations on the page or maxAnnotations in all.
+func (a *Annotations) Add(annotation Annotation) (Annotation, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.byURL[tenantURL{annotation.Tenant, annotation.URL}]) >= maxPageAnnotations ||
+		a.count[annotation.Tenant] >= maxAnnotations {
+		return Annotation{}, errTooManyAnnotations
+	}
+	annotation.ID = newID()
+	annotation.CreatedAt = a.clock.Now()
+	a.index(&annotation)
+	return annotation, a.save()
+}
+
+// Delete removes the annotation id of tenant. The annotations of other
+// tenants are not found.
+func (a *Annotations) Delete(tenant, id string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	annotation, ok := a.byID[id]
+	if !ok || annotation.Tenant != tenant {
+		return false, nil
+	}
+	delete(a.byID, id)
+	key := tenantURL{annotation.Tenant, annotation.URL}
+	list := a.byURL[key]
+	for i, other := range list {
+		if other.ID == id {
+			a.byURL[key] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(a.byURL[key]) == 0 {
+		delete(a.byURL, key)
+	}
+	if a.count[tenant]--; a.count[tenant] == 0 {
+		delete(a.count, tenant)
+	}
+	return true, a.save()
+}
+
+// ForURL returns the annotations tenant made on a page ordered by position.
+func (a *Annotations) ForURL(tenant, url string) []Annotation {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	key := tenantURL{tenant, url}
+	annotations := make([]Annotation, 0, len(a.byURL[key]))
+	for _, annotation := range a.byURL[key] {
+		annotations = append(annotations, *annotation)
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Start < annotations[j].Start
+	})
+	return annotations
+}
+
+// save writes all annotations to disk. The caller must hold the lock.
+func (a *Annotations) save() error {
+	if a.path == "" {
+		return nil
+	}
+	annotations := make([]*Annotation, 0, len(a.byID))
+	for _, annotation := range a.byID {
+		annotations = append(annotations, annotation)
+	}
+	return saveGob(a.path, annotations)
+}
+
+type annotationRequest struct {
+	URL   string `json:"url"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Note  string `json:"note"`
+}
+
+func (srv *Server) handleAddAnnotation(w http.ResponseWriter, r *http.Request) {
+	var req annotationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	hostName, pageName, ok := parseTargetURL(req.URL)
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+
+	obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	text := []rune(readableText(obj))
+	if req.Start < 0 || req.End <= req.Start || req.End > len(text) {
+		http.Error(w, "offsets out of range", http.StatusBadRequest)
+		return
+	}
+
+	annotation, err := srv.annotations.Add(Annotation{
+		URL:    cacheKey(hostName, pageName),
+		Start:  req.Start,
+		End:    req.End,
+		Quote:  string(text[req.Start:req.End]),
+		Note:   req.Note,
+		Tenant: srv.storage.tenant(r.Context()).Name,
+	})
+	if errors.Is(err, errTooManyAnnotations) {
+		http.Error(w, fmt.Sprintf("at most %d annotations per page and %d in all", maxPageAnnotations, maxAnnotations), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error("failed to add annotation", "url", req.URL, "error", err)
+		http.Error(w, "failed to save annotation", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, annotation)
+}
+
+func (srv *Server) handleListAnnotations(w http.ResponseWriter, r *http.Request) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	tenant := srv.storage.tenant(r.Context()).Name
+	writeJSON(w, http.StatusOK, srv.annotations.ForURL(tenant, cacheKey(hostName, pageName)))
+}
+
+func (srv *Server) handleDeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	deleted, err := srv.annotations.Delete(srv.storage.tenant(r.Context()).Name, r.PathValue("id"))
+	if err != nil {
+		log.Error("failed to delete annotation", "id", r.PathValue("id"), "error", err)
+		http.Error(w, "failed to delete annotation", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationsLimit(t *testing.T) {
+	a, err := NewAnnotations("", newFakeClock())
+	if err != nil {
+		t.Fatalf("NewAnnotations: %v", err)
+	}
+	const url = "https://paulgraham.com/greatwork.html"
+	var first Annotation
+	for i := range maxPageAnnotations {
+		annotation, err := a.Add(Annotation{URL: url, Start: i, End: i + 1, Tenant: "a"})
+		if err != nil {
+			t.Fatalf("Add of annotation %d: %v", i, err)
+		}
+		if i == 0 {
+			first = annotation
+		}
+	}
+	if _, err := a.Add(Annotation{URL: url, Start: 0, End: 1, Tenant: "a"}); !errors.Is(err, errTooManyAnnotations) {
+		t.Fatalf("Add over the page limit = %v, want %v", err, errTooManyAnnotations)
+	}
+	if _, err := a.Add(Annotation{URL: url, Start: 0, End: 1, Tenant: "b"}); err != nil {
+		t.Errorf("the page limit of a held back b: %v", err)
+	}
+	if _, err := a.Add(Annotation{URL: url + "?2", Start: 0, End: 1, Tenant: "a"}); err != nil {
+		t.Errorf("the page limit held back another page: %v", err)
+	}
+	if deleted, _ := a.Delete("a", first.ID); !deleted {
+		t.Fatal("Delete of the first annotation failed")
+	}
+	if _, err := a.Add(Annotation{URL: url, Start: 0, End: 1, Tenant: "a"}); err != nil {
+		t.Errorf("Add after a delete: %v", err)
+	}
+
+	// the limit of all pages
+	a.count["c"] = maxAnnotations
+	if _, err := a.Add(Annotation{URL: url, Start: 0, End: 1, Tenant: "c"}); !errors.Is(err, errTooManyAnnotations) {
+		t.Errorf("Add over the tenant limit = %v, want %v", err, errTooManyAnnotations)
+	}
+}
+
+func TestAddAnnotation(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.txt": {ContentType: "text/plain", Body: "Déjà vu.\nWork hard."},
+	})
+	proxy := newTestProxy(t, origin, nil)
+	add := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return proxy.Serve(httptest.NewRequest(http.MethodPost, "/annotations", strings.NewReader(body)))
+	}
+	target := `"url": "` + origin.URL + `/essay.txt"`
+
+	proxy.clock.Advance(time.Hour)
+	rec := add(`{` + target + `, "start": 0, "end": 4, "note": "french"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /annotations = %d: %s", rec.Code, rec.Body)
+	}
+	var annotation Annotation
+	if err := json.Unmarshal(rec.Body.Bytes(), &annotation); err != nil {
+		t.Fatal(err)
+	}
+	// offsets count runes, not bytes
+	if annotation.Quote != "Déjà" {
+		t.Errorf("quote = %q, want %q", annotation.Quote, "Déjà")
+	}
+	if !annotation.CreatedAt.Equal(proxy.clock.Now()) {
+		t.Errorf("annotation created at %v, want %v", annotation.CreatedAt, proxy.clock.Now())
+	}
+
+	for name, body := range map[string]string{
+		"end before start":  `{` + target + `, "start": 3, "end": 2}`,
+		"end past the text": `{` + target + `, "start": 0, "end": 100}`,
+		"negative start":    `{` + target + `, "start": -1, "end": 2}`,
+		"body over the limit": `{` + target + `, "start": 0, "end": 4, "note": "` +
+			strings.Repeat("x", maxAnnotationBody) + `"}`,
+	} {
+		if rec := add(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /annotations with %s = %d, want 400", name, rec.Code)
+		}
+	}
+
+	rec = proxy.Serve(httptest.NewRequest(http.MethodGet, "/annotations?url="+origin.URL+"/essay.txt", nil))
+	var list []Annotation
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != annotation.ID {
+		t.Errorf("GET /annotations = %+v, want only the annotation added", list)
+	}
+}
+
+func TestReaderPageHighlights(t *testing.T) {
+	obj := Object{ContentType: "text/plain", Content: []byte("Déjà vu.\nWork hard.\n\nEnd")}
+	page := newReaderPage("https://example.com/a.txt", obj, []Annotation{
+		// spans the paragraph break
+		{Start: 5, End: 13, Note: "across"},
+		// ends past the text
+		{Start: 22, End: 100},
+	})
+	want := [][]readerSegment{
+		{{Text: "Déjà "}, {Text: "vu.", Mark: true, Note: "across"}},
+		{{Text: "Work", Mark: true, Note: "across"}, {Text: " hard."}},
+		{{Text: "E"}, {Text: "nd", Mark: true}},
+	}
+	if !reflect.DeepEqual(page.Paragraphs, want) {
+		t.Errorf("paragraphs = %+v, want %+v", page.Paragraphs, want)
+	}
+
+	// a later annotation wins where they overlap
+	page = newReaderPage("https://example.com/a.txt", obj, []Annotation{
+		{Start: 0, End: 8, Note: "first"},
+		{Start: 5, End: 7, Note: "second"},
+	})
+	want = [][]readerSegment{
+		{{Text: "Déjà ", Mark: true, Note: "first"}, {Text: "vu", Mark: true, Note: "second"}, {Text: ".", Mark: true, Note: "first"}},
+		{{Text: "Work hard."}},
+		{{Text: "End"}},
+	}
+	if !reflect.DeepEqual(page.Paragraphs, want) {
+		t.Errorf("overlapping paragraphs = %+v, want %+v", page.Paragraphs, want)
+	}
+}
@@ -0,0 +1,190 @@
+package blogproxy
+
+import (
+	"context"
+	log "log/slog"
+	"maps"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bandwidthSaveInterval is how often changed counts are persisted. A crash
+// forgets at most that much of the day's usage.
+const bandwidthSaveInterval = time.Minute
+
+var errBudgetExceeded error = &domainError{msg: "origin bandwidth budget exceeded", kind: ErrUnavailable}
+
+var originBytes = metrics.Counter(
+	"blogproxy_origin_fetched_bytes_total",
+	"Content bytes fetched from origins.",
+	"host",
+)
+
+// Bandwidth accounts the bytes fetched from every origin per UTC day and
+// enforces the daily budget. The counts are persisted periodically and on
+// shutdown so a restart does not hand out a fresh budget.
+type Bandwidth struct {
+	cfg BandwidthConfig
+	// path is the file the counts are persisted to, empty to keep them in
+	// memory only
+	path  string
+	clock Clock
+
+	// saving serializes writes of the file so an older snapshot never
+	// replaces a newer one
+	saving sync.Mutex
+
+	mu    sync.Mutex
+	usage bandwidthUsage
+	// dirty is set when usage changed since it was last saved
+	dirty bool
+}
+
+type bandwidthUsage struct {
+	// Day is the UTC date the counts are for, as "2006-01-02".
+	Day   string
+	Bytes map[string]int64
+}
+
+// HostBandwidth is the usage of one origin for the current day.
+type HostBandwidth struct {
+	HostName string `json:"host"`
+	Bytes    int64  `json:"bytes"`
+	// Budget is zero when the host is not limited.
+	Budget int64 `json:"budget,omitempty"`
+}
+
+// NewBandwidth loads the counts persisted in dir. An empty dir keeps them in
+// memory only.
+func NewBandwidth(cfg BandwidthConfig, dir string, clock Clock) (*Bandwidth, error) {
+	cfg.Hosts = canonicalHostKeys(cfg.Hosts)
+	b := &Bandwidth{cfg: cfg, clock: clock}
+	if dir != "" {
+		b.path = filepath.Join(dir, "bandwidth.gob")
+		if err := loadGob(b.path, &b.usage); err != nil {
+			return nil, err
+		}
+	}
+	b.rollover(b.clock.Now())
+	return b, nil
+}
+
+// Allow reports whether hostName still has budget left today.
+func (b *Bandwidth) Allow(hostName string) error {
+	budget := b.budget(hostName)
+	if budget <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(b.clock.Now())
+	if b.usage.Bytes[hostName] >= budget {
+		return errBudgetExceeded
+	}
+	return nil
+}
+
+// Record adds n fetched bytes to the usage of hostName.
+func (b *Bandwidth) Record(hostName string, n int64) {
+	originBytes.Add(float64(n), hostName)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(b.clock.Now())
+	b.usage.Bytes[hostName] += n
+	if budget := b.budget(hostName); budget > 0 && b.usage.Bytes[hostName] >= budget {
+		log.Warn("origin bandwidth budget exhausted", "host", hostName, "bytes", b.usage.Bytes[hostName], "budget", budget)
+	}
+	b.dirty = true
+}
+
+// Start saves the counts every bandwidthSaveInterval until ctx is done, and
+// once more then.
+func (b *Bandwidth) Start(ctx context.Context) {
+	if b.path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(bandwidthSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				b.logSave()
+				return
+			case <-ticker.C:
+				b.logSave()
+			}
+		}
+	}()
+}
+
+// Save persists the counts if they changed since they were last saved.
+func (b *Bandwidth) Save() error {
+	if b.path == "" {
+		return nil
+	}
+	b.saving.Lock()
+	defer b.saving.Unlock()
+
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	usage := bandwidthUsage{Day: b.usage.Day, Bytes: maps.Clone(b.usage.Bytes)}
+	b.dirty = false
+	b.mu.Unlock()
+
+	if err := saveGob(b.path, usage); err != nil {
+		b.mu.Lock()
+		b.dirty = true
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (b *Bandwidth) logSave() {
+	if err := b.Save(); err != nil {
+		log.Error("failed to save bandwidth usage", "error", err)
+	}
+}
+
+// Usage returns today's usage of every origin fetched from.
+func (b *Bandwidth) Usage() []HostBandwidth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(b.clock.Now())
+
+	usage := make([]HostBandwidth, 0, len(b.usage.Bytes))
+	for hostName, n := range b.usage.Bytes {
+		usage = append(usage, HostBandwidth{HostName: hostName, Bytes: n, Budget: b.budget(hostName)})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].HostName < usage[j].HostName })
+	return usage
+}
+
+// Reset returns when the current budget period ends.
+func (b *Bandwidth) Reset() time.Time {
+	now := b.clock.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func (b *Bandwidth) budget(hostName string) int64 {
+	if budget, ok := b.cfg.Hosts[hostName]; ok {
+		return budget
+	}
+	return b.cfg.DailyBytes
+}
+
+// rollover starts a new day of counts once the day of now has begun.
+func (b *Bandwidth) rollover(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if b.usage.Day != day || b.usage.Bytes == nil {
+		b.usage = bandwidthUsage{Day: day, Bytes: make(map[string]int64)}
+	}
+}
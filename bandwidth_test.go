@@ -0,0 +1,124 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFakeClock()
+	cfg := BandwidthConfig{DailyBytes: 100, Hosts: map[string]int64{"big.example": 1000}}
+	bandwidth, err := NewBandwidth(cfg, dir, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bandwidth.Record("small.example", 60)
+	bandwidth.Record("big.example", 600)
+	if err := bandwidth.Allow("small.example"); err != nil {
+		t.Fatalf("Allow under budget = %v", err)
+	}
+	bandwidth.Record("small.example", 40)
+	if err := bandwidth.Allow("small.example"); !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("Allow at budget = %v, want %v", err, errBudgetExceeded)
+	}
+	if err := bandwidth.Allow("big.example"); err != nil {
+		t.Fatalf("Allow under the budget of the host = %v", err)
+	}
+
+	want := []HostBandwidth{
+		{HostName: "big.example", Bytes: 600, Budget: 1000},
+		{HostName: "small.example", Bytes: 100, Budget: 100},
+	}
+	if got := bandwidth.Usage(); !equalUsage(got, want) {
+		t.Fatalf("Usage() = %v, want %v", got, want)
+	}
+
+	// fetches don't write the file, saves do
+	path := filepath.Join(dir, "bandwidth.gob")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("usage written before Save: %v", err)
+	}
+	if err := bandwidth.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewBandwidth(cfg, dir, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Usage(); !equalUsage(got, want) {
+		t.Fatalf("Usage() after reload = %v, want %v", got, want)
+	}
+	if err := reloaded.Allow("small.example"); !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("Allow after reload = %v, want %v", err, errBudgetExceeded)
+	}
+
+	if got, want := bandwidth.Reset(), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("Reset() = %v, want %v", got, want)
+	}
+	clock.Advance(24 * time.Hour)
+	if err := bandwidth.Allow("small.example"); err != nil {
+		t.Fatalf("Allow the next day = %v", err)
+	}
+	if got := bandwidth.Usage(); len(got) != 0 {
+		t.Fatalf("Usage() the next day = %v, want none", got)
+	}
+}
+
+func TestBandwidthStats(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/other.html": {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.Bandwidth.DailyBytes = int64(len(essay))
+	})
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		// the cached page is still served over budget
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{
+			Path:               "/other.html",
+			WantStatus:         http.StatusServiceUnavailable,
+			WantHeader:         map[string]string{"Retry-After": "86401"},
+			WantOriginRequests: 0,
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := proxy.Serve(req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /stats = %d", rec.Code)
+	}
+	var stats Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Bandwidth) != 1 {
+		t.Fatalf("stats bandwidth = %v, want one origin", stats.Bandwidth)
+	}
+	if got := stats.Bandwidth[0]; got.Bytes != int64(len(essay)) || got.Budget != int64(len(essay)) {
+		t.Errorf("stats bandwidth = %+v, want %d bytes of a budget of %d", got, len(essay), len(essay))
+	}
+}
+
+func equalUsage(got, want []HostBandwidth) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
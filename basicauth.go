@@ -0,0 +1,181 @@
+package blogproxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var basicAuthFailures = metrics.Counter(
+	"blogproxy_basic_auth_failures_total",
+	"Rejected basic auth attempts.",
+	"reason",
+)
+
+// maxVerified bounds the credentials remembered as verified, so bcrypt only
+// runs on a client's first request.
+const maxVerified = 1024
+
+// BasicAuth protects the proxy routes with HTTP Basic auth against bcrypt
+// hashed passwords. Clients failing too often are locked out for a while
+// without their attempts being checked at all.
+type BasicAuth struct {
+	cfg   BasicAuthConfig
+	clock Clock
+	users map[string][]byte
+	// dummy is compared against for unknown users, so they take as long to
+	// reject as a wrong password does
+	dummy []byte
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+	failures map[netip.Addr]*authFailures
+}
+
+type authFailures struct {
+	count int
+	since time.Time
+}
+
+// NewBasicAuth returns nil when no users are configured.
+func NewBasicAuth(cfg BasicAuthConfig, clock Clock) (*BasicAuth, error) {
+	if len(cfg.Users) == 0 {
+		return nil, nil
+	}
+	users := make(map[string][]byte, len(cfg.Users))
+	for user, hash := range cfg.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid password hash of %q: %w", user, err)
+		}
+		users[user] = []byte(hash)
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &BasicAuth{
+		cfg:      cfg,
+		clock:    clock,
+		users:    users,
+		dummy:    dummy,
+		verified: make(map[[sha256.Size]byte]struct{}),
+		failures: make(map[netip.Addr]*authFailures),
+	}, nil
+}
+
+// Middleware asks for credentials on every route but the ones exempt from
+// authentication. It reads the client address, so it goes after
+// TrustedProxies.Middleware.
+func (a *BasicAuth) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ClientIP(r.Context())
+			if wait := a.lockedOut(client); wait > 0 {
+				basicAuthFailures.Inc("locked_out")
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			user, password, ok := r.BasicAuth()
+			if !ok || !a.verify(user, password) {
+				reason := "invalid"
+				if !ok {
+					reason = "missing"
+				} else {
+					a.fail(client)
+					log.Warn("basic auth failed", "user", user, "client", client, "path", r.URL.Path)
+				}
+				basicAuthFailures.Inc(reason)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.cfg.Realm))
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authExempt reports whether path is left alone by the proxy
+// authentication: the health check, the admin routes, which are guarded by
+// the admin credentials, and the peer routes, which have their own secret.
+func authExempt(path string) bool {
+	return path == "/health" || isAdminPath(path) || strings.HasPrefix(path, "/_peer/")
+}
+
+func (a *BasicAuth) verify(user, password string) bool {
+	digest := sha256.Sum256([]byte(user + "\x00" + password))
+	a.mu.Lock()
+	_, ok := a.verified[digest]
+	a.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	hash, known := a.users[user]
+	if !known {
+		hash = a.dummy
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !known {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.verified) >= maxVerified {
+		clear(a.verified)
+	}
+	a.verified[digest] = struct{}{}
+	return true
+}
+
+// lockedOut returns how long client still has to wait before its
+// credentials are checked again.
+func (a *BasicAuth) lockedOut(client netip.Addr) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failures[client]
+	if !ok || a.cfg.MaxFailures <= 0 || f.count < a.cfg.MaxFailures {
+		return 0
+	}
+	return f.since.Add(time.Duration(a.cfg.LockoutWindow)).Sub(a.clock.Now())
+}
+
+// fail counts a failed attempt of client within the lockout window.
+func (a *BasicAuth) fail(client netip.Addr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+	window := time.Duration(a.cfg.LockoutWindow)
+	for addr, f := range a.failures {
+		if now.Sub(f.since) > window {
+			delete(a.failures, addr)
+		}
+	}
+	f, ok := a.failures[client]
+	if !ok {
+		f = &authFailures{since: now}
+		a.failures[client] = f
+	}
+	f.count++
+	if f.count == a.cfg.MaxFailures {
+		log.Warn("client locked out after failed basic auth", "client", client, "failures", f.count)
+	}
+}
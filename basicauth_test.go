@@ -0,0 +1,50 @@
+package blogproxy
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthLockout(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	auth, err := NewBasicAuth(BasicAuthConfig{
+		Users:         map[string]string{"reader": string(hash)},
+		MaxFailures:   2,
+		LockoutWindow: Duration(time.Minute),
+	}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := netip.MustParseAddr("192.0.2.1")
+
+	auth.fail(client)
+	if wait := auth.lockedOut(client); wait != 0 {
+		t.Fatalf("locked out after one failure for %v", wait)
+	}
+	auth.fail(client)
+	if wait := auth.lockedOut(client); wait != time.Minute {
+		t.Fatalf("locked out for %v after two failures, want %v", wait, time.Minute)
+	}
+
+	clock.Advance(40 * time.Second)
+	if wait := auth.lockedOut(client); wait != 20*time.Second {
+		t.Fatalf("locked out for %v, want %v", wait, 20*time.Second)
+	}
+	clock.Advance(20 * time.Second)
+	if wait := auth.lockedOut(client); wait > 0 {
+		t.Fatalf("still locked out for %v after the window", wait)
+	}
+
+	clock.Advance(time.Second)
+	auth.fail(client)
+	if wait := auth.lockedOut(client); wait != 0 {
+		t.Fatalf("failures before the window counted again, locked out for %v", wait)
+	}
+}
@@ -0,0 +1,263 @@
+package blogproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxBatchBody bounds the request body of /api/prefetch.
+const maxBatchBody = 1 << 20
+
+// Statuses of the urls of a batch prefetch job.
+const (
+	batchPending  = "pending"
+	batchFetching = "fetching"
+	batchDone     = "done"
+	batchFailed   = "failed"
+)
+
+// BatchPrefetcher runs the jobs of /api/prefetch: lists of urls fetched into
+// the cache in the background. The fetches of all jobs share a concurrency
+// limit and are spaced out per origin.
+type BatchPrefetcher struct {
+	storage   *Storage
+	jobs      *Jobs
+	hostDelay time.Duration
+	maxURLs   int
+	retention time.Duration
+	slots     chan struct{}
+
+	mu      sync.Mutex
+	batches map[string]*batchJob
+	// next is when each origin may be fetched from again
+	next map[string]time.Time
+}
+
+type batchJob struct {
+	ID         string      `json:"id"`
+	State      string      `json:"state"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	URLs       []*batchURL `json:"urls"`
+
+	tenant *Tenant
+}
+
+type batchURL struct {
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Size   int    `json:"size,omitempty"`
+
+	hostName string
+	pageName string
+}
+
+func NewBatchPrefetcher(storage *Storage, cfg BatchPrefetchConfig, jobs *Jobs) *BatchPrefetcher {
+	return &BatchPrefetcher{
+		storage:   storage,
+		jobs:      jobs,
+		hostDelay: time.Duration(cfg.HostDelay),
+		maxURLs:   cfg.MaxURLs,
+		retention: time.Duration(cfg.Retention),
+		slots:     make(chan struct{}, max(cfg.Concurrency, 1)),
+		batches:   make(map[string]*batchJob),
+		next:      make(map[string]time.Time),
+	}
+}
+
+// Start creates a job fetching urls for tenant and runs it in the
+// background, under the id of its entry in /admin/jobs. Invalid urls fail
+// right away.
+func (b *BatchPrefetcher) Start(tenant *Tenant, urls []string) *batchJob {
+	batch := &batchJob{
+		State:     "running",
+		CreatedAt: b.storage.clock.Now(),
+		URLs:      make([]*batchURL, 0, len(urls)),
+		tenant:    tenant,
+	}
+	byHost := make(map[string][]*batchURL)
+	invalid := 0
+	for _, u := range urls {
+		entry := &batchURL{URL: u, Status: batchPending}
+		hostName, pageName, ok := parseTargetURL(u)
+		if ok {
+			entry.hostName, entry.pageName = hostName, pageName
+			byHost[hostName] = append(byHost[hostName], entry)
+		} else {
+			entry.Status, entry.Error = batchFailed, "invalid url"
+			invalid++
+		}
+		batch.URLs = append(batch.URLs, entry)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	ctx := withTenant(context.Background(), tenant)
+	job := b.jobs.Go(ctx, "prefetch", func(ctx context.Context, job *Job) error {
+		job.SetTotal(len(urls))
+		job.Advance(invalid)
+
+		// one goroutine per origin keeps the fetches from it in sequence
+		var wg sync.WaitGroup
+		for hostName, entries := range byHost {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.run(ctx, job, hostName, entries)
+			}()
+		}
+		wg.Wait()
+
+		b.mu.Lock()
+		b.finish(batch)
+		b.mu.Unlock()
+		return ctx.Err()
+	})
+	batch.ID = job.ID
+	b.batches[batch.ID] = batch
+	log.Info("started batch prefetch", "job", batch.ID, "tenant", tenant.Name, "urls", len(urls), "hosts", len(byHost))
+	return batch
+}
+
+// run fetches entries from hostName in sequence. Once ctx is done, the
+// entries left fail without being fetched.
+func (b *BatchPrefetcher) run(ctx context.Context, job *Job, hostName string, entries []*batchURL) {
+	for _, entry := range entries {
+		err := b.waitForHost(ctx, hostName)
+		if err == nil {
+			err = b.fetch(ctx, entry)
+		}
+
+		b.mu.Lock()
+		if err != nil {
+			entry.Status, entry.Error = batchFailed, err.Error()
+		}
+		b.mu.Unlock()
+		job.Advance(1)
+	}
+}
+
+func (b *BatchPrefetcher) fetch(ctx context.Context, entry *batchURL) error {
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-b.slots }()
+
+	b.mu.Lock()
+	entry.Status = batchFetching
+	b.mu.Unlock()
+	obj, err := b.storage.Get(ctx, entry.hostName, entry.pageName)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	entry.Status, entry.Size = batchDone, len(obj.Content)
+	b.mu.Unlock()
+	return nil
+}
+
+// waitForHost blocks until hostName may be fetched from, reserving the
+// next slot for the caller, or until ctx is done.
+func (b *BatchPrefetcher) waitForHost(ctx context.Context, hostName string) error {
+	b.mu.Lock()
+	now := b.storage.clock.Now()
+	at := b.next[hostName]
+	if at.Before(now) {
+		at = now
+	}
+	b.next[hostName] = at.Add(b.hostDelay)
+	b.mu.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish marks job done. b.mu must be held.
+func (b *BatchPrefetcher) finish(job *batchJob) {
+	now := b.storage.clock.Now()
+	job.State = "done"
+	job.FinishedAt = &now
+	log.Info("finished batch prefetch", "job", job.ID)
+}
+
+// prune forgets the jobs finished longer than the retention ago, and the
+// origins not fetched from for as long. b.mu must be held.
+func (b *BatchPrefetcher) prune() {
+	now := b.storage.clock.Now()
+	for id, batch := range b.batches {
+		if batch.FinishedAt != nil && now.Sub(*batch.FinishedAt) > b.retention {
+			delete(b.batches, id)
+		}
+	}
+	for hostName, at := range b.next {
+		if now.Sub(at) > b.retention {
+			delete(b.next, hostName)
+		}
+	}
+}
+
+// Job returns a copy of the job with id, if it was started by tenant.
+func (b *BatchPrefetcher) Job(tenant *Tenant, id string) (batchJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.batches[id]
+	if !ok || job.tenant != tenant {
+		return batchJob{}, false
+	}
+	snapshot := *job
+	snapshot.URLs = make([]*batchURL, len(job.URLs))
+	for i, entry := range job.URLs {
+		copied := *entry
+		snapshot.URLs[i] = &copied
+	}
+	return snapshot, true
+}
+
+type batchStartResponse struct {
+	ID   string `json:"id"`
+	URLs int    `json:"urls"`
+}
+
+func (srv *Server) handleStartPrefetch(w http.ResponseWriter, r *http.Request) {
+	var urls []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&urls); err != nil {
+		http.Error(w, "expected a json array of urls", http.StatusBadRequest)
+		return
+	}
+	if len(urls) == 0 {
+		http.Error(w, "no urls", http.StatusBadRequest)
+		return
+	}
+	if limit := srv.batchPrefetcher.maxURLs; limit > 0 && len(urls) > limit {
+		http.Error(w, fmt.Sprintf("too many urls, at most %d", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	job := srv.batchPrefetcher.Start(srv.storage.tenant(r.Context()), urls)
+	w.Header().Set("Location", "/api/prefetch/"+job.ID)
+	writeJSON(w, http.StatusAccepted, batchStartResponse{ID: job.ID, URLs: len(urls)})
+}
+
+func (srv *Server) handleGetPrefetch(w http.ResponseWriter, r *http.Request) {
+	job, ok := srv.batchPrefetcher.Job(srv.storage.tenant(r.Context()), r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
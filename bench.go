@@ -0,0 +1,331 @@
+package blogproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchResult is what one request of a bench run measured.
+type benchResult struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// cacheCounts are the cache lookups counted by the metrics of the proxy.
+type cacheCounts struct {
+	memory, disk, misses float64
+}
+
+func (c cacheCounts) sub(o cacheCounts) cacheCounts {
+	return cacheCounts{memory: c.memory - o.memory, disk: c.disk - o.disk, misses: c.misses - o.misses}
+}
+
+// benchTarget is where a bench run sends its requests.
+type benchTarget interface {
+	Get(ctx context.Context, target string) (status int, err error)
+	CacheCounts(ctx context.Context) (cacheCounts, error)
+}
+
+// RunBench implements the bench command: it replays the URLs of a list
+// against a running proxy, or the proxy built from the config in process,
+// at a target rate and reports latency percentiles and the cache hit ratio.
+func RunBench(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	urls := flags.String("urls", "", "file listing the urls to request, one per line")
+	addr := flags.String("target", "", "base url of a running proxy, e.g. http://localhost:9080; empty to bench in process")
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "config of the in-process proxy")
+	apiKey := flags.String("api-key", "", "X-API-Key sent with every request")
+	adminToken := flags.String("admin-token", "", "admin token of the running proxy, to read its /metrics")
+	rps := flags.Float64("rps", 50, "requests per second")
+	duration := flags.Duration("duration", 30*time.Second, "length of the run")
+	concurrency := flags.Int("concurrency", 16, "maximum requests in flight")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *urls == "" || *rps <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(stdout, "bench needs -urls, a positive -rps and a positive -concurrency")
+		return 2
+	}
+
+	targets, err := readURLList(*urls)
+	if err != nil {
+		fmt.Fprintln(stdout, "error:", err)
+		return 1
+	}
+
+	var target benchTarget
+	if *addr != "" {
+		target = &remoteBenchTarget{base: strings.TrimRight(*addr, "/"), apiKey: *apiKey, adminToken: *adminToken, client: &http.Client{Timeout: time.Minute}}
+	} else {
+		target, err = newLocalBenchTarget(*path, *apiKey)
+		if err != nil {
+			fmt.Fprintln(stdout, "error:", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	before, countErr := target.CacheCounts(ctx)
+	results, dropped := bench(ctx, target, targets, *rps, *duration, *concurrency)
+	var counts cacheCounts
+	if countErr == nil {
+		var after cacheCounts
+		after, countErr = target.CacheCounts(ctx)
+		counts = after.sub(before)
+	}
+
+	writeBenchReport(stdout, results, dropped, *duration, counts, countErr)
+	return 0
+}
+
+// readURLList reads the urls of path, skipping blank lines and # comments.
+func readURLList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no urls in %s", path)
+	}
+	return urls, nil
+}
+
+// bench sends requests for urls, cycling through the list, at rps until
+// duration has passed. Requests due while concurrency requests are already
+// in flight are dropped rather than delayed, so a slow proxy shows up as
+// drops instead of as a lower rate.
+func bench(ctx context.Context, target benchTarget, urls []string, rps float64, duration time.Duration, concurrency int) (results []benchResult, dropped int) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	for i := 0; ; i++ {
+		select {
+		case <-deadline:
+			wg.Wait()
+			return results, dropped
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			dropped++
+			continue
+		}
+
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			status, err := target.Get(ctx, u)
+			result := benchResult{status: status, latency: time.Since(start), err: err}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(urls[i%len(urls)])
+	}
+}
+
+func writeBenchReport(w io.Writer, results []benchResult, dropped int, duration time.Duration, counts cacheCounts, countErr error) {
+	fmt.Fprintf(w, "requests: %d (%.1f/s), dropped: %d\n", len(results), float64(len(results))/duration.Seconds(), dropped)
+
+	writeStatuses(w, results)
+	latencies := make([]time.Duration, 0, len(results))
+	for _, result := range results {
+		if result.err == nil {
+			latencies = append(latencies, result.latency)
+		}
+	}
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		fmt.Fprintf(w, "latency: p50=%s p90=%s p99=%s max=%s\n",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+	}
+
+	if countErr != nil {
+		fmt.Fprintln(w, "cache: hit ratio unavailable:", countErr)
+		return
+	}
+	hits := counts.memory + counts.disk
+	if lookups := hits + counts.misses; lookups > 0 {
+		fmt.Fprintf(w, "cache: %.0f hits (memory %.0f, disk %.0f), %.0f misses, hit ratio %.1f%%\n",
+			hits, counts.memory, counts.disk, counts.misses, 100*hits/lookups)
+	} else {
+		fmt.Fprintln(w, "cache: no lookups")
+	}
+}
+
+// writeStatuses writes the count of every response status of results, and
+// of the requests that failed.
+func writeStatuses(w io.Writer, results []benchResult) {
+	statuses := make(map[int]int)
+	var errs int
+	for _, result := range results {
+		if result.err != nil {
+			errs++
+			continue
+		}
+		statuses[result.status]++
+	}
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d=%d", code, statuses[code]))
+	}
+	fmt.Fprintf(w, "status: %s, errors: %d\n", strings.Join(parts, " "), errs)
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1].Round(time.Microsecond)
+}
+
+// parseCacheCounts reads the cache lookup counters from the text exposition
+// of the metrics. Counters never incremented are absent and read as zero.
+func parseCacheCounts(r io.Reader) (cacheCounts, error) {
+	var counts cacheCounts
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		series, value, ok := strings.Cut(line, " ")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch series {
+		case `blogproxy_cache_hits_total{tier="memory"}`:
+			counts.memory = v
+		case `blogproxy_cache_hits_total{tier="disk"}`:
+			counts.disk = v
+		case "blogproxy_cache_misses_total":
+			counts.misses = v
+		}
+	}
+	return counts, scanner.Err()
+}
+
+// remoteBenchTarget is a running proxy.
+type remoteBenchTarget struct {
+	base       string
+	apiKey     string
+	adminToken string
+	client     *http.Client
+}
+
+func (t *remoteBenchTarget) Get(ctx context.Context, target string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/?url="+url.QueryEscape(target), nil)
+	if err != nil {
+		return 0, err
+	}
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, err
+}
+
+func (t *remoteBenchTarget) CacheCounts(ctx context.Context) (cacheCounts, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/metrics", nil)
+	if err != nil {
+		return cacheCounts{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.adminToken)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return cacheCounts{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cacheCounts{}, errors.New("/metrics responded with " + resp.Status)
+	}
+	return parseCacheCounts(resp.Body)
+}
+
+// localBenchTarget is a proxy built in this process, benched without the
+// network in between.
+type localBenchTarget struct {
+	handler http.Handler
+	apiKey  string
+}
+
+func newLocalBenchTarget(path, apiKey string) (*localBenchTarget, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := NewStorage(context.Background(), WithConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage: %w", err)
+	}
+	srv, err := NewServer(cfg, storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+	return &localBenchTarget{handler: srv.Handler(), apiKey: apiKey}, nil
+}
+
+func (t *localBenchTarget) Get(ctx context.Context, target string) (int, error) {
+	req := httptest.NewRequest(http.MethodGet, "/?url="+url.QueryEscape(target), nil).WithContext(ctx)
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	return rec.Code, nil
+}
+
+func (t *localBenchTarget) CacheCounts(ctx context.Context) (cacheCounts, error) {
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	return parseCacheCounts(&buf)
+}
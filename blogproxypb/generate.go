@@ -0,0 +1,4 @@
+// Package blogproxypb holds the generated gRPC API of the proxy.
+package blogproxypb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative blogproxypb/blogproxy.proto
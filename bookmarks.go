@@ -0,0 +1,272 @@
+package blogproxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBookmarks bounds the reading list of every tenant.
+	maxBookmarks = 1000
+	// maxBookmarkBody bounds the request body of POST /bookmarks.
+	maxBookmarkBody = 64 << 10
+)
+
+var errTooManyBookmarks = errors.New("too many bookmarks")
+
+// Bookmark is a page saved to the reading list, together with the snapshot
+// taken when it was bookmarked.
+type Bookmark struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	HostName  string    `json:"host"`
+	PageName  string    `json:"page"`
+	Title     string    `json:"title,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Snapshot  Object    `json:"-"`
+	// Tenant is the name of the tenant whose reading list the bookmark is
+	// on, empty for the default tenant.
+	Tenant string `json:"-"`
+}
+
+// tenantURL identifies a page in the records of one tenant.
+type tenantURL struct {
+	tenant string
+	url    string
+}
+
+// Bookmarks is the reading list, one per tenant. Every bookmark pins its
+// snapshot: it is kept outside the cache, so it is never evicted, and
+// Storage falls back to it when the origin no longer has the page for the
+// tenant.
+type Bookmarks struct {
+	// path is the file the bookmarks are persisted to, empty to keep them in
+	// memory only
+	path  string
+	clock Clock
+
+	mu    sync.RWMutex
+	byID  map[string]*Bookmark
+	byURL map[tenantURL]*Bookmark
+	// count is the number of bookmarks of every tenant
+	count map[string]int
+}
+
+// NewBookmarks loads the bookmarks persisted in dir. An empty dir keeps the
+// bookmarks in memory only.
+func NewBookmarks(dir string, clock Clock) (*Bookmarks, error) {
+	b := &Bookmarks{
+		clock: clock,
+		byID:  make(map[string]*Bookmark),
+		byURL: make(map[tenantURL]*Bookmark),
+		count: make(map[string]int),
+	}
+	if dir == "" {
+		return b, nil
+	}
+	b.path = filepath.Join(dir, "bookmarks.gob")
+
+	var bookmarks []*Bookmark
+	if err := loadGob(b.path, &bookmarks); err != nil {
+		return nil, err
+	}
+	for _, bookmark := range bookmarks {
+		b.byID[bookmark.ID] = bookmark
+		b.byURL[tenantURL{bookmark.Tenant, bookmark.URL}] = bookmark
+		b.count[bookmark.Tenant]++
+	}
+	return b, nil
+}
+
+// Add bookmarks pageName on hostName for tenant with obj as its snapshot.
+// Bookmarking a page again refreshes the snapshot. A new bookmark fails
+// with errTooManyBookmarks once tenant has maxBookmarks.
+func (b *Bookmarks) Add(tenant, hostName, pageName string, obj Object) (Bookmark, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := tenantURL{tenant, cacheKey(hostName, pageName)}
+	bookmark, ok := b.byURL[key]
+	if !ok {
+		if b.count[tenant] >= maxBookmarks {
+			return Bookmark{}, errTooManyBookmarks
+		}
+		bookmark = &Bookmark{
+			ID:        newID(),
+			URL:       key.url,
+			HostName:  hostName,
+			PageName:  pageName,
+			CreatedAt: b.clock.Now(),
+			Tenant:    tenant,
+		}
+		b.count[tenant]++
+	}
+	bookmark.Snapshot = obj
+	if isHTML(obj.ContentType) {
+		bookmark.Title = extractTitle(obj.Content)
+	}
+
+	b.byID[bookmark.ID] = bookmark
+	b.byURL[key] = bookmark
+	if err := b.save(); err != nil {
+		return Bookmark{}, err
+	}
+	return *bookmark, nil
+}
+
+// Delete removes the bookmark id of tenant. The bookmarks of other tenants
+// are not found.
+func (b *Bookmarks) Delete(tenant, id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bookmark, ok := b.byID[id]
+	if !ok || bookmark.Tenant != tenant {
+		return false, nil
+	}
+	delete(b.byID, id)
+	delete(b.byURL, tenantURL{bookmark.Tenant, bookmark.URL})
+	if b.count[tenant]--; b.count[tenant] == 0 {
+		delete(b.count, tenant)
+	}
+	return true, b.save()
+}
+
+func (b *Bookmarks) Get(tenant, id string) (Bookmark, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bookmark, ok := b.byID[id]
+	if !ok || bookmark.Tenant != tenant {
+		return Bookmark{}, false
+	}
+	return *bookmark, true
+}
+
+// List returns the bookmarks of tenant, newest first.
+func (b *Bookmarks) List(tenant string) []Bookmark {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bookmarks := make([]Bookmark, 0)
+	for _, bookmark := range b.byID {
+		if bookmark.Tenant == tenant {
+			bookmarks = append(bookmarks, *bookmark)
+		}
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].CreatedAt.After(bookmarks[j].CreatedAt)
+	})
+	return bookmarks
+}
+
+// Pinned returns the snapshot tenant pinned for pageName on hostName.
+func (b *Bookmarks) Pinned(tenant, hostName, pageName string) (Object, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bookmark, ok := b.byURL[tenantURL{tenant, cacheKey(hostName, pageName)}]
+	if !ok {
+		return Object{}, false
+	}
+	return bookmark.Snapshot, true
+}
+
+// save writes all bookmarks to disk. The caller must hold the lock.
+func (b *Bookmarks) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	bookmarks := make([]*Bookmark, 0, len(b.byID))
+	for _, bookmark := range b.byID {
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return saveGob(b.path, bookmarks)
+}
+
+func newID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type bookmarkRequest struct {
+	URL string `json:"url"`
+}
+
+func (srv *Server) handleAddBookmark(w http.ResponseWriter, r *http.Request) {
+	var req bookmarkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBookmarkBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	hostName, pageName, ok := parseTargetURL(req.URL)
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+
+	obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	tenant := srv.storage.tenant(r.Context()).Name
+	bookmark, err := srv.storage.bookmarks.Add(tenant, hostName, pageName, obj)
+	if errors.Is(err, errTooManyBookmarks) {
+		http.Error(w, fmt.Sprintf("at most %d bookmarks", maxBookmarks), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error("failed to add bookmark", "url", req.URL, "error", err)
+		http.Error(w, "failed to save bookmark", http.StatusInternalServerError)
+		return
+	}
+	log.Info("bookmark added", "id", bookmark.ID, "url", bookmark.URL, "tenant", tenant)
+	writeJSON(w, http.StatusCreated, bookmark)
+}
+
+func (srv *Server) handleListBookmarks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.storage.bookmarks.List(srv.storage.tenant(r.Context()).Name))
+}
+
+// handleGetBookmark serves the pinned snapshot of a bookmark.
+func (srv *Server) handleGetBookmark(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := srv.storage.bookmarks.Get(srv.storage.tenant(r.Context()).Name, r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	obj, err := bookmark.Snapshot.Decoded()
+	if err != nil {
+		log.Error("failed to decode bookmark", "id", bookmark.ID, "error", err)
+		http.Error(w, "failed to decode bookmark", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("ETag", obj.Etag)
+	http.ServeContent(w, r, bookmark.PageName, obj.ModTime(), bytes.NewReader(obj.Content))
+}
+
+func (srv *Server) handleDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	deleted, err := srv.storage.bookmarks.Delete(srv.storage.tenant(r.Context()).Name, r.PathValue("id"))
+	if err != nil {
+		log.Error("failed to delete bookmark", "id", r.PathValue("id"), "error", err)
+		http.Error(w, "failed to delete bookmark", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookmarksPerTenant(t *testing.T) {
+	b, err := NewBookmarks("", newFakeClock())
+	if err != nil {
+		t.Fatalf("NewBookmarks: %v", err)
+	}
+	const hostName, pageName = "https://paulgraham.com", "greatwork.html"
+	mine, err := b.Add("a", hostName, pageName, Object{Etag: "a"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := b.Add("b", hostName, pageName, Object{Etag: "b"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if pinned, ok := b.Pinned("a", hostName, pageName); !ok || pinned.Etag != "a" {
+		t.Errorf("Pinned for a = %q, %v, want the snapshot of a", pinned.Etag, ok)
+	}
+	if _, ok := b.Pinned("", hostName, pageName); ok {
+		t.Error("the default tenant got the snapshot pinned by another tenant")
+	}
+	if got := b.List("b"); len(got) != 1 || got[0].Snapshot.Etag != "b" {
+		t.Errorf("List for b = %+v, want only the bookmark of b", got)
+	}
+	if _, ok := b.Get("b", mine.ID); ok {
+		t.Error("b got the bookmark of a")
+	}
+	if deleted, _ := b.Delete("b", mine.ID); deleted {
+		t.Error("b deleted the bookmark of a")
+	}
+	if deleted, _ := b.Delete("a", mine.ID); !deleted {
+		t.Error("a could not delete its own bookmark")
+	}
+}
+
+func TestBookmarksLimit(t *testing.T) {
+	b, err := NewBookmarks("", newFakeClock())
+	if err != nil {
+		t.Fatalf("NewBookmarks: %v", err)
+	}
+	const hostName = "https://paulgraham.com"
+	var first Bookmark
+	for i := range maxBookmarks {
+		bookmark, err := b.Add("a", hostName, fmt.Sprintf("%d.html", i), Object{})
+		if err != nil {
+			t.Fatalf("Add of bookmark %d: %v", i, err)
+		}
+		if i == 0 {
+			first = bookmark
+		}
+	}
+	if _, err := b.Add("a", hostName, "one-more.html", Object{}); !errors.Is(err, errTooManyBookmarks) {
+		t.Fatalf("Add over the limit = %v, want %v", err, errTooManyBookmarks)
+	}
+	// refreshing a bookmark adds none
+	if _, err := b.Add("a", hostName, "0.html", Object{Etag: "new"}); err != nil {
+		t.Errorf("Add of a page bookmarked already: %v", err)
+	}
+	if _, err := b.Add("b", hostName, "one-more.html", Object{}); err != nil {
+		t.Errorf("the limit of a held back b: %v", err)
+	}
+	if deleted, _ := b.Delete("a", first.ID); !deleted {
+		t.Fatal("Delete of the first bookmark failed")
+	}
+	if _, err := b.Add("a", hostName, "one-more.html", Object{}); err != nil {
+		t.Errorf("Add after a delete: %v", err)
+	}
+}
+
+func TestAddBookmark(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/other.html": {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, nil)
+	add := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return proxy.Serve(httptest.NewRequest(http.MethodPost, "/bookmarks", strings.NewReader(body)))
+	}
+
+	rec := add(`{"url": "` + origin.URL + `/essay.html"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /bookmarks = %d: %s", rec.Code, rec.Body)
+	}
+	var bookmark Bookmark
+	if err := json.Unmarshal(rec.Body.Bytes(), &bookmark); err != nil {
+		t.Fatal(err)
+	}
+	if !bookmark.CreatedAt.Equal(proxy.clock.Now()) {
+		t.Errorf("bookmark created at %v, want %v", bookmark.CreatedAt, proxy.clock.Now())
+	}
+
+	proxy.clock.Advance(time.Minute)
+	if rec := add(`{"url": "` + origin.URL + `/other.html"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /bookmarks = %d: %s", rec.Code, rec.Body)
+	}
+	list := proxy.storage.bookmarks.List("test")
+	if len(list) != 2 || list[0].PageName != "other.html" {
+		t.Errorf("bookmarks = %+v, want the newest first", list)
+	}
+
+	padding := strings.Repeat(" ", maxBookmarkBody)
+	if rec := add(`{"url": "` + origin.URL + `/essay.html"` + padding + `}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /bookmarks with a body over the limit = %d, want 400", rec.Code)
+	}
+}
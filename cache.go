@@ -0,0 +1,275 @@
+package blogproxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Object struct {
+	Etag        string
+	ContentType string
+	Content     []byte
+	// ContentEncoding is the coding Content is compressed with, as sent by
+	// the origin, e.g. "gzip". It is empty for uncompressed content.
+	ContentEncoding string
+	UpdateTime      time.Time
+	ExpiryTime      time.Time
+	// LastModified is the Last-Modified date of the origin, zero when it
+	// sent none.
+	LastModified time.Time
+	// Digest is the hash of Content as stored, "sha256:<hex>", checked when
+	// the object is read back from disk. It is empty when digests are off.
+	Digest string
+	// FetchDuration is how long fetching the object from its origin took.
+	FetchDuration time.Duration
+	// SoftPurged marks an object expired by a soft purge. It is still
+	// served while it is fetched again, and if fetching it fails.
+	SoftPurged bool
+}
+
+// ModTime returns when the content last changed: its Last-Modified date
+// at the origin, or else when it was fetched.
+func (o Object) ModTime() time.Time {
+	if !o.LastModified.IsZero() {
+		return o.LastModified
+	}
+	return o.UpdateTime
+}
+
+// Cache stores objects by host and page name.
+type Cache interface {
+	Get(hostName, pageName string) (Object, bool)
+	Put(hostName, pageName string, obj Object)
+	// Delete removes a page and reports whether it was cached.
+	Delete(hostName, pageName string) bool
+	// List returns every cached page.
+	List() []CacheEntry
+}
+
+// cacheContainer is implemented by the caches that can tell whether they
+// hold a page without it counting as a lookup. Storage falls back to Get
+// for the others.
+type cacheContainer interface {
+	Contains(hostName, pageName string) bool
+}
+
+type CacheEntry struct {
+	HostName string
+	PageName string
+	Object   Object
+}
+
+func cacheKey(hostName, pageName string) string {
+	return hostName + "/" + pageName
+}
+
+type memoryEntry struct {
+	hostName string
+	pageName string
+	obj      Object
+	digest   [sha256.Size]byte
+	// hits counts the lookups that found the entry
+	hits int64
+
+	elem *list.Element
+	// groups are the quota groups of the entry, the entry being at
+	// groupElems[i] in the LRU of groups[i]
+	groups     []*lruGroup
+	groupElems []*list.Element
+}
+
+// MemoryCache is an in-memory LRU cache bounded by the total size of the
+// cached content. Bodies are stored by their hash, so identical content
+// cached under several URLs, such as a page and its index.html, takes up
+// memory once. With quotas, the objects of every host and tenant are also
+// bounded on their own: a host over its quota only evicts its own objects.
+type MemoryCache struct {
+	maxBytes int64
+	quotas   *CacheQuotas
+	// onEvict is called, without the lock held, for every object pushed out
+	// to make room.
+	onEvict func(hostName, pageName string, obj Object)
+
+	mu sync.Mutex
+	// size counts every stored body once, logical as often as it is cached
+	size    int64
+	logical int64
+	lru     *list.List
+	entries map[string]*memoryEntry
+	groups  map[string]*lruGroup
+	blobs   map[[sha256.Size]byte]*blob
+}
+
+// blob is a body shared by the entries with the same content.
+type blob struct {
+	data []byte
+	refs int
+}
+
+// NewMemoryCache creates a cache holding up to maxBytes of content. Zero
+// means no limit. quotas may be nil.
+func NewMemoryCache(maxBytes int64, quotas *CacheQuotas) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		quotas:   quotas,
+		lru:      list.New(),
+		entries:  make(map[string]*memoryEntry),
+		groups:   make(map[string]*lruGroup),
+		blobs:    make(map[[sha256.Size]byte]*blob),
+	}
+}
+
+func (c *MemoryCache) Get(hostName, pageName string) (Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(hostName, pageName)]
+	if !ok {
+		return Object{}, false
+	}
+	c.lru.MoveToFront(entry.elem)
+	for i, group := range entry.groups {
+		group.lru.MoveToFront(entry.groupElems[i])
+	}
+	entry.hits++
+	return entry.obj, true
+}
+
+// Contains reports whether pageName is cached, leaving its place in the LRU
+// and its hits alone.
+func (c *MemoryCache) Contains(hostName, pageName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[cacheKey(hostName, pageName)]
+	return ok
+}
+
+// Hits returns how many lookups found pageName since it was cached in
+// memory, zero if it isn't.
+func (c *MemoryCache) Hits(hostName, pageName string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[cacheKey(hostName, pageName)]; ok {
+		return entry.hits
+	}
+	return 0
+}
+
+func (c *MemoryCache) Put(hostName, pageName string, obj Object) {
+	evicted := c.put(hostName, pageName, obj)
+	if c.onEvict != nil {
+		for _, entry := range evicted {
+			c.onEvict(entry.hostName, entry.pageName, entry.obj)
+		}
+	}
+}
+
+func (c *MemoryCache) put(hostName, pageName string, obj Object) []*memoryEntry {
+	digest := sha256.Sum256(obj.Content)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(hostName, pageName)
+	if existing, ok := c.entries[key]; ok {
+		c.remove(existing)
+	}
+	if b, ok := c.blobs[digest]; ok {
+		obj.Content = b.data
+		b.refs++
+	} else {
+		c.blobs[digest] = &blob{data: obj.Content, refs: 1}
+		c.size += int64(len(obj.Content))
+	}
+	entry := &memoryEntry{hostName: hostName, pageName: pageName, obj: obj, digest: digest}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.logical += int64(len(obj.Content))
+
+	names, quotas := c.quotas.groups(hostName)
+	for i, name := range names {
+		group, ok := c.groups[name]
+		if !ok {
+			scope, _, _ := strings.Cut(name, ":")
+			group = &lruGroup{name: name, scope: scope, quota: quotas[i], lru: list.New()}
+			c.groups[name] = group
+		}
+		entry.groups = append(entry.groups, group)
+		entry.groupElems = append(entry.groupElems, group.lru.PushFront(entry))
+		group.size += int64(len(obj.Content))
+	}
+
+	var evicted []*memoryEntry
+	for _, group := range entry.groups {
+		for group.quota.exceeded(group.size, group.lru.Len()) && group.lru.Len() > 1 {
+			oldest := group.lru.Back().Value.(*memoryEntry)
+			c.remove(oldest)
+			quotaEvictions.Inc(group.scope)
+			evicted = append(evicted, oldest)
+		}
+	}
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.lru.Len() > 1 {
+		oldest := c.lru.Back().Value.(*memoryEntry)
+		c.remove(oldest)
+		evicted = append(evicted, oldest)
+	}
+	return evicted
+}
+
+func (c *MemoryCache) Delete(hostName, pageName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(hostName, pageName)]
+	if ok {
+		c.remove(entry)
+	}
+	return ok
+}
+
+func (c *MemoryCache) List() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]CacheEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*memoryEntry)
+		entries = append(entries, CacheEntry{HostName: entry.hostName, PageName: entry.pageName, Object: entry.obj})
+	}
+	return entries
+}
+
+func (c *MemoryCache) remove(entry *memoryEntry) {
+	c.lru.Remove(entry.elem)
+	delete(c.entries, cacheKey(entry.hostName, entry.pageName))
+	c.logical -= int64(len(entry.obj.Content))
+	b := c.blobs[entry.digest]
+	b.refs--
+	if b.refs == 0 {
+		delete(c.blobs, entry.digest)
+		c.size -= int64(len(entry.obj.Content))
+	}
+
+	for i, group := range entry.groups {
+		group.lru.Remove(entry.groupElems[i])
+		group.size -= int64(len(entry.obj.Content))
+		if group.lru.Len() == 0 {
+			delete(c.groups, group.name)
+		}
+	}
+}
+
+// Size returns the number of content bytes held.
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// DedupedSize returns the number of content bytes not held thanks to
+// identical bodies being stored once.
+func (c *MemoryCache) DedupedSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logical - c.size
+}
@@ -0,0 +1,90 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CacheControlRule sets the caching headers sent to downstream caches such
+// as a CDN for the responses it matches. Empty Host or ContentType match
+// anything.
+type CacheControlRule struct {
+	// Host is the host name the rule applies to, e.g.
+	// "https://paulgraham.com".
+	Host string `json:"host"`
+	// ContentType is a media type, possibly with a wildcard subtype:
+	// "image/*".
+	ContentType string `json:"content_type"`
+
+	// NoStore forbids downstream caching; the other fields are ignored.
+	NoStore              bool     `json:"no_store"`
+	Private              bool     `json:"private"`
+	MaxAge               Duration `json:"max_age"`
+	SMaxAge              Duration `json:"s_maxage"`
+	StaleWhileRevalidate Duration `json:"stale_while_revalidate"`
+	StaleIfError         Duration `json:"stale_if_error"`
+	// SurrogateMaxAge, when set, is sent as Surrogate-Control for CDNs that
+	// honor it and strip it before the browser.
+	SurrogateMaxAge Duration `json:"surrogate_max_age"`
+}
+
+func (rule CacheControlRule) matches(hostName, contentType string) bool {
+	if rule.Host != "" && canonicalHost(rule.Host) != hostName {
+		return false
+	}
+	if rule.ContentType != "" && !(MediaTypes{rule.ContentType}).Allowed(parseMediaType(contentType)) {
+		return false
+	}
+	return true
+}
+
+func (rule CacheControlRule) header() string {
+	if rule.NoStore {
+		return "no-store"
+	}
+	directives := []string{"public"}
+	if rule.Private {
+		directives[0] = "private"
+	}
+	directives = append(directives, fmt.Sprintf("max-age=%d", seconds(rule.MaxAge)))
+	if rule.SMaxAge > 0 && !rule.Private {
+		directives = append(directives, fmt.Sprintf("s-maxage=%d", seconds(rule.SMaxAge)))
+	}
+	if rule.StaleWhileRevalidate > 0 {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%d", seconds(rule.StaleWhileRevalidate)))
+	}
+	if rule.StaleIfError > 0 {
+		directives = append(directives, fmt.Sprintf("stale-if-error=%d", seconds(rule.StaleIfError)))
+	}
+	return strings.Join(directives, ", ")
+}
+
+func seconds(d Duration) int64 {
+	return int64(time.Duration(d) / time.Second)
+}
+
+// CacheControlPolicy picks the caching headers of proxy responses. The
+// first matching rule wins; without a match no caching headers are sent.
+type CacheControlPolicy []CacheControlRule
+
+// Apply sets the caching headers of a response. A private response, one
+// depending on the credentials of the request, is made private whatever
+// the rule says, so that a shared cache never serves it to someone else.
+func (p CacheControlPolicy) Apply(header http.Header, hostName, contentType string, private bool) {
+	for _, rule := range p {
+		if !rule.matches(hostName, contentType) {
+			continue
+		}
+		if private {
+			rule.Private = true
+			rule.SurrogateMaxAge = 0
+		}
+		header.Set("Cache-Control", rule.header())
+		if rule.SurrogateMaxAge > 0 && !rule.NoStore {
+			header.Set("Surrogate-Control", fmt.Sprintf("max-age=%d", seconds(rule.SurrogateMaxAge)))
+		}
+		return
+	}
+}
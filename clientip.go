@@ -0,0 +1,160 @@
+package blogproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Middleware wraps an http.Handler with additional behavior.
+type Middleware func(http.Handler) http.Handler
+
+// TrustedProxies is the set of networks whose forwarding header is
+// believed when working out the client address.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	// header is the one forwarding header the trusted proxies write. Any
+	// other is ignored, as the client may have sent it.
+	header string
+}
+
+// ParseTrustedProxies parses CIDRs or bare addresses, and the name of the
+// header the proxies append the address of their client to,
+// X-Forwarded-For when empty.
+func ParseTrustedProxies(entries []string, header string) (TrustedProxies, error) {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	trusted := TrustedProxies{
+		prefixes: make([]netip.Prefix, 0, len(entries)),
+		header:   http.CanonicalHeaderKey(header),
+	}
+	for _, entry := range entries {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return TrustedProxies{}, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted.prefixes = append(trusted.prefixes, prefix)
+	}
+	return trusted, nil
+}
+
+func parsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP determines the address of the client that made r. Only the
+// forwarding header of the trusted proxies is read, from the right, and only
+// as long as the hop that added an entry is trusted, so a client cannot
+// spoof its address by sending forwarding headers itself.
+func (t TrustedProxies) ClientIP(r *http.Request) netip.Addr {
+	remote := parseHostAddr(r.RemoteAddr)
+	if !remote.IsValid() || !t.Contains(remote) {
+		return remote
+	}
+
+	var hops []string
+	if t.header == "Forwarded" {
+		hops = forwardedFor(r.Header)
+	} else {
+		hops = listHeader(r.Header, t.header)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := parseHostAddr(hops[i])
+		if !addr.IsValid() {
+			// obfuscated or garbage entry, the last trusted hop is all we know
+			break
+		}
+		client = addr
+		if !t.Contains(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// Middleware stores the client address in the request context, where it is
+// available through ClientIP to every handler further down the chain.
+func (t TrustedProxies) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey, t.ClientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the client address stored by TrustedProxies.Middleware.
+func ClientIP(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(clientIPKey).(netip.Addr)
+	return addr
+}
+
+// forwardedFor returns the for= parameters of the RFC 7239 Forwarded
+// header, in the order the hops were added.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hops = append(hops, strings.Trim(val, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// listHeader returns the comma separated entries of the named header, such
+// as X-Forwarded-For, in the order the hops were added.
+func listHeader(header http.Header, name string) []string {
+	var hops []string
+	for _, value := range header.Values(name) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHostAddr parses an address that may carry a port and, for IPv6,
+// square brackets: "192.0.2.1", "192.0.2.1:80", "[2001:db8::1]:443".
+func parseHostAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
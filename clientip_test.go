@@ -0,0 +1,106 @@
+package blogproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "untrusted peer",
+			remote: "198.51.100.7:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			want: "198.51.100.7",
+		},
+		{
+			name:   "trusted hop",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			// the client prepends its own entry; only the one added by the
+			// load balancer, rightmost, is believed
+			name:   "spoofed leading entry",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.0.2.1, 203.0.113.9",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "chain of trusted hops",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9, 10.0.0.2",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			// the load balancer writes X-Forwarded-For and passes a
+			// Forwarded header sent by the client through untouched
+			name:   "forged Forwarded through a trusted hop",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       "for=192.0.2.1",
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "forged Forwarded without X-Forwarded-For",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded": "for=192.0.2.1",
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:   "configured Forwarded",
+			header: "Forwarded",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       `for=192.0.2.1, for="[2001:db8::1]:443"`,
+				"X-Forwarded-For": "192.0.2.2",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:   "single address header",
+			header: "x-real-ip",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Real-IP":       "203.0.113.9",
+				"X-Forwarded-For": "192.0.2.2",
+			},
+			want: "203.0.113.9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"}, tt.header)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := trusted.ClientIP(r); got.String() != tt.want {
+				t.Errorf("ClientIP = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
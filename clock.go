@@ -0,0 +1,16 @@
+package blogproxy
+
+import "time"
+
+// Clock tells the time. Storage reads it through a Clock rather than
+// calling time.Now so tests can move time forward without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
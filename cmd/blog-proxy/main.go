@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	blogproxy "github.com/priyanshujain/blog-proxy"
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds how long a signalled shutdown waits for requests in
+// flight.
+const shutdownTimeout = 10 * time.Second
+
+func init() {
+	var logLevel = log.LevelDebug
+	// parse log level from env
+	if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" {
+		if err := logLevel.UnmarshalText([]byte(envLogLevel)); err != nil {
+			log.Error("failed to parse log level", "error", err)
+		}
+	}
+	log.SetDefault(log.New(log.NewTextHandler(os.Stdout, &log.HandlerOptions{
+		Level: logLevel,
+	})))
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(blogproxy.RunValidateConfig(os.Args[2:], os.Stdout))
+		case "bench":
+			os.Exit(blogproxy.RunBench(os.Args[2:], os.Stdout))
+		case "warm":
+			os.Exit(blogproxy.RunWarm(os.Args[2:], os.Stdout))
+		}
+	}
+
+	ctx := context.Background()
+	cfg, err := blogproxy.LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		fatalf("failed to load config: %+v", err)
+	}
+
+	s, err := blogproxy.NewStorage(ctx, blogproxy.WithConfig(cfg))
+	if err != nil {
+		fatalf("failed to create storage: %+v", err)
+	}
+
+	srv, err := blogproxy.NewServer(cfg, s)
+	if err != nil {
+		fatalf("failed to create server: %+v", err)
+	}
+
+	var handoff *blogproxy.Handoff
+	if cfg.Handoff.Socket != "" {
+		handoff = blogproxy.NewHandoff(cfg.Handoff, s)
+		// the process being replaced keeps serving until the cache is
+		// here, and releases the listen addresses before Receive returns
+		n, err := handoff.Receive()
+		if err != nil {
+			log.Error("failed to take the cache over", "received", n, "error", err)
+		} else if n > 0 {
+			log.Info("took the cache over", "objects", n)
+		}
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			fatalf("failed to listen for grpc: %+v", err)
+		}
+		grpcServer = blogproxy.NewGRPCServer(cfg, s)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				fatalf("grpc server failed: %+v", err)
+			}
+		}()
+	}
+
+	httpServer := &http.Server{Addr: ":9080", Handler: srv.Handler()}
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func(timeout time.Duration) {
+		stopOnce.Do(func() {
+			defer close(stopped)
+			// event streams never go idle, the timeout cuts them
+			shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if grpcServer != nil {
+				go func() {
+					<-shutdownCtx.Done()
+					grpcServer.Stop()
+				}()
+				grpcServer.GracefulStop()
+			}
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				httpServer.Close()
+			}
+		})
+	}
+	if handoff != nil {
+		go func() {
+			err := handoff.Serve(func() { stop(time.Duration(cfg.Handoff.Timeout)) })
+			if err != nil {
+				log.Error("handoff socket failed", "error", err)
+			}
+		}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop(shutdownTimeout)
+	}()
+
+	err = httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		if err := s.Close(); err != nil {
+			log.Error("failed to close storage", "error", err)
+		}
+		return
+	}
+	if err != nil {
+		fatalf("http server failed: %+v", err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,57 @@
+package blogproxy
+
+import (
+	"context"
+	log "log/slog"
+	"math/rand"
+	"time"
+)
+
+var compareTotal = metrics.Counter(
+	"blogproxy_compare_total",
+	"Dark-launch comparisons of cached objects against the origin, by result.",
+	"result",
+)
+
+// shouldCompare picks the cache hits that are checked against the origin.
+func (s *Storage) shouldCompare() bool {
+	return s.compareRate > 0 && rand.Float64() < s.compareRate
+}
+
+// compareTimeout bounds a comparison fetch.
+const compareTimeout = time.Minute
+
+// compareWithOrigin fetches pageName from the origin for the request of ctx
+// and logs whether it still matches the cached object. The result is only
+// reported; the cache is left untouched.
+func (s *Storage) compareWithOrigin(ctx context.Context, hostName, pageName string, cached Object) {
+	// the tenant and language of the request pick the variant compared,
+	// once it is done too
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compareTimeout)
+	defer cancel()
+
+	fresh, err := s.fetch(ctx, hostName, pageName)
+	if err != nil {
+		compareTotal.Inc("error")
+		log.Warn("compare fetch failed", "host", hostName, "object", pageName, "error", err)
+		return
+	}
+
+	if fresh.Etag == cached.Etag {
+		compareTotal.Inc("match")
+		log.Debug("compare match", "host", hostName, "object", pageName)
+		return
+	}
+
+	compareTotal.Inc("diverged")
+	log.Info("compare diverged",
+		"host", hostName,
+		"object", pageName,
+		"cached_etag", cached.Etag,
+		"origin_etag", fresh.Etag,
+		"cached_size", len(cached.Content),
+		"origin_size", len(fresh.Content),
+		"cached_age", s.clock.Now().Sub(cached.UpdateTime).Round(time.Second),
+		"ttl_left", cached.ExpiryTime.Sub(s.clock.Now()).Round(time.Second),
+	)
+}
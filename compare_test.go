@@ -0,0 +1,81 @@
+package blogproxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForCount waits until the compare result counter got past n.
+func waitForCount(t *testing.T, result string, n float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for compareTotal.Value(result) <= n {
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s comparison counted", result)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCompareSampling(t *testing.T) {
+	for _, tt := range []struct {
+		rate float64
+		want bool
+	}{{0, false}, {1, true}} {
+		s := &Storage{compareRate: tt.rate}
+		for i := 0; i < 100; i++ {
+			if got := s.shouldCompare(); got != tt.want {
+				t.Fatalf("shouldCompare at rate %v = %v", tt.rate, got)
+			}
+		}
+	}
+
+	s := &Storage{compareRate: 0.5}
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if s.shouldCompare() {
+			sampled++
+		}
+	}
+	if sampled < 4000 || sampled > 6000 {
+		t.Errorf("sampled %d hits of 10000 at rate 0.5", sampled)
+	}
+}
+
+func TestCompareWithOrigin(t *testing.T) {
+	page := originPage{
+		Body:      essay,
+		Languages: map[string]string{"fr": "<p>Comment bien travailler.</p>"},
+		Header:    http.Header{"Vary": {"Accept-Language"}},
+	}
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": page})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.CompareSampleRate = 1
+	})
+	french := http.Header{"Accept-Language": {"fr"}}
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/essay.html", Header: french, WantStatus: http.StatusOK, WantBody: "Comment", WantOriginRequests: 2},
+	})
+
+	// the French copy is compared with the French page
+	matches, diverged := compareTotal.Value("match"), compareTotal.Value("diverged")
+	// the hits are compared in the background, so the origin requests of
+	// the comparisons aren't checked
+	if rec := proxy.Get("/essay.html", french); rec.Code != http.StatusOK {
+		t.Fatalf("GET /essay.html = %d", rec.Code)
+	}
+	waitForCount(t, "match", matches)
+	if got := compareTotal.Value("diverged"); got != diverged {
+		t.Errorf("%v comparisons diverged, want none", got-diverged)
+	}
+
+	page.Languages["fr"] = "<p>Comment faire du bon travail.</p>"
+	origin.Set("/essay.html", page)
+	if rec := proxy.Get("/essay.html", french); !strings.Contains(rec.Body.String(), "bien travailler") {
+		t.Fatalf("GET /essay.html = %d %q, want the cached copy", rec.Code, rec.Body)
+	}
+	waitForCount(t, "diverged", diverged)
+}
@@ -0,0 +1,623 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config holds the runtime settings of the proxy. It is read from the JSON
+// file named by the CONFIG_FILE environment variable; any field left out of
+// the file keeps its default value.
+type Config struct {
+	// AllowedHosts are the origins proxied for the requests of no tenant,
+	// as in the allowed_hosts of a tenant: origins,
+	// "https://paulgraham.com", or bare hostnames, "paulgraham.com".
+	AllowedHosts []string `json:"allowed_hosts"`
+	// AllowedSchemes are the schemes the bare hostnames of AllowedHosts
+	// match, https and http by default.
+	AllowedSchemes []string `json:"allowed_schemes"`
+
+	// AllowedContentTypes lists the media types the proxy is willing to
+	// serve. An entry may use a wildcard subtype, e.g. "image/*".
+	AllowedContentTypes []string `json:"allowed_content_types"`
+
+	// DenyPaths maps a host name, e.g. "https://paulgraham.com", to regular
+	// expressions matched against the request path ("/wp-login.php").
+	// Matching pages are refused before any fetch and are never cached.
+	DenyPaths map[string][]string `json:"deny_paths"`
+
+	// TrustedProxies lists the CIDRs of load balancers and proxies in front
+	// of this service. ForwardedHeader is only honored when it was added by
+	// one of these.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// ForwardedHeader is the header the trusted proxies append the client
+	// address to: "X-Forwarded-For" (the default), "Forwarded" or a single
+	// address header such as "X-Real-IP". Other forwarding headers are
+	// ignored, since clients can send them too.
+	ForwardedHeader string `json:"forwarded_header"`
+
+	// AdminToken is the bearer token required by the /admin routes. The
+	// admin routes are disabled while it is empty.
+	AdminToken string `json:"admin_token"`
+
+	ClientAccess ClientAccessConfig `json:"client_access"`
+
+	// Tenants lets several users share the deployment. Requests matching no
+	// tenant keep being served with the global settings.
+	Tenants []TenantConfig `json:"tenants"`
+
+	BasicAuth BasicAuthConfig `json:"basic_auth"`
+
+	OIDC OIDCConfig `json:"oidc"`
+
+	SignedURLs SignedURLConfig `json:"signed_urls"`
+
+	Maintenance MaintenanceConfig `json:"maintenance"`
+
+	Fetch FetchConfig `json:"fetch"`
+
+	Prefetch PrefetchConfig `json:"prefetch"`
+
+	BatchPrefetch BatchPrefetchConfig `json:"batch_prefetch"`
+
+	// CompareSampleRate is the fraction of cache hits, between 0 and 1, that
+	// are also fetched from the origin in the background to check whether
+	// the cached copy has diverged. Divergence is logged and counted in
+	// blogproxy_compare_total; the cached copy is still what gets served.
+	CompareSampleRate float64 `json:"compare_sample_rate"`
+
+	Admission AdmissionConfig `json:"admission"`
+
+	Cache CacheConfig `json:"cache"`
+
+	Peers PeersConfig `json:"peers"`
+
+	// GRPCAddr is the listen address of the gRPC API, e.g. ":9090", which
+	// requires the admin token. The gRPC API is off while it is empty.
+	GRPCAddr string `json:"grpc_addr"`
+
+	TTS TTSConfig `json:"tts"`
+
+	LinkCheck LinkCheckConfig `json:"link_check"`
+
+	Favicon FaviconConfig `json:"favicon"`
+
+	// Transforms maps host names, e.g. "https://paulgraham.com", to the
+	// rules rewriting their HTML pages before they are served.
+	Transforms map[string]TransformRules `json:"transforms"`
+
+	Banner BannerConfig `json:"banner"`
+
+	Indexing IndexingConfig `json:"indexing"`
+
+	Bandwidth BandwidthConfig `json:"bandwidth"`
+
+	// HistoryVersions is the number of versions kept per page for /versions
+	// and /diff. Zero turns off version history.
+	HistoryVersions int `json:"history_versions"`
+	// HistoryMaxBytes bounds the content of the versions held in memory
+	// when there is no disk_dir to keep them in. The pages changed least
+	// recently are dropped first. Zero means no limit.
+	HistoryMaxBytes int64 `json:"history_max_bytes"`
+
+	// CacheControl are the rules for the Cache-Control and
+	// Surrogate-Control headers of proxied responses, for running the proxy
+	// behind a CDN. The first matching rule applies. With basic auth, OIDC,
+	// signed links or tenant API keys on, responses are always private.
+	CacheControl []CacheControlRule `json:"cache_control"`
+
+	// ResponseHeaders are extra headers of proxied responses, set after
+	// the proxy's own, by host, path and content type. Every matching rule
+	// applies.
+	ResponseHeaders []HeaderRule `json:"response_headers"`
+
+	CORS CORSConfig `json:"cors"`
+
+	Handoff HandoffConfig `json:"handoff"`
+
+	SLO SLOConfig `json:"slo"`
+
+	Debug DebugConfig `json:"debug"`
+}
+
+type DebugConfig struct {
+	// Token turns on the debug headers of the proxied requests sending it
+	// in X-Blog-Proxy-Debug: the cache decision and key, the time to live
+	// left, the transforms applied and the upstream fetch. Debugging is
+	// off while it is empty.
+	Token string `json:"token"`
+}
+
+// SLOConfig are the service level objectives of the proxied requests,
+// whose burn rates are exported on /metrics.
+type SLOConfig struct {
+	// Availability is the fraction of requests to answer without a server
+	// error. Zero turns the availability objective off.
+	Availability float64 `json:"availability"`
+	// LatencyObjective is the fraction of requests to answer within
+	// Latency. Zero turns the latency objective off.
+	LatencyObjective float64  `json:"latency_objective"`
+	Latency          Duration `json:"latency"`
+}
+
+type HandoffConfig struct {
+	// Socket is the path of the unix socket over which a restarted proxy
+	// takes the memory cache over from the process it replaces. Handoff is
+	// off while it is empty.
+	Socket string `json:"socket"`
+	// Timeout bounds the whole handoff; past it, the new process starts
+	// with what it got.
+	Timeout Duration `json:"timeout"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins are the origins of the pages that may read proxied
+	// responses, e.g. "https://app.example.com", or "https://*.example.com"
+	// for its subdomains. While empty, every page may.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request. Zero leaves it to the browser.
+	MaxAge Duration `json:"max_age"`
+}
+
+type TenantConfig struct {
+	// Name identifies the tenant in logs and metrics and namespaces its
+	// cache. It is made of lowercase letters, digits, "-" and "_".
+	Name string `json:"name"`
+	// APIKeys are the values of the X-API-Key header picking the tenant.
+	APIKeys []string `json:"api_keys"`
+	// Hostnames are the hostnames of the proxy, as in the Host header, that
+	// pick the tenant when no API key is sent.
+	Hostnames []string `json:"hostnames"`
+	// AllowedHosts are the origins the tenant may proxy, e.g.
+	// "https://paulgraham.com", or bare hostnames, "paulgraham.com",
+	// matching every scheme of AllowedSchemes. A port other than the
+	// default one of the scheme has to be given, "localhost:8080".
+	AllowedHosts []string `json:"allowed_hosts"`
+	// AllowedSchemes are the schemes bare hostnames of AllowedHosts match,
+	// https and http by default.
+	AllowedSchemes []string `json:"allowed_schemes"`
+	// RateLimit is the number of requests per second the tenant may make,
+	// with bursts of up to Burst requests. Zero means no limit.
+	RateLimit float64 `json:"rate_limit"`
+	Burst     int     `json:"burst"`
+	// TTL is how long fetched pages stay fresh, 24h by default.
+	TTL Duration `json:"ttl"`
+	// Quota bounds the memory cache taken up by all the pages of the
+	// tenant.
+	Quota Quota `json:"quota"`
+}
+
+type BasicAuthConfig struct {
+	// Users maps user names to bcrypt hashes of their passwords, as made by
+	// "htpasswd -nbB user password". Basic auth is off while it is empty.
+	Users map[string]string `json:"users"`
+	// Realm is sent in the WWW-Authenticate challenge.
+	Realm string `json:"realm"`
+	// MaxFailures is the number of failed attempts within LockoutWindow
+	// after which a client gets 429 until the window is over. Zero never
+	// locks clients out.
+	MaxFailures   int      `json:"max_failures"`
+	LockoutWindow Duration `json:"lockout_window"`
+}
+
+type SignedURLConfig struct {
+	// Secret is the HMAC key of signed links, which are issued by POST
+	// /admin/sign and served without authentication until they expire.
+	// Signed links are off while it is empty.
+	Secret string `json:"secret"`
+	// DefaultTTL is how long issued links stay valid.
+	DefaultTTL Duration `json:"default_ttl"`
+	// Required refuses unsigned requests when neither basic auth nor OIDC
+	// is enabled, so the instance only serves the links it signed.
+	Required bool `json:"required"`
+}
+
+type OIDCConfig struct {
+	// Issuer is the OIDC issuer URL whose JWTs, sent as bearer tokens, are
+	// required on the proxy routes. A token satisfying the Admin rules also
+	// opens the admin routes. OIDC is off while it is empty.
+	Issuer string `json:"issuer"`
+	// JWKSURL is where the signing keys are fetched from. It is looked up
+	// in the issuer's discovery document when empty.
+	JWKSURL string `json:"jwks_url"`
+	// Audience is the aud tokens must be issued for. Any audience is
+	// accepted while it is empty.
+	Audience string `json:"audience"`
+	// GroupsClaim is the claim holding the groups of the user, "groups" by
+	// default.
+	GroupsClaim string `json:"groups_claim"`
+
+	Proxy OIDCRules `json:"proxy"`
+	// Admin must be restricted by at least one group or claim, as every
+	// valid token would be an admin otherwise.
+	Admin OIDCRules `json:"admin"`
+}
+
+// OIDCRules are the claims a token needs for a set of routes.
+type OIDCRules struct {
+	// Groups lets in members of any of the listed groups. Everyone is let
+	// in while it is empty.
+	Groups []string `json:"groups"`
+	// Claims maps claim names to their accepted values, e.g.
+	// {"email_verified": ["true"]}. A claim holding a list is accepted when
+	// any of its values is.
+	Claims map[string][]string `json:"claims"`
+}
+
+type ClientAccessConfig struct {
+	// Proxy restricts the clients of every route but the admin ones.
+	Proxy IPRulesConfig `json:"proxy"`
+	// Admin restricts the clients of the /admin routes, /stats, /metrics
+	// and the GraphQL purge mutation, on top of the admin token.
+	Admin IPRulesConfig `json:"admin"`
+}
+
+type IPRulesConfig struct {
+	// Allow lists the CIDRs or addresses of the clients let in. Every
+	// client is let in while it is empty.
+	Allow []string `json:"allow"`
+	// Deny lists CIDRs or addresses refused even when they are allowed.
+	Deny []string `json:"deny"`
+}
+
+type BandwidthConfig struct {
+	// DailyBytes is the number of content bytes that may be fetched from
+	// each origin per UTC day. Once it is used up the origin is no longer
+	// fetched from: cached copies are served even when expired, and pages
+	// not in the cache get a 503. Zero means no limit.
+	DailyBytes int64 `json:"daily_bytes"`
+	// Hosts overrides DailyBytes for individual hosts, e.g.
+	// {"https://paulgraham.com": 104857600}. Zero means no limit.
+	Hosts map[string]int64 `json:"hosts"`
+}
+
+type TransformRules struct {
+	// Strip lists CSS selectors of elements removed from pages, e.g.
+	// ".ad" or "nav".
+	Strip []string `json:"strip"`
+	// Inject lists HTML fragments added to pages.
+	Inject []InjectRule `json:"inject"`
+}
+
+type InjectRule struct {
+	// Selector is the CSS selector of the element the HTML is inserted
+	// relative to, "body" by default. Only the first match is used.
+	Selector string `json:"selector"`
+	// Position is "prepend" (the default) or "append" to insert into the
+	// element, or "before" or "after" to insert next to it.
+	Position string `json:"position"`
+	// HTML is an html/template that can use {{.URL}}, the original address
+	// of the page, and {{.Fetched}}, when it was fetched.
+	HTML string `json:"html"`
+}
+
+type BannerConfig struct {
+	// Enabled adds a banner crediting the original page to the top of every
+	// proxied HTML page.
+	Enabled bool `json:"enabled"`
+	// Hosts limits the banner to the pages of these hosts, e.g.
+	// ["https://paulgraham.com"]. Every host gets it while it is empty.
+	Hosts []string `json:"hosts"`
+	// HTML replaces the built-in banner. Like the html of inject rules it
+	// is an html/template that can use {{.URL}} and {{.Fetched}}.
+	HTML string `json:"html"`
+}
+
+type IndexingConfig struct {
+	// Canonical adds a <link rel="canonical"> to the original URL to every
+	// proxied HTML page, replacing the one the page came with, so search
+	// engines don't treat the proxy as duplicate content.
+	Canonical bool `json:"canonical"`
+	// NoIndex adds <meta name="robots" content="noindex"> to every proxied
+	// HTML page.
+	NoIndex bool `json:"noindex"`
+}
+
+type FaviconConfig struct {
+	// Enabled answers /favicon.ico with the icon of the origin of the page
+	// the browser is on, taken from the url parameter of the Referer.
+	Enabled bool `json:"enabled"`
+	// DefaultHost is the origin whose icon is served when the Referer names
+	// none, e.g. "https://paulgraham.com".
+	DefaultHost string `json:"default_host"`
+}
+
+type LinkCheckConfig struct {
+	// Enabled turns on the periodic check of the links in cached pages. The
+	// report is at /admin/linkcheck. Links to loopback, private and
+	// link-local addresses are reported dead without being requested.
+	Enabled bool `json:"enabled"`
+	// Interval is the time between two checks. It must be positive.
+	Interval Duration `json:"interval"`
+	// Timeout bounds the check of a single link; links timing out are
+	// reported dead.
+	Timeout Duration `json:"timeout"`
+	// Concurrency is the number of links checked at once.
+	Concurrency int `json:"concurrency"`
+}
+
+type TTSConfig struct {
+	// Backend selects the text-to-speech engine behind /audio: "command"
+	// runs a local program, "http" calls a speech API. /audio is off while
+	// it is empty.
+	Backend string `json:"backend"`
+	// Command is the program and arguments of the command backend, e.g.
+	// ["espeak-ng", "--stdout"]. It reads text on stdin and writes audio to
+	// stdout.
+	Command []string `json:"command"`
+	// URL is the endpoint of the http backend. It receives a JSON body with
+	// "text" and "voice" and answers with the audio.
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+	Voice  string `json:"voice"`
+	// ContentType is the type of the produced audio, used when the backend
+	// doesn't report one.
+	ContentType string `json:"content_type"`
+}
+
+type PeersConfig struct {
+	// Self is the base URL other replicas reach this one on, and must be one
+	// of Peers.
+	Self string `json:"self"`
+	// Peers lists the base URLs of all replicas, this one included, e.g.
+	// "http://10.0.0.2:9080". Peer sharing is off while it is empty.
+	Peers []string `json:"peers"`
+	// Secret authenticates the requests replicas make to each other.
+	Secret string `json:"secret"`
+}
+
+type CacheConfig struct {
+	// MemoryMaxBytes bounds the content held in memory. Zero means no limit.
+	MemoryMaxBytes int64 `json:"memory_max_bytes"`
+	// DiskDir enables the disk tier: objects evicted from memory are
+	// written there instead of being dropped.
+	DiskDir string `json:"disk_dir"`
+	// HostQuota bounds the memory cache taken up by the pages of any one
+	// host, so a busy host only evicts its own pages. A host cached for
+	// several tenants has a quota in each of them.
+	HostQuota Quota `json:"host_quota"`
+	// HostQuotas overrides HostQuota for individual hosts, e.g.
+	// {"https://paulgraham.com": {"max_entries": 500}}.
+	HostQuotas map[string]Quota `json:"host_quotas"`
+	// EarlyRefresh spreads out the refetches of popular pages: a cache hit
+	// on a page about to expire refreshes it in the background with a
+	// probability rising as expiry nears, so its readers don't all miss at
+	// the same instant. It is the beta of XFetch, scaling how early
+	// refreshes start relative to how long the page took to fetch; 1 is a
+	// good start, zero turns early refreshes off.
+	EarlyRefresh float64 `json:"early_refresh"`
+	// Digest is the hash stored with every object and checked when it is
+	// read back from disk or a snapshot: "sha256", "sha512", "crc32c", the
+	// fastest, or "none". A corrupt copy is dropped and fetched again.
+	Digest string `json:"digest"`
+	// Version is a string included in the key of every object on disk and
+	// in snapshots. Changing it, after a change to the transforms say,
+	// drops every object cached under the previous version without a
+	// purge: they are fetched again, and their files removed as the disk
+	// tier is listed.
+	Version string `json:"version"`
+}
+
+type AdmissionConfig struct {
+	// MaxObjectBytes is the largest object admitted into the cache. Bigger
+	// objects are still served, just fetched every time. Zero means no cap.
+	MaxObjectBytes int64 `json:"max_object_bytes"`
+	// MinRequests is how many times an object must be requested within
+	// Window before it is cached. Values of 0 and 1 admit on first request.
+	MinRequests int `json:"min_requests"`
+	// Window is the period over which requests are counted.
+	Window Duration `json:"window"`
+}
+
+type FetchConfig struct {
+	// MaxInflight caps the upstream fetches running at once across all
+	// hosts. Zero means no limit.
+	MaxInflight int `json:"max_inflight"`
+	// MaxInflightPerHost caps the upstream fetches running at once against
+	// a single host. Zero means no limit.
+	MaxInflightPerHost int `json:"max_inflight_per_host"`
+	// QueueTimeout is how long a request waits for a free fetch slot before
+	// it is answered with 503. Zero rejects immediately.
+	QueueTimeout Duration `json:"queue_timeout"`
+
+	// DialTimeout bounds connecting to an origin.
+	DialTimeout Duration `json:"dial_timeout"`
+	// TLSHandshakeTimeout bounds the TLS handshake with an origin.
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout"`
+	// ResponseHeaderTimeout bounds the wait for the response headers once
+	// the request is sent.
+	ResponseHeaderTimeout Duration `json:"response_header_timeout"`
+	// BodyTimeout bounds reading the whole response body, so an origin
+	// trickling bytes can't hold a connection and its buffer forever.
+	BodyTimeout Duration `json:"body_timeout"`
+	// MaxBodyBytes is the largest origin body fetched, compressed or
+	// decompressed; bigger ones fail. Zero means no limit.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// KeepPartial keeps the bytes read by fetches that are cut short, by a
+	// client going away or a timeout, and completes the object with a range
+	// request on its next fetch. Only origins sending Accept-Ranges and an
+	// ETag or Last-Modified can be resumed.
+	KeepPartial bool `json:"keep_partial"`
+	// BreakerFailures is the number of consecutive failed fetches, errors,
+	// timeouts and 5xx responses, after which the fetches from an origin
+	// fail at once for BreakerCooldown. A single fetch then probes whether
+	// it recovered. Zero turns the circuit breaker off.
+	BreakerFailures int      `json:"breaker_failures"`
+	BreakerCooldown Duration `json:"breaker_cooldown"`
+	// BackgroundWorkers is the number of fetches requests start in the
+	// background, early refreshes and comparisons with the origin, running
+	// at once. Up to BackgroundQueue more wait; the others are dropped.
+	BackgroundWorkers int `json:"background_workers"`
+	BackgroundQueue   int `json:"background_queue"`
+
+	// Hosts tunes the connections to single origins, keyed by host name,
+	// e.g. "https://paulgraham.com". The other origins keep the defaults.
+	Hosts map[string]HostFetchConfig `json:"hosts"`
+}
+
+// HostFetchConfig is the transport of one origin.
+type HostFetchConfig struct {
+	// MaxIdleConns is how many idle connections to the origin are kept for
+	// reuse. Zero keeps the default, the larger of max_inflight_per_host
+	// and 2.
+	MaxIdleConns int `json:"max_idle_conns"`
+	// MinTLSVersion is the oldest TLS version accepted from the origin:
+	// "1.0", "1.1", "1.2" or "1.3". Empty keeps the Go default.
+	MinTLSVersion string `json:"min_tls_version"`
+	// DisableHTTP2 speaks HTTP/1.1 to the origin even when it offers
+	// HTTP/2.
+	DisableHTTP2 bool `json:"disable_http2"`
+	// IPFamily restricts connecting to the origin to "ipv4" or "ipv6"
+	// addresses. Empty uses either.
+	IPFamily string `json:"ip_family"`
+}
+
+type MaintenanceConfig struct {
+	// Enabled starts the proxy in maintenance mode.
+	Enabled bool `json:"enabled"`
+	// Page is the path of the HTML file served while in maintenance. A
+	// built-in page is used when empty.
+	Page string `json:"page"`
+	// RetryAfter is sent as the Retry-After header with the 503.
+	RetryAfter Duration `json:"retry_after"`
+}
+
+type PrefetchConfig struct {
+	// Enabled turns on prefetching of same-host links in served HTML.
+	Enabled bool `json:"enabled"`
+	// MaxDepth is how many links away from a requested page to prefetch.
+	MaxDepth int `json:"max_depth"`
+	// Concurrency is the number of prefetches running at once.
+	Concurrency int `json:"concurrency"`
+}
+
+type BatchPrefetchConfig struct {
+	// Concurrency is the number of /api/prefetch fetches running at once
+	// across all jobs.
+	Concurrency int `json:"concurrency"`
+	// HostDelay is the least time between two fetches from the same origin,
+	// so a big batch doesn't hammer it.
+	HostDelay Duration `json:"host_delay"`
+	// MaxURLs is the most urls a single job may ask for.
+	MaxURLs int `json:"max_urls"`
+	// Retention is how long finished jobs stay queryable.
+	Retention Duration `json:"retention"`
+}
+
+// Duration is a time.Duration that is written as a string such as "30s" in
+// the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func DefaultConfig() Config {
+	return Config{
+		AllowedHosts:    []string{"https://paulgraham.com"},
+		ForwardedHeader: "X-Forwarded-For",
+		AllowedContentTypes: []string{
+			"text/html",
+			"text/plain",
+			"text/css",
+			"image/*",
+		},
+		BasicAuth: BasicAuthConfig{
+			Realm:         "blog-proxy",
+			MaxFailures:   5,
+			LockoutWindow: Duration(15 * time.Minute),
+		},
+		SignedURLs: SignedURLConfig{
+			DefaultTTL: Duration(7 * 24 * time.Hour),
+		},
+		Maintenance: MaintenanceConfig{
+			RetryAfter: Duration(5 * time.Minute),
+		},
+		Fetch: FetchConfig{
+			MaxInflight:        64,
+			MaxInflightPerHost: 8,
+			QueueTimeout:       Duration(5 * time.Second),
+
+			DialTimeout:           Duration(5 * time.Second),
+			TLSHandshakeTimeout:   Duration(5 * time.Second),
+			ResponseHeaderTimeout: Duration(10 * time.Second),
+			BodyTimeout:           Duration(30 * time.Second),
+
+			BreakerCooldown: Duration(30 * time.Second),
+
+			BackgroundWorkers: 8,
+			BackgroundQueue:   256,
+		},
+		Admission: AdmissionConfig{
+			MaxObjectBytes: 10 << 20,
+			MinRequests:    1,
+			Window:         Duration(time.Hour),
+		},
+		Cache: CacheConfig{
+			MemoryMaxBytes: 256 << 20,
+			Digest:         "sha256",
+		},
+		Prefetch: PrefetchConfig{
+			MaxDepth:    1,
+			Concurrency: 2,
+		},
+		BatchPrefetch: BatchPrefetchConfig{
+			Concurrency: 4,
+			HostDelay:   Duration(time.Second),
+			MaxURLs:     1000,
+			Retention:   Duration(time.Hour),
+		},
+		TTS: TTSConfig{
+			ContentType: "audio/wav",
+		},
+		HistoryVersions: 10,
+		HistoryMaxBytes: 32 << 20,
+		LinkCheck: LinkCheckConfig{
+			Interval:    Duration(24 * time.Hour),
+			Timeout:     Duration(10 * time.Second),
+			Concurrency: 4,
+		},
+		Handoff: HandoffConfig{
+			Timeout: Duration(30 * time.Second),
+		},
+		SLO: SLOConfig{
+			Availability:     0.999,
+			LatencyObjective: 0.99,
+			Latency:          Duration(time.Second),
+		},
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults. An empty
+// path returns the defaults unchanged.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return cfg, nil
+}
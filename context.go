@@ -0,0 +1,15 @@
+package blogproxy
+
+// contextKey namespaces the request-scoped values the proxy stores in a
+// context.Context.
+type contextKey int
+
+const (
+	clientIPKey contextKey = iota
+	peerRequestKey
+	adminKey
+	tenantKey
+	languageKey
+	revalidationKey
+	traceKey
+)
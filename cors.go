@@ -0,0 +1,101 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMethods are the methods of the proxied responses.
+const corsMethods = "GET, OPTIONS"
+
+// CORS decides which pages, by their Origin, may read the proxied
+// responses. With no allowed origins configured any page may, and the
+// responses are the same for all; otherwise they depend on the Origin of
+// the request and say so with Vary, so downstream caches keep a copy per
+// origin.
+type CORS struct {
+	any     bool
+	origins map[string]struct{}
+	// wildcards are the "scheme://" and ".domain" of "scheme://*.domain"
+	wildcards [][2]string
+	maxAge    time.Duration
+}
+
+func NewCORS(cfg CORSConfig) (*CORS, error) {
+	c := &CORS{any: len(cfg.AllowedOrigins) == 0, origins: make(map[string]struct{}), maxAge: time.Duration(cfg.MaxAge)}
+	for _, entry := range cfg.AllowedOrigins {
+		if scheme, domain, ok := strings.Cut(entry, "://*."); ok {
+			origin, ok := normalizeOrigin(scheme + "://" + domain)
+			if !ok {
+				return nil, fmt.Errorf("invalid cors origin %q", entry)
+			}
+			scheme, domain, _ := strings.Cut(origin, "://")
+			c.wildcards = append(c.wildcards, [2]string{scheme + "://", "." + domain})
+			continue
+		}
+		origin, ok := normalizeOrigin(entry)
+		if !ok {
+			return nil, fmt.Errorf("invalid cors origin %q, want one like https://app.example.com", entry)
+		}
+		c.origins[origin] = struct{}{}
+	}
+	return c, nil
+}
+
+// allows reports whether the page of origin may read the responses.
+func (c *CORS) allows(origin string) bool {
+	origin, ok := normalizeOrigin(origin)
+	if !ok {
+		return false
+	}
+	if _, ok := c.origins[origin]; ok {
+		return true
+	}
+	for _, wildcard := range c.wildcards {
+		if rest, ok := strings.CutPrefix(origin, wildcard[0]); ok && strings.HasSuffix(rest, wildcard[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply sets the CORS headers of a response to r.
+func (c *CORS) Apply(header http.Header, r *http.Request) {
+	if c.any {
+		header.Set("Access-Control-Allow-Origin", "*")
+		header.Set("Access-Control-Allow-Methods", corsMethods)
+		return
+	}
+	header.Add("Vary", "Origin")
+	if origin := r.Header.Get("Origin"); origin != "" && c.allows(origin) {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Methods", corsMethods)
+	}
+}
+
+// Middleware answers the CORS preflight requests. They carry no
+// credentials, so they are answered before authentication.
+func (c *CORS) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c.Apply(w.Header(), r)
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				if c.maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
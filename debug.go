@@ -0,0 +1,114 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugHeader carries the debug token of a request asking for the debug
+// headers.
+const debugHeader = "X-Blog-Proxy-Debug"
+
+// requestTrace collects what the proxy did for a request sent with the
+// debug token, to report it in the debug headers. A nil trace records
+// nothing.
+type requestTrace struct {
+	mu sync.Mutex
+	// decision is the cache event of the lookup, and reason its reason
+	decision string
+	reason   string
+	key      string
+	// upstream is where the object was fetched from, empty when it wasn't
+	upstream string
+	took     time.Duration
+	fetchErr error
+}
+
+func withTrace(ctx context.Context) (context.Context, *requestTrace) {
+	trace := &requestTrace{}
+	return context.WithValue(ctx, traceKey, trace), trace
+}
+
+func traceFrom(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(traceKey).(*requestTrace)
+	return trace
+}
+
+func (t *requestTrace) decide(decision, reason, key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decision, t.reason, t.key = decision, reason, key
+}
+
+func (t *requestTrace) fetched(upstream string, took time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.upstream, t.took, t.fetchErr = upstream, took, err
+}
+
+// decide publishes the cache event of a lookup and records it in the trace
+// of ctx.
+func (s *Storage) decide(ctx context.Context, typ, namespace, stored, reason string) {
+	s.publish(typ, namespace, stored, reason)
+	traceFrom(ctx).decide(typ, reason, cacheKey(namespace, stored))
+}
+
+// debugging reports whether r carries the debug token.
+func (srv *Server) debugging(r *http.Request) bool {
+	token := srv.cfg.Debug.Token
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(debugHeader)), []byte(token)) == 1
+}
+
+// writeHeaders sets the debug headers of the lookup traced, which found
+// stored unless it failed.
+func (t *requestTrace) writeHeaders(h http.Header, stored Object, found bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// a shared cache must not hand the debug headers to other clients
+	h.Add("Vary", debugHeader)
+
+	decision := t.decision
+	if decision == "" {
+		decision = "none"
+	}
+	if t.reason != "" {
+		decision += "; " + t.reason
+	}
+	h.Set("X-Debug-Cache", decision)
+	if t.key != "" {
+		h.Set("X-Debug-Cache-Key", t.key)
+	}
+	if found && !stored.ExpiryTime.IsZero() {
+		h.Set("X-Debug-TTL", stored.ExpiryTime.Sub(now).Round(time.Second).String())
+	}
+
+	upstream := "none"
+	if t.upstream != "" {
+		upstream = fmt.Sprintf("%s; took=%s", t.upstream, t.took.Round(time.Millisecond))
+		if t.fetchErr != nil {
+			upstream += fmt.Sprintf("; error=%q", t.fetchErr.Error())
+		}
+	}
+	h.Set("X-Debug-Upstream", upstream)
+}
+
+// writeTransformHeader sets the debug header listing the transforms applied
+// to a page, none when it was served as stored.
+func writeTransformHeader(h http.Header, transforms []string) {
+	if len(transforms) == 0 {
+		h.Set("X-Debug-Transforms", "none")
+		return
+	}
+	h.Set("X-Debug-Transforms", strings.Join(transforms, ", "))
+}
@@ -0,0 +1,41 @@
+package blogproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var errPathDenied error = &domainError{msg: "path denied", kind: ErrNotAllowed}
+
+// DenyRules holds the compiled per-host path deny patterns.
+type DenyRules map[string][]*regexp.Regexp
+
+func NewDenyRules(rules map[string][]string) (DenyRules, error) {
+	deny := make(DenyRules, len(rules))
+	for hostName, patterns := range rules {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deny pattern %q for %s: %w", pattern, hostName, err)
+			}
+			canonical := canonicalHost(hostName)
+			deny[canonical] = append(deny[canonical], re)
+		}
+	}
+	return deny, nil
+}
+
+// Denied reports whether pageName on hostName matches a deny rule. Patterns
+// are matched against the page path with a leading slash, without the
+// query and fragment.
+func (d DenyRules) Denied(hostName, pageName string) bool {
+	path, _, _ := strings.Cut("/"+pageName, "?")
+	path, _, _ = strings.Cut(path, "#")
+	for _, re := range d[hostName] {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
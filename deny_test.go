@@ -0,0 +1,38 @@
+package blogproxy
+
+import "testing"
+
+func TestDenyRules(t *testing.T) {
+	deny, err := NewDenyRules(map[string][]string{
+		"https://Blog.Example.com:443": {`^/wp-login\.php$`, `^/admin/`},
+	})
+	if err != nil {
+		t.Fatalf("NewDenyRules: %v", err)
+	}
+
+	tests := []struct {
+		hostName string
+		pageName string
+		want     bool
+	}{
+		{"https://blog.example.com", "wp-login.php", true},
+		{"https://blog.example.com", "wp-login.php?x=1", true},
+		{"https://blog.example.com", "wp-login.php#top", true},
+		{"https://blog.example.com", "admin/users?page=2", true},
+		{"https://blog.example.com", "essay.html", false},
+		{"https://blog.example.com", "essay.html?next=/wp-login.php", false},
+		{"https://blog.example.com", "wp-login.php.html", false},
+		// the rules of another host don't apply
+		{"https://example.com", "wp-login.php", false},
+		{"http://blog.example.com", "wp-login.php", false},
+	}
+	for _, tt := range tests {
+		if got := deny.Denied(tt.hostName, tt.pageName); got != tt.want {
+			t.Errorf("Denied(%q, %q) = %v, want %v", tt.hostName, tt.pageName, got, tt.want)
+		}
+	}
+
+	if _, err := NewDenyRules(map[string][]string{"https://example.com": {"("}}); err == nil {
+		t.Error("NewDenyRules accepted an invalid pattern")
+	}
+}
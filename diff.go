@@ -0,0 +1,275 @@
+package blogproxy
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffLine struct {
+	Op   diffOp
+	Text string
+}
+
+// Diffs are refused past these sizes: a diff of two versions of n and m
+// lines differing by d edits takes O((n+m)·d) time and O(d²) memory.
+const (
+	maxDiffLines = 20000
+	maxDiffEdits = 2000
+)
+
+// errDiffTooLarge is returned for versions too long or too different to
+// be diffed.
+var errDiffTooLarge = errors.New("too large to diff")
+
+// diffLines computes a shortest edit script turning a into b with the Myers
+// algorithm, giving up with errDiffTooLarge past maxDiffLines lines or
+// maxDiffEdits edits.
+func diffLines(a, b []string) ([]diffLine, error) {
+	n, m := len(a), len(b)
+	if n > maxDiffLines || m > maxDiffLines {
+		return nil, errDiffTooLarge
+	}
+	limit := min(n+m, maxDiffEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] holds v[offset-d-1 : offset+d+2] as it was before step d,
+	// the diagonals backtrack reads
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), nil
+			}
+		}
+	}
+	return nil, errDiffTooLarge
+}
+
+func backtrack(trace [][]int, a, b []string) []diffLine {
+	var lines []diffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		// v[i] is diagonal i-d-1
+		offset := d + 1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			lines = append(lines, diffLine{Op: diffEqual, Text: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, diffLine{Op: diffInsert, Text: b[prevY]})
+			} else {
+				lines = append(lines, diffLine{Op: diffDelete, Text: a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+type diffHunk struct {
+	Header string
+	Lines  []diffLine
+}
+
+// diffHunks groups an edit script into unified diff hunks.
+func diffHunks(lines []diffLine) []diffHunk {
+	var hunks []diffHunk
+	aLine, bLine := 1, 1
+	i := 0
+	for i < len(lines) {
+		if lines[i].Op == diffEqual {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		// widen the hunk to include context before the change
+		start := max(i-diffContext, 0)
+		for ; start < i && lines[start].Op != diffEqual; start++ {
+		}
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+
+		// extend until diffContext*2 equal lines separate two changes
+		end := i
+		equal := 0
+		for end < len(lines) && (lines[end].Op != diffEqual || equal < 2*diffContext) {
+			if lines[end].Op == diffEqual {
+				equal++
+			} else {
+				equal = 0
+			}
+			end++
+		}
+		end = min(end-equal+min(equal, diffContext), len(lines))
+
+		hunk := diffHunk{Lines: lines[start:end]}
+		aCount, bCount := 0, 0
+		for _, line := range hunk.Lines {
+			if line.Op != diffInsert {
+				aCount++
+			}
+			if line.Op != diffDelete {
+				bCount++
+			}
+		}
+		hunk.Header = fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart, aCount, bStart, bCount)
+		hunks = append(hunks, hunk)
+
+		for _, line := range lines[i:end] {
+			if line.Op != diffInsert {
+				aLine++
+			}
+			if line.Op != diffDelete {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return hunks
+}
+
+func unifiedDiff(fromName, toName string, hunks []diffHunk) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		b.WriteString(hunk.Header + "\n")
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case diffEqual:
+				b.WriteByte(' ')
+			case diffDelete:
+				b.WriteByte('-')
+			case diffInsert:
+				b.WriteByte('+')
+			}
+			b.WriteString(line.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+var diffTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.URL}}: {{.From}} to {{.To}}</title>
+<style>
+body { font: 14px/1.5 monospace; margin: 2em; }
+.hunk { color: #666; margin-top: 1em; }
+.del { background: #fdd; }
+.ins { background: #dfd; }
+div { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1><a href="{{.URL}}">{{.URL}}</a></h1>
+<p>{{.From}} &rarr; {{.To}}</p>
+{{range .Hunks}}<div class="hunk">{{.Header}}</div>
+{{range .Lines}}{{if eq .Op 1}}<div class="del">- {{.Text}}</div>{{else if eq .Op 2}}<div class="ins">+ {{.Text}}</div>{{else}}<div>  {{.Text}}</div>{{end}}
+{{end}}{{else}}<p>No changes.</p>{{end}}
+</body>
+</html>
+`))
+
+// handleDiff shows what changed between two recorded versions of a page.
+// from and to are etags and default to the two newest versions; format
+// selects a unified text diff (the default) or html.
+func (srv *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hostName, pageName, ok := parseTargetURL(query.Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+
+	versions := srv.storage.Versions(r.Context(), hostName, pageName)
+	fromEtag, toEtag := query.Get("from"), query.Get("to")
+	if n := len(versions); n >= 2 {
+		if fromEtag == "" {
+			fromEtag = versions[n-2].Etag
+		}
+		if toEtag == "" {
+			toEtag = versions[n-1].Etag
+		}
+	}
+	from, ok := srv.storage.Version(r.Context(), hostName, pageName, fromEtag)
+	if !ok {
+		http.Error(w, "unknown from version", http.StatusNotFound)
+		return
+	}
+	to, ok := srv.storage.Version(r.Context(), hostName, pageName, toEtag)
+	if !ok {
+		http.Error(w, "unknown to version", http.StatusNotFound)
+		return
+	}
+
+	lines, err := diffLines(diffableLines(from), diffableLines(to))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	hunks := diffHunks(lines)
+	url := cacheKey(hostName, pageName)
+
+	if query.Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		diffTemplate.Execute(w, map[string]any{"URL": url, "From": fromEtag, "To": toEtag, "Hunks": hunks})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(unifiedDiff(url+"@"+fromEtag, url+"@"+toEtag, hunks)))
+}
+
+// diffableLines splits a version into the lines compared by the diff: the
+// readable text for HTML and plain text, and the raw content otherwise.
+func diffableLines(obj Object) []string {
+	text := readableText(obj)
+	if text == "" {
+		text = string(obj.Content)
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,46 @@
+package blogproxy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := strings.Split("a b c d e f", " ")
+	b := strings.Split("a c d x e f g", " ")
+	lines, err := diffLines(a, b)
+	if err != nil {
+		t.Fatalf("diffLines: %v", err)
+	}
+
+	var got []string
+	for _, line := range lines {
+		got = append(got, string(" -+"[line.Op])+line.Text)
+	}
+	want := []string{" a", "-b", " c", " d", "+x", " e", " f", "+g"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("diffLines = %v, want %v", got, want)
+	}
+}
+
+func TestDiffLinesTooLarge(t *testing.T) {
+	if _, err := diffLines(make([]string, maxDiffLines+1), nil); !errors.Is(err, errDiffTooLarge) {
+		t.Errorf("diffLines of too many lines: err = %v, want errDiffTooLarge", err)
+	}
+
+	// every line differs, so the edit distance is the sum of the lengths
+	a := make([]string, maxDiffEdits)
+	b := make([]string, maxDiffEdits)
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i)
+		b[i] = "b" + strconv.Itoa(i)
+	}
+	if _, err := diffLines(a, b); !errors.Is(err, errDiffTooLarge) {
+		t.Errorf("diffLines of too different versions: err = %v, want errDiffTooLarge", err)
+	}
+	if _, err := diffLines(a[:maxDiffEdits/2], b[:maxDiffEdits/2]); err != nil {
+		t.Errorf("diffLines within the edit limit: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package blogproxy
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+var errCorruptObject error = &domainError{msg: "cached object corrupt", kind: ErrUnavailable}
+
+var cacheCorrupt = metrics.Counter(
+	"blogproxy_cache_corrupt_total",
+	"Objects read back from disk or a snapshot whose content no longer matches its digest.",
+)
+
+// digestNone turns content digests off.
+const digestNone = "none"
+
+// digestAlgorithms are the hashes content digests can be made with.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+func checkDigestAlgorithm(algorithm string) error {
+	if _, ok := digestAlgorithms[algorithm]; !ok && algorithm != digestNone {
+		return fmt.Errorf("unknown cache.digest %q, want sha256, sha512, crc32c or none", algorithm)
+	}
+	return nil
+}
+
+// contentDigest returns the digest of content, "algorithm:hex", empty when
+// algorithm is none.
+func contentDigest(algorithm string, content []byte) string {
+	newHash, ok := digestAlgorithms[algorithm]
+	if !ok {
+		return ""
+	}
+	h := newHash()
+	h.Write(content)
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the content of o, as stored, against its digest. Objects
+// stored without a digest, or with one of a hash since removed, pass.
+func (o Object) Verify() error {
+	algorithm, _, ok := strings.Cut(o.Digest, ":")
+	if !ok {
+		return nil
+	}
+	if _, known := digestAlgorithms[algorithm]; !known {
+		return nil
+	}
+	if got := contentDigest(algorithm, o.Content); got != o.Digest {
+		return fmt.Errorf("%w: content digest %s, want %s", errCorruptObject, got, o.Digest)
+	}
+	return nil
+}
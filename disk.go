@@ -0,0 +1,160 @@
+package blogproxy
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	log "log/slog"
+	"os"
+	"path/filepath"
+)
+
+// diskRecord is the on-disk form of a cached object.
+type diskRecord struct {
+	HostName string
+	PageName string
+	Object   Object
+	// Version is the cache.version the object was stored under
+	Version string
+}
+
+// DiskCache keeps objects as gob files in a directory, one file per page.
+// Objects of another cache version than its own are not read back.
+type DiskCache struct {
+	dir     string
+	version string
+	// onCorrupt is called with the pages found corrupt, which are deleted
+	onCorrupt func(hostName, pageName string)
+}
+
+func NewDiskCache(dir, version string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	return &DiskCache{dir: dir, version: version}, nil
+}
+
+// versionedKey returns the key of a page under a cache version, the plain
+// key for the empty version so caches predating versions stay valid.
+func versionedKey(version, hostName, pageName string) string {
+	if version == "" {
+		return cacheKey(hostName, pageName)
+	}
+	return version + "@" + cacheKey(hostName, pageName)
+}
+
+func (c *DiskCache) path(hostName, pageName string) string {
+	sum := sha256.Sum256([]byte(versionedKey(c.version, hostName, pageName)))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name+".obj")
+}
+
+func (c *DiskCache) Get(hostName, pageName string) (Object, bool) {
+	record, err := readDiskRecord(c.path(hostName, pageName))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Error("failed to read cached object", "host", hostName, "object", pageName, "error", err)
+		}
+		return Object{}, false
+	}
+	if record.HostName != hostName || record.PageName != pageName || record.Version != c.version {
+		return Object{}, false
+	}
+	if err := record.Object.Verify(); err != nil {
+		cacheCorrupt.Inc()
+		log.Error("dropping corrupt cached object", "host", hostName, "object", pageName, "error", err)
+		c.Delete(hostName, pageName)
+		if c.onCorrupt != nil {
+			c.onCorrupt(hostName, pageName)
+		}
+		return Object{}, false
+	}
+	return record.Object, true
+}
+
+// Contains reports whether a file is stored for pageName, without reading
+// or verifying it.
+func (c *DiskCache) Contains(hostName, pageName string) bool {
+	_, err := os.Stat(c.path(hostName, pageName))
+	return err == nil
+}
+
+func (c *DiskCache) Put(hostName, pageName string, obj Object) {
+	if err := c.write(hostName, pageName, obj); err != nil {
+		log.Error("failed to write cached object", "host", hostName, "object", pageName, "error", err)
+	}
+}
+
+func (c *DiskCache) write(hostName, pageName string, obj Object) error {
+	path := c.path(hostName, pageName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so readers never see half an object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	record := diskRecord{HostName: hostName, PageName: pageName, Object: obj, Version: c.version}
+	if err := gob.NewEncoder(tmp).Encode(record); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *DiskCache) Delete(hostName, pageName string) bool {
+	err := os.Remove(c.path(hostName, pageName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error("failed to delete cached object", "host", hostName, "object", pageName, "error", err)
+	}
+	return err == nil
+}
+
+func (c *DiskCache) List() []CacheEntry {
+	var entries []CacheEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".obj" {
+			return err
+		}
+		record, err := readDiskRecord(path)
+		if err != nil {
+			log.Error("failed to decode cached object", "path", path, "error", err)
+			return nil
+		}
+		if record.Version != c.version {
+			// left behind by a previous cache version
+			if err := os.Remove(path); err != nil {
+				log.Error("failed to delete object of another cache version", "path", path, "error", err)
+			}
+			return nil
+		}
+		entries = append(entries, CacheEntry{HostName: record.HostName, PageName: record.PageName, Object: record.Object})
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to list disk cache", "error", err)
+	}
+	return entries
+}
+
+func readDiskRecord(path string) (diskRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return diskRecord{}, err
+	}
+	defer f.Close()
+
+	var record diskRecord
+	err = gob.NewDecoder(f).Decode(&record)
+	return record, err
+}
@@ -0,0 +1,12 @@
+// Package blogproxy is a caching proxy for blogs. Storage fetches pages
+// from their origins and caches them; Server serves it over HTTP. The
+// blog-proxy command in cmd/blog-proxy runs both from a config file, and
+// programs embedding the proxy can build a Storage of their own:
+//
+//	s, err := blogproxy.NewStorage(ctx, blogproxy.WithAllowedHosts("paulgraham.com"))
+//	...
+//	obj, err := s.Get(ctx, "https://paulgraham.com", "greatwork.html")
+//	if errors.Is(err, blogproxy.ErrNotFound) {
+//		...
+//	}
+package blogproxy
@@ -0,0 +1,62 @@
+package blogproxy
+
+import (
+	"context"
+	log "log/slog"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var earlyRefreshes = metrics.Counter(
+	"blogproxy_cache_early_refreshes_total",
+	"Background refreshes of cached objects started before they expired, by result: ok, error or dropped.",
+	"result",
+)
+
+// earlyRefreshTimeout bounds a background refresh.
+const earlyRefreshTimeout = time.Minute
+
+// refreshEarly decides, as in XFetch, whether the fresh obj is refreshed
+// ahead of its expiry: it is when now, pushed forward by the time obj took
+// to fetch scaled by the beta and a random exponential factor, is past the
+// expiry. Few hits qualify long before expiry and most do right before it.
+func (s *Storage) refreshEarly(obj Object) bool {
+	if s.earlyRefresh <= 0 || obj.FetchDuration <= 0 {
+		return false
+	}
+	// 1 - rand.Float64() is in (0, 1], keeping the logarithm finite
+	gap := float64(obj.FetchDuration) * s.earlyRefresh * -math.Log(1-rand.Float64())
+	return float64(obj.ExpiryTime.Sub(s.clock.Now())) <= gap
+}
+
+// refreshInBackground fetches pageName again and caches it, unless it is
+// already being fetched again.
+func (s *Storage) refreshInBackground(ctx context.Context, hostName, pageName, namespace, stored string) {
+	key := cacheKey(namespace, stored)
+	if !s.revalidations.Start(key) {
+		return
+	}
+	// the tenant and language of the request still apply once it is done
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), earlyRefreshTimeout)
+	queued := s.workers.Submit("early_refresh", func() {
+		defer cancel()
+		defer s.revalidations.Done(key)
+
+		obj, err := s.fetchFromPeerOrOrigin(ctx, hostName, pageName)
+		if err != nil {
+			earlyRefreshes.Inc("error")
+			log.Warn("early refresh failed", "host", hostName, "object", pageName, "error", err)
+			return
+		}
+		earlyRefreshes.Inc("ok")
+		log.Debug("refreshed object early", "host", hostName, "object", pageName)
+		s.publish(eventRefresh, namespace, stored, "early")
+		s.store(ctx, hostName, pageName, namespace, stored, obj)
+	})
+	if !queued {
+		earlyRefreshes.Inc("dropped")
+		cancel()
+		s.revalidations.Done(key)
+	}
+}
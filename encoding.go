@@ -0,0 +1,90 @@
+package blogproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// originAcceptEncoding is the Accept-Encoding of origin fetches. Only the
+// codings the proxy can decode itself are asked for, since it must still
+// serve the clients that don't support them.
+const originAcceptEncoding = "gzip"
+
+// decodeContent returns content, compressed with encoding, decompressed.
+// When limit isn't zero, content decompressing to more than limit bytes is
+// errBodyTooLarge.
+func decodeContent(encoding string, content []byte, limit int64) ([]byte, error) {
+	switch encoding {
+	case "":
+		return content, nil
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if limit <= 0 {
+			return io.ReadAll(reader)
+		}
+		plain, err := io.ReadAll(io.LimitReader(reader, limit+1))
+		if err == nil && int64(len(plain)) > limit {
+			return nil, errBodyTooLarge
+		}
+		return plain, err
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// contentEncoding returns the coding of a Content-Encoding header, empty
+// for identity.
+func contentEncoding(header string) string {
+	encoding := strings.ToLower(strings.TrimSpace(header))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+// Decoded returns obj with its content decompressed, as it is parsed and
+// served to the clients that don't accept its encoding.
+func (obj Object) Decoded() (Object, error) {
+	if obj.ContentEncoding == "" {
+		return obj, nil
+	}
+	content, err := decodeContent(obj.ContentEncoding, obj.Content, 0)
+	if err != nil {
+		return Object{}, err
+	}
+	obj.Content, obj.ContentEncoding = content, ""
+	return obj, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding of r allows coding:
+// "gzip, deflate;q=0.5" allows gzip, "*;q=0" allows nothing.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case coding:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
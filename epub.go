@@ -0,0 +1,252 @@
+package blogproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	log "log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxEPUBChapters bounds the articles bundled into one EPUB.
+const maxEPUBChapters = 500
+
+type epubChapter struct {
+	Order int
+	ID    string
+	File  string
+	Title string
+	Page  readerPage
+}
+
+type epubBook struct {
+	ID       string
+	Title    string
+	Modified string
+	Chapters []epubChapter
+}
+
+var epubTemplates = template.Must(template.New("container").Parse(`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+func init() {
+	template.Must(epubTemplates.New("opf").Parse(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{.ID}}</dc:identifier>
+    <dc:title>{{.Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+{{- range .Chapters}}
+    <item id="{{.ID}}" href="{{.File}}" media-type="application/xhtml+xml"/>
+{{- end}}
+  </manifest>
+  <spine toc="ncx">
+{{- range .Chapters}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+	template.Must(epubTemplates.New("nav").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{.Title}}</title></head>
+<body>
+<nav epub:type="toc">
+<h1>{{.Title}}</h1>
+<ol>
+{{- range .Chapters}}
+<li><a href="{{.File}}">{{.Title}}</a></li>
+{{- end}}
+</ol>
+</nav>
+</body>
+</html>
+`))
+	template.Must(epubTemplates.New("ncx").Parse(`<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="{{.ID}}"/></head>
+<docTitle><text>{{.Title}}</text></docTitle>
+<navMap>
+{{- range $c := .Chapters}}
+<navPoint id="{{$c.ID}}" playOrder="{{$c.Order}}"><navLabel><text>{{$c.Title}}</text></navLabel><content src="{{$c.File}}"/></navPoint>
+{{- end}}
+</navMap>
+</ncx>
+`))
+	template.Must(epubTemplates.New("chapter").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+{{- range .Paragraphs}}
+<p>{{range .}}{{.Text}}{{end}}</p>
+{{- end}}
+<p><small>Original: <a href="{{.URL}}">{{.URL}}</a>, fetched {{.Fetched}}</small></p>
+</body>
+</html>
+`))
+}
+
+// renderEPUB bundles the entries, in order, into an EPUB 3 book modified
+// at now that also carries an NCX table of contents for older readers.
+func renderEPUB(title string, entries []CacheEntry, now time.Time) ([]byte, error) {
+	book := epubBook{
+		ID:       "urn:blog-proxy:" + newID(),
+		Title:    title,
+		Modified: now.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	for i, entry := range entries {
+		url := cacheKey(entry.HostName, entry.PageName)
+		page := newReaderPage(url, entry.Object, nil)
+		book.Chapters = append(book.Chapters, epubChapter{
+			Order: i + 1,
+			ID:    fmt.Sprintf("chapter%d", i+1),
+			File:  fmt.Sprintf("chapter%d.xhtml", i+1),
+			Title: page.Title,
+			Page:  page,
+		})
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// the mimetype entry must come first and be stored uncompressed
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	mimetype.Write([]byte("application/epub+zip"))
+
+	type epubFile struct {
+		name     string
+		template string
+		data     any
+	}
+	files := []epubFile{
+		{"META-INF/container.xml", "container", nil},
+		{"OEBPS/content.opf", "opf", book},
+		{"OEBPS/nav.xhtml", "nav", book},
+		{"OEBPS/toc.ncx", "ncx", book},
+	}
+	for _, chapter := range book.Chapters {
+		files = append(files, epubFile{"OEBPS/" + chapter.File, "chapter", chapter.Page})
+	}
+
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		// html/template would escape the XML declaration, so it is written
+		// outside the templates
+		io.WriteString(w, xml.Header)
+		if err := epubTemplates.ExecuteTemplate(w, file.template, file.data); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", file.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleEPUB bundles articles into an EPUB: all cached HTML pages of the
+// host parameter, or the pages named by repeated url parameters.
+func (srv *Server) handleEPUB(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var entries []CacheEntry
+	title := "Reading list"
+	if hostName := query.Get("host"); hostName != "" {
+		title = strings.TrimPrefix(strings.TrimPrefix(hostName, "https://"), "http://")
+		// the language variants of a page are listed apart, but make one
+		// chapter: the newest
+		index := make(map[string]int)
+		for _, entry := range srv.storage.List(r.Context(), hostName) {
+			if !isHTML(entry.Object.ContentType) {
+				continue
+			}
+			// the cache lists pages as stored, maybe compressed
+			obj, err := entry.Object.Decoded()
+			if err != nil {
+				log.Warn("skipping epub chapter", "url", cacheKey(entry.HostName, entry.PageName), "error", err)
+				continue
+			}
+			entry.Object = obj
+			if i, ok := index[entry.PageName]; ok {
+				if obj.UpdateTime.After(entries[i].Object.UpdateTime) {
+					entries[i] = entry
+				}
+				continue
+			}
+			index[entry.PageName] = len(entries)
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].PageName < entries[j].PageName })
+	} else {
+		// each url may be fetched from its origin, so their number is
+		// checked before any is
+		if len(query["url"]) > maxEPUBChapters {
+			http.Error(w, fmt.Sprintf("at most %d urls", maxEPUBChapters), http.StatusBadRequest)
+			return
+		}
+		for _, url := range query["url"] {
+			hostName, pageName, ok := parseTargetURL(url)
+			if !ok {
+				http.Error(w, "invalid url "+url, http.StatusBadRequest)
+				return
+			}
+			obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+			if err != nil {
+				log.Warn("skipping epub chapter", "url", url, "error", err)
+				continue
+			}
+			entries = append(entries, CacheEntry{HostName: hostName, PageName: pageName, Object: obj})
+		}
+	}
+	if t := query.Get("title"); t != "" {
+		title = t
+	}
+
+	if len(entries) == 0 {
+		http.Error(w, "no articles to bundle", http.StatusNotFound)
+		return
+	}
+	if len(entries) > maxEPUBChapters {
+		entries = entries[:maxEPUBChapters]
+	}
+
+	book, err := renderEPUB(title, entries, srv.storage.clock.Now())
+	if err != nil {
+		log.Error("failed to render epub", "error", err)
+		http.Error(w, "failed to render epub", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", epubFilename(title)))
+	w.Write(book)
+}
+
+func epubFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, title)
+	return name + ".epub"
+}
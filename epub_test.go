@@ -0,0 +1,96 @@
+package blogproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// readEPUB returns the files of the EPUB in body by name.
+func readEPUB(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("not an epub: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestEPUBCompressedPages(t *testing.T) {
+	// long enough to be deflated rather than stored in the gzip stream
+	page := "<html><head><title>Great Work</title></head><body>" + strings.Repeat("<p>How to do great work.</p>", 20) + "</body></html>"
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: gzipString(page), Header: http.Header{"Content-Encoding": {"gzip"}}},
+	})
+	proxy := newTestProxy(t, origin, nil)
+	if rec := proxy.Get("/essay.html", http.Header{"Accept-Encoding": {"gzip"}}); rec.Code != http.StatusOK {
+		t.Fatalf("GET /essay.html = %d", rec.Code)
+	}
+
+	rec := proxy.Serve(httptest.NewRequest(http.MethodGet, "/epub?host="+url.QueryEscape(origin.URL), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /epub = %d %s", rec.Code, rec.Body)
+	}
+	chapter := readEPUB(t, rec.Body.Bytes())["OEBPS/chapter1.xhtml"]
+	if !strings.Contains(chapter, "<h1>Great Work</h1>") || strings.Count(chapter, "How to do great work.") != 20 {
+		t.Errorf("chapter of the compressed page = %q", chapter)
+	}
+}
+
+func TestEPUBLanguageVariants(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay, Header: http.Header{"Vary": {"Accept-Language"}}},
+	})
+	proxy := newTestProxy(t, origin, nil)
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/essay.html", Header: http.Header{"Accept-Language": {"fr"}}, WantStatus: http.StatusOK, WantOriginRequests: 2},
+	})
+
+	rec := proxy.Serve(httptest.NewRequest(http.MethodGet, "/epub?host="+url.QueryEscape(origin.URL), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /epub = %d %s", rec.Code, rec.Body)
+	}
+	files := readEPUB(t, rec.Body.Bytes())
+	if _, ok := files["OEBPS/chapter2.xhtml"]; ok {
+		t.Error("the language variants of a page made several chapters")
+	}
+	if want := "<meta property=\"dcterms:modified\">2024-01-01T00:00:00Z</meta>"; !strings.Contains(files["OEBPS/content.opf"], want) {
+		t.Errorf("content.opf lacks %s:\n%s", want, files["OEBPS/content.opf"])
+	}
+}
+
+func TestEPUBTooManyURLs(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, nil)
+
+	query := url.Values{}
+	for i := 0; i <= maxEPUBChapters; i++ {
+		query.Add("url", origin.URL+"/essay.html")
+	}
+	rec := proxy.Serve(httptest.NewRequest(http.MethodGet, "/epub?"+query.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /epub with %d urls = %d, want 400", maxEPUBChapters+1, rec.Code)
+	}
+	if got := len(origin.Requests("/essay.html")); got != 0 {
+		t.Errorf("origin got %d requests, want none", got)
+	}
+}
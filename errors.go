@@ -0,0 +1,78 @@
+package blogproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The kinds of failure of Storage.Get and Storage.Lookup. The errors
+// returned wrap one of them, so callers branch with errors.Is:
+//
+//	obj, err := storage.Get(ctx, hostName, pageName)
+//	switch {
+//	case errors.Is(err, ErrNotAllowed):
+//	case errors.Is(err, ErrNotFound):
+//	...
+//	}
+//
+// Errors of the origin itself are an *UpstreamError, found with errors.As,
+// which is also ErrNotFound for the pages the origin doesn't have. The
+// messages are meant for logs and may change; the kinds won't.
+var (
+	// ErrNotAllowed is a page the proxy refuses: its host isn't allowed for
+	// the tenant, its path is denied, or its content type isn't allowed.
+	ErrNotAllowed = errors.New("not allowed")
+	// ErrNotFound is a page the origin doesn't have.
+	ErrNotFound = errors.New("not found")
+	// ErrTooLarge is a page bigger than fetch.max_body_bytes.
+	ErrTooLarge = errors.New("too large")
+	// ErrTimeout is a fetch that ran out of one of its deadlines.
+	ErrTimeout = errors.New("timed out")
+	// ErrUnavailable is a fetch the proxy declined to make for now, having too
+	// many in flight or no origin bandwidth left. Retrying later can work.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrInvalidArgument is a request naming no page, with an empty host or
+	// page name, or a page name with control characters.
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// domainError is an error of one of the kinds above with a message of its
+// own.
+type domainError struct {
+	msg  string
+	kind error
+}
+
+func (e *domainError) Error() string { return e.msg }
+
+func (e *domainError) Unwrap() error { return e.kind }
+
+// UpstreamError is a fetch that failed at the origin: it responded with a
+// status other than 200, or it couldn't be reached or read.
+type UpstreamError struct {
+	// Status is the status of the response, zero when there was none.
+	Status int
+	// Err is what went wrong when there was no response.
+	Err error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("origin responded with %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("origin failed: %v", e.Err)
+}
+
+// Unwrap returns Err, and ErrNotFound for the statuses telling the page
+// doesn't exist.
+func (e *UpstreamError) Unwrap() []error {
+	var errs []error
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	if e.Status == http.StatusNotFound || e.Status == http.StatusGone {
+		errs = append(errs, ErrNotFound)
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventsBuffer is how many events a subscriber may lag behind before the
+// next ones are dropped for it.
+const eventsBuffer = 256
+
+// eventsHeartbeat is how often an idle event stream gets a comment, so
+// proxies in between don't close it.
+const eventsHeartbeat = 15 * time.Second
+
+// Types of cache events.
+const (
+	eventHit      = "hit"
+	eventMiss     = "miss"
+	eventStale    = "stale"
+	eventRefresh  = "refresh"
+	eventEviction = "eviction"
+	eventPurge    = "purge"
+)
+
+// CacheEvent is something that happened to a cached page.
+type CacheEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	URL    string    `json:"url"`
+	// Reason tells why a stale copy was served or a page refreshed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CacheEvents fans the cache events out to the streams of /admin/events.
+// Events are dropped for the subscribers not keeping up rather than slowing
+// down the requests they come from.
+type CacheEvents struct {
+	mu          sync.RWMutex
+	subscribers map[chan CacheEvent]struct{}
+}
+
+func NewCacheEvents() *CacheEvents {
+	return &CacheEvents{subscribers: make(map[chan CacheEvent]struct{})}
+}
+
+// Publish sends event to every subscriber.
+func (e *CacheEvents) Publish(event CacheEvent) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of the events published from now on, and the
+// function to call once done with it.
+func (e *CacheEvents) Subscribe() (<-chan CacheEvent, func()) {
+	ch := make(chan CacheEvent, eventsBuffer)
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		delete(e.subscribers, ch)
+		e.mu.Unlock()
+	}
+}
+
+// publish records an event of type typ for pageName, as stored in the cache
+// under namespace.
+func (s *Storage) publish(typ, namespace, stored, reason string) {
+	tenant, hostName := splitNamespace(namespace)
+	pageName, _ := splitVariant(stored)
+	s.events.Publish(CacheEvent{
+		Type:   typ,
+		Time:   s.clock.Now(),
+		Tenant: tenant,
+		URL:    cacheKey(hostName, pageName),
+		Reason: reason,
+	})
+}
+
+// handleEvents streams the cache events as server-sent events. The type
+// query parameter, e.g. "miss,eviction", keeps only the events of these
+// types.
+func (srv *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types map[string]bool
+	if v := r.URL.Query().Get("type"); v != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(v, ",") {
+			types[strings.TrimSpace(typ)] = true
+		}
+	}
+
+	events, unsubscribe := srv.storage.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	if err := flusher.Flush(); err != nil {
+		log.Error("event stream can't be flushed", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-events:
+			if types != nil && !types[event.Type] {
+				continue
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+}
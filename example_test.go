@@ -0,0 +1,33 @@
+package blogproxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	blogproxy "github.com/priyanshujain/blog-proxy"
+)
+
+func ExampleNewStorage() {
+	ctx := context.Background()
+	s, err := blogproxy.NewStorage(ctx, blogproxy.WithAllowedHosts("paulgraham.com"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	obj, err := s.Get(ctx, "https://paulgraham.com", "greatwork.html")
+	var upstream *blogproxy.UpstreamError
+	switch {
+	case errors.Is(err, blogproxy.ErrNotFound):
+		fmt.Println("no such essay")
+	case errors.Is(err, blogproxy.ErrUnavailable), errors.Is(err, blogproxy.ErrTimeout):
+		fmt.Println("try again later")
+	case errors.As(err, &upstream):
+		fmt.Println("origin failed:", upstream.Status)
+	case err != nil:
+		log.Fatal(err)
+	default:
+		fmt.Println(obj.ContentType, len(obj.Content))
+	}
+}
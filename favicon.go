@@ -0,0 +1,42 @@
+package blogproxy
+
+import (
+	"bytes"
+	log "log/slog"
+	"net/http"
+	"net/url"
+)
+
+// handleFavicon serves the favicon of the origin a proxied page came from,
+// found through the url parameter of the Referer, or of the configured
+// default host. Without either, or when the origin has none, it answers
+// 204 so browsers stop asking without a 404 in the logs.
+func (srv *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	hostName := srv.cfg.Favicon.DefaultHost
+	if referer, err := url.Parse(r.Referer()); err == nil {
+		if refHost, _, ok := parseTargetURL(referer.Query().Get("url")); ok {
+			hostName = refHost
+		}
+	}
+	if hostName == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	obj, err := srv.storage.Get(r.Context(), hostName, "favicon.ico")
+	if err == nil {
+		obj, err = obj.Decoded()
+	}
+	if err != nil {
+		log.Debug("no favicon", "host", hostName, "error", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("ETag", obj.Etag)
+	// the icon depends on the Referer, which browsers don't vary on, so only
+	// let them keep it briefly
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "favicon.ico", obj.ModTime(), bytes.NewReader(obj.Content))
+}
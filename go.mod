@@ -1,3 +1,21 @@
 module github.com/priyanshujain/blog-proxy
 
 go 1.22.0
+
+require (
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/coreos/go-oidc/v3 v3.12.0
+	github.com/graph-gophers/graphql-go v1.5.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.33.0
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/go-jose/go-jose/v4 v4.0.2 // indirect
+	golang.org/x/oauth2 v0.21.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
@@ -0,0 +1,163 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	# pages lists the cached pages, of one host when host is given.
+	pages(host: String): [Page!]!
+	# page returns a cached page, or null when it is not cached.
+	page(url: String!): Page
+}
+
+type Mutation {
+	# purge drops a page from the cache and reports whether it was cached.
+	# A soft purge marks it stale instead, to be fetched again on the next
+	# request. Needs the admin token.
+	purge(url: String!, soft: Boolean = false): Boolean!
+}
+
+type Page {
+	url: String!
+	host: String!
+	title: String
+	wordCount: Int!
+	contentType: String!
+	size: Int!
+	etag: String!
+	lastFetched: Time!
+	expires: Time!
+}
+`
+
+// newGraphQLHandler serves the cached content metadata over GraphQL.
+func newGraphQLHandler(srv *Server) http.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{storage: srv.storage})
+	handler := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), adminKey, srv.isAdmin(r))
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type graphqlResolver struct {
+	storage *Storage
+}
+
+func (g *graphqlResolver) Pages(ctx context.Context, args struct{ Host *string }) []*pageResolver {
+	var hostName string
+	if args.Host != nil {
+		hostName = *args.Host
+	}
+
+	entries := g.storage.List(ctx, hostName)
+	sort.Slice(entries, func(i, j int) bool {
+		return cacheKey(entries[i].HostName, entries[i].PageName) < cacheKey(entries[j].HostName, entries[j].PageName)
+	})
+	pages := make([]*pageResolver, 0, len(entries))
+	for _, entry := range entries {
+		pages = append(pages, &pageResolver{entry: entry})
+	}
+	return pages
+}
+
+func (g *graphqlResolver) Page(ctx context.Context, args struct{ URL string }) *pageResolver {
+	hostName, pageName, ok := parseTargetURL(args.URL)
+	if !ok {
+		return nil
+	}
+	obj, ok := g.storage.Cached(ctx, hostName, pageName)
+	if !ok {
+		return nil
+	}
+	return &pageResolver{entry: CacheEntry{HostName: hostName, PageName: pageName, Object: obj}}
+}
+
+func (g *graphqlResolver) Purge(ctx context.Context, args struct {
+	URL  string
+	Soft bool
+}) (bool, error) {
+	if admin, _ := ctx.Value(adminKey).(bool); !admin {
+		return false, errors.New("admin token required")
+	}
+	hostName, pageName, ok := parseTargetURL(args.URL)
+	if !ok {
+		return false, errors.New("invalid url")
+	}
+	return g.storage.Purge(hostName, pageName, args.Soft), nil
+}
+
+type pageResolver struct {
+	entry CacheEntry
+}
+
+func (p *pageResolver) URL() string {
+	return cacheKey(p.entry.HostName, p.entry.PageName)
+}
+
+func (p *pageResolver) Host() string {
+	return p.entry.HostName
+}
+
+// content returns the decompressed content of the page, nil when it can't
+// be decoded.
+func (p *pageResolver) content() []byte {
+	obj, err := p.entry.Object.Decoded()
+	if err != nil {
+		return nil
+	}
+	return obj.Content
+}
+
+func (p *pageResolver) Title() *string {
+	if !isHTML(p.entry.Object.ContentType) {
+		return nil
+	}
+	title := extractTitle(p.content())
+	if title == "" {
+		return nil
+	}
+	return &title
+}
+
+func (p *pageResolver) WordCount() int32 {
+	if !isHTML(p.entry.Object.ContentType) {
+		return 0
+	}
+	return int32(wordCount(p.content()))
+}
+
+func (p *pageResolver) ContentType() string {
+	return p.entry.Object.ContentType
+}
+
+func (p *pageResolver) Size() int32 {
+	return int32(len(p.entry.Object.Content))
+}
+
+func (p *pageResolver) Etag() string {
+	return p.entry.Object.Etag
+}
+
+func (p *pageResolver) LastFetched() graphql.Time {
+	return graphql.Time{Time: p.entry.Object.UpdateTime}
+}
+
+func (p *pageResolver) Expires() graphql.Time {
+	return graphql.Time{Time: p.entry.Object.ExpiryTime}
+}
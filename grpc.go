@@ -0,0 +1,126 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	log "log/slog"
+	"strings"
+
+	"github.com/priyanshujain/blog-proxy/blogproxypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the BlogProxy gRPC service on top of Storage.
+type grpcServer struct {
+	blogproxypb.UnimplementedBlogProxyServer
+	storage *Storage
+}
+
+// NewGRPCServer creates the gRPC server. Every RPC needs the admin token,
+// like the /admin routes: the listener sits outside the HTTP middleware, so
+// GetObject would otherwise skip the authentication, client access rules and
+// tenant limits of the proxy route.
+func NewGRPCServer(cfg Config, storage *Storage) *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcAdminOnly(cfg.AdminToken)))
+	blogproxypb.RegisterBlogProxyServer(server, &grpcServer{storage: storage})
+	return server
+}
+
+func grpcAdminOnly(adminToken string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				token, _ = strings.CutPrefix(values[0], "Bearer ")
+			}
+		}
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			log.Warn("grpc admin access denied", "method", info.FullMethod)
+			return nil, status.Error(codes.PermissionDenied, "admin token required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *grpcServer) GetObject(ctx context.Context, req *blogproxypb.GetObjectRequest) (*blogproxypb.GetObjectResponse, error) {
+	hostName, pageName, ok := parseTargetURL(req.GetUrl())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid url")
+	}
+
+	obj, err := g.storage.Get(ctx, hostName, pageName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	pb := objectToProto(hostName, pageName, obj)
+	pb.Content = obj.Content
+	return &blogproxypb.GetObjectResponse{Object: pb}, nil
+}
+
+func (g *grpcServer) PurgeObject(ctx context.Context, req *blogproxypb.PurgeObjectRequest) (*blogproxypb.PurgeObjectResponse, error) {
+	hostName, pageName, ok := parseTargetURL(req.GetUrl())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid url")
+	}
+	return &blogproxypb.PurgeObjectResponse{Purged: g.storage.Purge(hostName, pageName, false)}, nil
+}
+
+func (g *grpcServer) ListCached(ctx context.Context, req *blogproxypb.ListCachedRequest) (*blogproxypb.ListCachedResponse, error) {
+	entries := g.storage.List(ctx, req.GetHost())
+	resp := &blogproxypb.ListCachedResponse{Objects: make([]*blogproxypb.Object, 0, len(entries))}
+	for _, entry := range entries {
+		resp.Objects = append(resp.Objects, objectToProto(entry.HostName, entry.PageName, entry.Object))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Stats(ctx context.Context, req *blogproxypb.StatsRequest) (*blogproxypb.StatsResponse, error) {
+	stats := g.storage.Stats()
+	return &blogproxypb.StatsResponse{
+		Objects:      stats.Objects,
+		ContentBytes: stats.ContentBytes,
+		MemoryBytes:  stats.MemoryBytes,
+		MemoryHits:   stats.MemoryHits,
+		DiskHits:     stats.DiskHits,
+		Misses:       stats.Misses,
+	}, nil
+}
+
+// objectToProto converts obj to its API form, leaving out the content.
+func objectToProto(hostName, pageName string, obj Object) *blogproxypb.Object {
+	return &blogproxypb.Object{
+		Url:         cacheKey(hostName, pageName),
+		Etag:        obj.Etag,
+		ContentType: obj.ContentType,
+		Size:        int64(len(obj.Content)),
+		UpdateTime:  timestamppb.New(obj.UpdateTime),
+		ExpiryTime:  timestamppb.New(obj.ExpiryTime),
+	}
+}
+
+// grpcError maps storage errors to the status codes matching the HTTP
+// route's responses.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errHostNotAllowed), errors.Is(err, errPathDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errUnsupportedMediaType):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errBudgetExceeded), errors.Is(err, ErrTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, errOverloaded), errors.Is(err, errCircuitOpen), errors.Is(err, errCorruptObject), errors.Is(err, errMaintenance):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errOriginTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.NotFound, err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package blogproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	log "log/slog"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// handoffRequest opens the connection of a process taking over.
+const handoffRequest = "blog-proxy handoff 1\n"
+
+// Handoff passes the memory cache from the running proxy to the process
+// replacing it on a deploy, so the new process starts warm even without
+// a disk cache. The new process connects to the socket of the old one,
+// which sends its cache in the snapshot format, stops serving and closes
+// the connection; the new process then starts serving and listens on the
+// socket in its turn.
+type Handoff struct {
+	storage *Storage
+	path    string
+	timeout time.Duration
+}
+
+func NewHandoff(cfg HandoffConfig, storage *Storage) *Handoff {
+	return &Handoff{storage: storage, path: cfg.Socket, timeout: time.Duration(cfg.Timeout)}
+}
+
+// Receive takes the cache over from the process listening on the socket
+// and returns once that process has stopped serving. With no process
+// listening there is nothing to take over and Receive returns at once.
+func (h *Handoff) Receive() (int, error) {
+	conn, err := net.DialTimeout("unix", h.path, h.timeout)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %w", h.path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(h.timeout))
+
+	if _, err := io.WriteString(conn, handoffRequest); err != nil {
+		return 0, fmt.Errorf("failed to request the handoff: %w", err)
+	}
+	n, err := h.storage.Restore(conn)
+	// the old process closes the connection once it stopped serving,
+	// whether or not the cache got through
+	io.Copy(io.Discard, conn)
+	return n, err
+}
+
+// Serve listens on the socket until a process takes over. It then sends
+// that process the memory cache and calls stop, which stops the servers
+// of this process.
+func (h *Handoff) Serve(stop func()) error {
+	// a crashed process leaves its socket behind
+	if err := os.Remove(h.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", h.path)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		if !h.requested(conn) {
+			conn.Close()
+			continue
+		}
+		// the socket is removed before the new process listens on it
+		ln.Close()
+		h.send(conn)
+		stop()
+		conn.Close()
+		return nil
+	}
+}
+
+// requested reports whether conn comes from a process taking over.
+func (h *Handoff) requested(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(h.timeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, int64(len(handoffRequest)))).ReadString('\n')
+	return err == nil && line == handoffRequest
+}
+
+func (h *Handoff) send(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(h.timeout))
+	entries := h.storage.cache.List()
+	if h.storage.memory != nil {
+		// the new process reads the disk tier itself
+		entries = h.storage.memory.List()
+	}
+	n, err := writeSnapshot(conn, h.storage.cacheVersion, entries)
+	if err != nil {
+		log.Error("failed to hand the cache off", "sent", n, "error", err)
+		return
+	}
+	log.Info("handed the cache off", "objects", n)
+}
@@ -0,0 +1,56 @@
+package blogproxy
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	cfg := HandoffConfig{Socket: filepath.Join(t.TempDir(), "handoff.sock"), Timeout: Duration(5 * time.Second)}
+
+	// with no process to take over from, there is nothing to receive
+	old := newTestProxy(t, origin, nil)
+	if n, err := NewHandoff(cfg, old.storage).Receive(); n != 0 || err != nil {
+		t.Fatalf("Receive with no old process = %d, %v, want 0, nil", n, err)
+	}
+	if rec := old.Get("/essay.html", nil); rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want 200", rec.Code)
+	}
+
+	stopped := make(chan struct{})
+	served := make(chan error, 1)
+	go func() { served <- NewHandoff(cfg, old.storage).Serve(func() { close(stopped) }) }()
+	// wait for the socket
+	for i := 0; ; i++ {
+		if conn, err := net.Dial("unix", cfg.Socket); err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal("the old process never listened")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	next := newTestProxy(t, origin, nil)
+	n, err := NewHandoff(cfg, next.storage).Receive()
+	if n != 1 || err != nil {
+		t.Fatalf("Receive = %d, %v, want 1, nil", n, err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Receive returned before the old process stopped")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+
+	next.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: essay, WantOriginRequests: 1},
+	})
+}
@@ -0,0 +1,214 @@
+package blogproxy
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHostname is the Host of the requests sent with httptest.NewRequest;
+// the test tenant is resolved from it.
+const testHostname = "example.com"
+
+// originPage is a response of the fake origin.
+type originPage struct {
+	Status      int
+	ContentType string
+	Body        string
+	// Languages holds the bodies sent instead of Body to the requests
+	// whose Accept-Language is one of its keys.
+	Languages map[string]string
+	Header    http.Header
+	// Latency delays the response headers.
+	Latency time.Duration
+}
+
+// fakeOrigin is an origin server whose pages the tests set up and whose
+// requests they count.
+type fakeOrigin struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	pages    map[string]originPage
+	requests map[string][]*http.Request
+}
+
+func newFakeOrigin(t *testing.T, pages map[string]originPage) *fakeOrigin {
+	t.Helper()
+	o := &fakeOrigin{pages: make(map[string]originPage), requests: make(map[string][]*http.Request)}
+	for path, page := range pages {
+		o.pages[path] = page
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	t.Cleanup(o.Close)
+	return o
+}
+
+func (o *fakeOrigin) serve(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	page, ok := o.pages[r.URL.Path]
+	o.requests[r.URL.Path] = append(o.requests[r.URL.Path], r)
+	o.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if page.Latency > 0 {
+		select {
+		case <-time.After(page.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	for name, values := range page.Header {
+		w.Header()[name] = values
+	}
+	contentType := page.ContentType
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	status := page.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	body, ok := page.Languages[r.Header.Get("Accept-Language")]
+	if !ok {
+		body = page.Body
+	}
+	w.Write([]byte(body))
+}
+
+// Set replaces the page at path.
+func (o *fakeOrigin) Set(path string, page originPage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pages[path] = page
+}
+
+// Requests returns the requests the origin got for path.
+func (o *fakeOrigin) Requests(path string) []*http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[path]
+}
+
+// testProxy is the whole proxy handler in front of a fake origin.
+type testProxy struct {
+	handler http.Handler
+	storage *Storage
+	origin  *fakeOrigin
+	clock   *fakeClock
+}
+
+// newTestProxy builds the proxy with the default config, edited by
+// configure when it isn't nil, and a tenant for testHostname allowed to
+// proxy origin.
+func newTestProxy(t *testing.T, origin *fakeOrigin, configure func(*Config)) *testProxy {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Tenants = []TenantConfig{{
+		Name:         "test",
+		Hostnames:    []string{testHostname},
+		AllowedHosts: []string{origin.URL},
+	}}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	clock := newFakeClock()
+	storage, err := NewStorage(context.Background(), WithConfig(cfg), withClock(clock))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	srv, err := NewServer(cfg, storage)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return &testProxy{handler: srv.Handler(), storage: storage, origin: origin, clock: clock}
+}
+
+// Get requests the page at path of the origin through the proxy.
+func (p *testProxy) Get(path string, header http.Header) *httptest.ResponseRecorder {
+	return p.GetURL(p.origin.URL+path, header)
+}
+
+// GetURL requests target through the proxy.
+func (p *testProxy) GetURL(target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/?url="+url.QueryEscape(target), nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	p.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// Serve sends req to the proxy.
+func (p *testProxy) Serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// gzipString compresses s as a gzip origin would.
+func gzipString(s string) string {
+	var buf strings.Builder
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.String()
+}
+
+// proxyStep is one request of an end-to-end test.
+type proxyStep struct {
+	// Advance moves the clock before the request.
+	Advance time.Duration
+	// Origin replaces pages of the origin before the request.
+	Origin map[string]originPage
+	Path   string
+	Header http.Header
+
+	WantStatus int
+	// WantBody, when set, must be contained in the response body.
+	WantBody   string
+	WantHeader map[string]string
+	// WantOriginRequests is the number of requests the origin must have
+	// got for Path after the step.
+	WantOriginRequests int
+}
+
+func (p *testProxy) run(t *testing.T, steps []proxyStep) {
+	t.Helper()
+	for i, step := range steps {
+		p.clock.Advance(step.Advance)
+		for path, page := range step.Origin {
+			p.origin.Set(path, page)
+		}
+
+		rec := p.Get(step.Path, step.Header)
+		if rec.Code != step.WantStatus {
+			t.Errorf("step %d: GET %s = %d, want %d", i, step.Path, rec.Code, step.WantStatus)
+		}
+		if !strings.Contains(rec.Body.String(), step.WantBody) {
+			t.Errorf("step %d: body %q lacks %q", i, rec.Body.String(), step.WantBody)
+		}
+		for name, want := range step.WantHeader {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("step %d: header %s = %q, want %q", i, name, got, want)
+			}
+		}
+		if got := len(p.origin.Requests(step.Path)); got != step.WantOriginRequests {
+			t.Errorf("step %d: origin got %d requests for %s, want %d", i, got, step.Path, step.WantOriginRequests)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// HeaderRule adds headers to the proxied responses it matches. Empty Host,
+// Path or ContentType match anything.
+type HeaderRule struct {
+	// Host is the host name the rule applies to, e.g.
+	// "https://paulgraham.com".
+	Host string `json:"host"`
+	// Path is a regular expression matched against the page path with a
+	// leading slash: `\.woff2$`.
+	Path string `json:"path"`
+	// ContentType is a media type, possibly with a wildcard subtype:
+	// "image/*".
+	ContentType string `json:"content_type"`
+	// Headers are set on the response, replacing the proxy's own value.
+	// An empty value removes the header.
+	Headers map[string]string `json:"headers"`
+}
+
+// reservedHeaders describe the body and can't be set by header rules.
+var reservedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Content-Range":     true,
+	"Transfer-Encoding": true,
+}
+
+type headerRule struct {
+	HeaderRule
+	path *regexp.Regexp
+}
+
+// HeaderRules are the compiled response header rules. Every matching rule
+// applies, in order, so a later rule overrides an earlier one.
+type HeaderRules []headerRule
+
+func NewHeaderRules(rules []HeaderRule) (HeaderRules, error) {
+	compiled := make(HeaderRules, 0, len(rules))
+	for i, rule := range rules {
+		for name := range rule.Headers {
+			if reservedHeaders[http.CanonicalHeaderKey(name)] {
+				return nil, fmt.Errorf("response_headers[%d]: %s can't be set", i, name)
+			}
+		}
+		var path *regexp.Regexp
+		if rule.Path != "" {
+			re, err := regexp.Compile(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("response_headers[%d]: invalid path pattern %q: %w", i, rule.Path, err)
+			}
+			path = re
+		}
+		if rule.Host != "" {
+			rule.Host = canonicalHost(rule.Host)
+		}
+		compiled = append(compiled, headerRule{HeaderRule: rule, path: path})
+	}
+	return compiled, nil
+}
+
+// Apply sets the headers of the rules matching pageName on hostName with
+// contentType. It runs after the proxy has set its own headers.
+func (h HeaderRules) Apply(header http.Header, hostName, pageName, contentType string) {
+	for _, rule := range h {
+		if rule.Host != "" && rule.Host != hostName {
+			continue
+		}
+		if rule.path != nil && !rule.path.MatchString("/"+pageName) {
+			continue
+		}
+		if rule.ContentType != "" && !(MediaTypes{rule.ContentType}).Allowed(parseMediaType(contentType)) {
+			continue
+		}
+		for name, value := range rule.Headers {
+			if value == "" {
+				header.Del(name)
+			} else {
+				header.Set(name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package blogproxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	log "log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// History keeps the last versions of every page, so revisions of an essay
+// can be compared. A new version is recorded whenever a fetch returns
+// content with a different etag than the latest one.
+type History struct {
+	// dir persists the versions, one file per page, which are then read
+	// back from disk when asked for. Empty keeps them in memory, up to
+	// maxBytes of content.
+	dir         string
+	maxVersions int
+	maxBytes    int64
+
+	// writes serializes the updates of the files of a page, striped by
+	// page so the pages don't wait for each other's disk writes
+	writes [historyStripes]sync.Mutex
+
+	mu    sync.Mutex
+	pages map[string]*historyPage
+	// lru orders the pages held in memory by their last recorded version,
+	// the most recent at the front
+	lru  *list.List
+	size int64
+}
+
+const historyStripes = 64
+
+type historyPage struct {
+	url      string
+	versions []Object
+	size     int64
+	elem     *list.Element
+}
+
+// NewHistory keeps up to maxVersions versions per page. A non-empty dir
+// persists them; otherwise they are held in memory, and the pages with the
+// oldest changes are dropped past maxBytes of content. Zero means no limit.
+func NewHistory(dir string, maxVersions int, maxBytes int64) (*History, error) {
+	h := &History{
+		maxVersions: maxVersions,
+		maxBytes:    maxBytes,
+		pages:       make(map[string]*historyPage),
+		lru:         list.New(),
+	}
+	if dir != "" {
+		h.dir = filepath.Join(dir, "history")
+		if err := os.MkdirAll(h.dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *History) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(h.dir, hex.EncodeToString(sum[:])+".gob")
+}
+
+// load reads the versions of url from disk.
+func (h *History) load(url string) []Object {
+	var versions []Object
+	if err := loadGob(h.path(url), &versions); err != nil {
+		log.Error("failed to load page history", "url", url, "error", err)
+	}
+	return versions
+}
+
+// Record adds obj as the newest version of pageName on hostName unless it
+// has the same content as the current newest.
+func (h *History) Record(hostName, pageName string, obj Object) {
+	if h.maxVersions <= 0 {
+		return
+	}
+	url := cacheKey(hostName, pageName)
+	if h.dir == "" {
+		h.record(url, obj)
+		return
+	}
+
+	stripe := h.stripe(url)
+	stripe.Lock()
+	defer stripe.Unlock()
+	versions := h.load(url)
+	if n := len(versions); n > 0 && versions[n-1].Etag == obj.Etag {
+		return
+	}
+	versions = append(versions, obj)
+	if len(versions) > h.maxVersions {
+		versions = versions[len(versions)-h.maxVersions:]
+	}
+	if err := saveGob(h.path(url), versions); err != nil {
+		log.Error("failed to save page history", "url", url, "error", err)
+	}
+}
+
+// stripe returns the lock of the files of url.
+func (h *History) stripe(url string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(url))
+	return &h.writes[hash.Sum32()%historyStripes]
+}
+
+// record adds obj to the versions of url held in memory, dropping the
+// oldest versions, then the least recently changed pages, past the limits.
+func (h *History) record(url string, obj Object) {
+	if h.maxBytes > 0 && int64(len(obj.Content)) > h.maxBytes {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	page, ok := h.pages[url]
+	if !ok {
+		page = &historyPage{url: url}
+		page.elem = h.lru.PushFront(page)
+		h.pages[url] = page
+	}
+	if n := len(page.versions); n > 0 && page.versions[n-1].Etag == obj.Etag {
+		return
+	}
+	h.lru.MoveToFront(page.elem)
+	page.versions = append(page.versions, obj)
+	page.size += int64(len(obj.Content))
+	h.size += int64(len(obj.Content))
+	for len(page.versions) > h.maxVersions {
+		h.dropOldest(page)
+	}
+
+	for h.maxBytes > 0 && h.size > h.maxBytes {
+		victim := h.lru.Back().Value.(*historyPage)
+		if victim == page {
+			// only the page just recorded is left
+			h.dropOldest(page)
+			continue
+		}
+		h.lru.Remove(victim.elem)
+		delete(h.pages, victim.url)
+		h.size -= victim.size
+	}
+}
+
+// dropOldest forgets the oldest version of page. The caller must hold the
+// lock.
+func (h *History) dropOldest(page *historyPage) {
+	size := int64(len(page.versions[0].Content))
+	page.versions = append([]Object(nil), page.versions[1:]...)
+	page.size -= size
+	h.size -= size
+}
+
+// Versions returns the recorded versions of a page, oldest first.
+func (h *History) Versions(hostName, pageName string) []Object {
+	url := cacheKey(hostName, pageName)
+	if h.dir != "" {
+		// files are replaced atomically, so they need no lock to be read
+		return h.load(url)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	page, ok := h.pages[url]
+	if !ok {
+		return nil
+	}
+	return append([]Object(nil), page.versions...)
+}
+
+// Version returns the version of a page with the given etag.
+func (h *History) Version(hostName, pageName, etag string) (Object, bool) {
+	for _, obj := range h.Versions(hostName, pageName) {
+		if obj.Etag == etag {
+			return obj, true
+		}
+	}
+	return Object{}, false
+}
+
+type versionInfo struct {
+	Etag      string    `json:"etag"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Size      int       `json:"size"`
+}
+
+// handleVersions lists the recorded versions of a page, oldest first.
+func (srv *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	versions := srv.storage.Versions(r.Context(), hostName, pageName)
+	infos := make([]versionInfo, 0, len(versions))
+	for _, obj := range versions {
+		infos = append(infos, versionInfo{Etag: obj.Etag, FetchedAt: obj.UpdateTime, Size: len(obj.Content)})
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
@@ -0,0 +1,65 @@
+package blogproxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHistoryMemoryLimit(t *testing.T) {
+	h, err := NewHistory("", 3, 100)
+	if err != nil {
+		t.Fatalf("NewHistory: %v", err)
+	}
+	version := func(i int) Object {
+		return Object{Etag: fmt.Sprint(i), Content: []byte(strings.Repeat("x", 30))}
+	}
+
+	for i := range 4 {
+		h.Record("https://a.example", "p", version(i))
+	}
+	if got := len(h.Versions("https://a.example", "p")); got != 3 {
+		t.Errorf("kept %d versions, want 3", got)
+	}
+
+	// a second page pushes the total past 100 bytes, which drops the first
+	// page, changed least recently
+	h.Record("https://b.example", "p", version(0))
+	h.Record("https://b.example", "p", version(1))
+	if got := len(h.Versions("https://a.example", "p")); got != 0 {
+		t.Errorf("kept %d versions of the least recent page, want 0", got)
+	}
+	if got := len(h.Versions("https://b.example", "p")); got != 2 {
+		t.Errorf("kept %d versions of the recent page, want 2", got)
+	}
+
+	// a version bigger than the limit is not recorded at all
+	h.Record("https://c.example", "p", Object{Etag: "big", Content: make([]byte, 101)})
+	if got := len(h.Versions("https://c.example", "p")); got != 0 {
+		t.Errorf("kept %d versions over the limit, want 0", got)
+	}
+}
+
+func TestHistoryDisk(t *testing.T) {
+	dir := t.TempDir()
+	h, err := NewHistory(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("NewHistory: %v", err)
+	}
+	for _, etag := range []string{"1", "1", "2", "3"} {
+		h.Record("https://a.example", "p", Object{Etag: etag, Content: []byte(etag)})
+	}
+	if len(h.pages) != 0 {
+		t.Errorf("history with a dir holds %d pages in memory", len(h.pages))
+	}
+
+	// a new process reads them back
+	h, err = NewHistory(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("NewHistory: %v", err)
+	}
+	versions := h.Versions("https://a.example", "p")
+	if len(versions) != 2 || versions[0].Etag != "2" || versions[1].Etag != "3" {
+		t.Errorf("Versions = %+v, want etags 2 and 3", versions)
+	}
+}
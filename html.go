@@ -0,0 +1,165 @@
+package blogproxy
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// isHTML reports whether contentType is an HTML document.
+func isHTML(contentType string) bool {
+	return parseMediaType(contentType) == "text/html"
+}
+
+// extractLinks returns the targets of the <a href> elements in content,
+// resolved against base. Fragments are dropped and duplicates removed.
+func extractLinks(base *url.URL, content []byte) []*url.URL {
+	var links []*url.URL
+	seen := make(map[string]struct{})
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" || !hasAttr {
+				continue
+			}
+			for {
+				key, val, more := z.TagAttr()
+				if string(key) == "href" {
+					if link := resolveLink(base, string(val)); link != nil {
+						if _, ok := seen[link.String()]; !ok {
+							seen[link.String()] = struct{}{}
+							links = append(links, link)
+						}
+					}
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}
+}
+
+func resolveLink(base *url.URL, href string) *url.URL {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return nil
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil
+	}
+	link := base.ResolveReference(ref)
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return nil
+	}
+	link.Fragment = ""
+	link.RawFragment = ""
+	return link
+}
+
+// skipTextTags are elements whose content is not part of the readable text.
+var skipTextTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"head":     true,
+	"title":    true,
+}
+
+// extractTitle returns the text of the <title> element.
+func extractTitle(content []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	inTitle := false
+	var title strings.Builder
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(title.String()), " ")
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			inTitle = string(name) == "title"
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == "title" {
+				return strings.Join(strings.Fields(title.String()), " ")
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		}
+	}
+}
+
+// extractText returns the readable text of an HTML document with whitespace
+// collapsed. Block level elements start a new line.
+func extractText(content []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	var text strings.Builder
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return collapseText(text.String())
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if skipTextTags[string(name)] {
+				skip++
+			}
+			if isBlockTag(string(name)) {
+				text.WriteByte('\n')
+			}
+		case html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if string(name) == "br" {
+				text.WriteByte('\n')
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if skipTextTags[string(name)] && skip > 0 {
+				skip--
+			}
+			if isBlockTag(string(name)) {
+				text.WriteByte('\n')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(z.Text())
+			}
+		}
+	}
+}
+
+func isBlockTag(name string) bool {
+	switch name {
+	case "p", "div", "br", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6",
+		"blockquote", "pre", "section", "article", "header", "footer", "table":
+		return true
+	}
+	return false
+}
+
+// collapseText squeezes runs of spaces within lines and drops empty lines.
+func collapseText(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wordCount returns the number of words in the readable text of content.
+func wordCount(content []byte) int {
+	return len(strings.Fields(extractText(content)))
+}
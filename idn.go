@@ -0,0 +1,74 @@
+package blogproxy
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// displayHost returns host, "name[:port]", with an internationalized name
+// in Unicode, "bücher.example" for both itself and "xn--bcher-kva.example",
+// so either spelling of a target is logged, allowed and cached alike. ok
+// is false for a name IDNA rejects.
+func displayHost(host string) (string, bool) {
+	name, port := splitPort(host)
+	if !isIDN(name) {
+		return host, true
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", false
+	}
+	display, err := idna.Lookup.ToUnicode(ascii)
+	if err != nil {
+		return "", false
+	}
+	return joinPort(display, port), true
+}
+
+// asciiHostName returns hostName, "scheme://host", with its host in
+// punycode, the form the origin is fetched by.
+func asciiHostName(hostName string) string {
+	scheme, host, ok := strings.Cut(hostName, "://")
+	if !ok {
+		return hostName
+	}
+	name, port := splitPort(host)
+	if !isIDN(name) {
+		return hostName
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		// the fetch fails on the name as it is
+		return hostName
+	}
+	return scheme + "://" + joinPort(ascii, port)
+}
+
+// isIDN reports whether name is internationalized, spelled in Unicode or
+// in punycode.
+func isIDN(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 {
+			return true
+		}
+	}
+	return strings.HasPrefix(strings.ToLower(name), "xn--") || strings.Contains(strings.ToLower(name), ".xn--")
+}
+
+// splitPort splits host into its name and port, empty when it has none.
+// IPv6 addresses keep their brackets.
+func splitPort(host string) (name, port string) {
+	if name, port, err := net.SplitHostPort(host); err == nil && !strings.Contains(name, ":") {
+		return name, port
+	}
+	return host, ""
+}
+
+func joinPort(name, port string) string {
+	if port == "" {
+		return name
+	}
+	return name + ":" + port
+}
@@ -0,0 +1,74 @@
+package blogproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTargetURLIDN(t *testing.T) {
+	tests := []struct {
+		url       string
+		hostName  string
+		asciiHost string
+	}{
+		{"https://bücher.example/essay.html", "https://bücher.example", "https://xn--bcher-kva.example"},
+		// already encoded
+		{"https://xn--bcher-kva.example/essay.html", "https://bücher.example", "https://xn--bcher-kva.example"},
+		{"https://XN--BCHER-KVA.example/essay.html", "https://bücher.example", "https://xn--bcher-kva.example"},
+		{"https://BÜCHER.example:8443/essay.html", "https://bücher.example:8443", "https://xn--bcher-kva.example:8443"},
+		// ASCII and internationalized labels
+		{"https://blog.xn--bcher-kva.example/essay.html", "https://blog.bücher.example", "https://blog.xn--bcher-kva.example"},
+		// Latin with a Cyrillic "а" is not the ASCII name it looks like
+		{"https://pаypal.com/essay.html", "https://pаypal.com", "https://xn--pypal-4ve.com"},
+		{"https://paulgraham.com/essay.html", "https://paulgraham.com", "https://paulgraham.com"},
+		{"http://[::1]:8080/essay.html", "http://[::1]:8080", "http://[::1]:8080"},
+	}
+	for _, tt := range tests {
+		hostName, pageName, ok := parseTargetURL(tt.url)
+		if !ok || hostName != tt.hostName || pageName != "essay.html" {
+			t.Errorf("parseTargetURL(%q) = %q, %q, %v, want %q, essay.html", tt.url, hostName, pageName, ok, tt.hostName)
+			continue
+		}
+		if got := asciiHostName(hostName); got != tt.asciiHost {
+			t.Errorf("asciiHostName(%q) = %q, want %q", hostName, got, tt.asciiHost)
+		}
+	}
+
+	if _, _, ok := parseTargetURL("https://xn--a.example/essay.html"); ok {
+		t.Error("parseTargetURL accepted invalid punycode")
+	}
+}
+
+func TestFetchIDN(t *testing.T) {
+	var fetched []string
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		fetched = append(fetched, r.URL.Host)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html"}},
+			Body:       io.NopCloser(strings.NewReader(essay)),
+			Request:    r,
+		}, nil
+	})}
+	ctx := context.Background()
+	storage, err := NewStorage(ctx, WithAllowedHosts("xn--bcher-kva.example"), WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	for _, target := range []string{"https://bücher.example/essay.html", "https://xn--bcher-kva.example/essay.html"} {
+		hostName, pageName, _ := parseTargetURL(target)
+		if _, err := storage.Get(ctx, hostName, pageName); err != nil {
+			t.Fatalf("Get %s: %v", target, err)
+		}
+	}
+	if len(fetched) != 1 || fetched[0] != "xn--bcher-kva.example" {
+		t.Errorf("fetched %q, want xn--bcher-kva.example once", fetched)
+	}
+	if _, ok := storage.cache.Get("https://bücher.example", "essay.html"); !ok {
+		t.Error("the page isn't cached under the Unicode host name")
+	}
+}
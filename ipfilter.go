@@ -0,0 +1,107 @@
+package blogproxy
+
+import (
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var clientsDenied = metrics.Counter(
+	"blogproxy_client_denied_total",
+	"Requests refused by the client address rules.",
+	"scope",
+)
+
+// IPRules decides from its address whether a client may use a set of
+// routes. Deny entries win over allow entries; with no allow entries every
+// address not denied is allowed.
+type IPRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func NewIPRules(cfg IPRulesConfig) (IPRules, error) {
+	var rules IPRules
+	for _, entry := range cfg.Allow {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return IPRules{}, fmt.Errorf("invalid allowed client %q: %w", entry, err)
+		}
+		rules.allow = append(rules.allow, prefix)
+	}
+	for _, entry := range cfg.Deny {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return IPRules{}, fmt.Errorf("invalid denied client %q: %w", entry, err)
+		}
+		rules.deny = append(rules.deny, prefix)
+	}
+	return rules, nil
+}
+
+func (rules IPRules) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if containsAddr(rules.deny, addr) {
+		return false
+	}
+	return len(rules.allow) == 0 || containsAddr(rules.allow, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientFilter holds the address rules of the proxy and the admin routes.
+type ClientFilter struct {
+	proxy IPRules
+	admin IPRules
+}
+
+func NewClientFilter(cfg ClientAccessConfig) (*ClientFilter, error) {
+	proxy, err := NewIPRules(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := NewIPRules(cfg.Admin)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientFilter{proxy: proxy, admin: admin}, nil
+}
+
+// adminAllowed reports whether the admin rules let addr in.
+func (f *ClientFilter) adminAllowed(addr netip.Addr) bool {
+	return f.admin.Allowed(addr)
+}
+
+// Middleware refuses clients the rules for the requested route don't allow.
+// It reads the client address, so it goes after TrustedProxies.Middleware.
+func (f *ClientFilter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope, rules := "proxy", f.proxy
+			if isAdminPath(r.URL.Path) {
+				scope, rules = "admin", f.admin
+			}
+			if client := ClientIP(r.Context()); !rules.Allowed(client) {
+				clientsDenied.Inc(scope)
+				log.Warn("client denied", "scope", scope, "client", client, "path", r.URL.Path)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isAdminPath reports whether path is one of the admin routes.
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || path == "/stats" || path == "/metrics"
+}
@@ -0,0 +1,116 @@
+package blogproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPRules(t *testing.T) {
+	rules, err := NewIPRules(IPRulesConfig{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"},
+		Deny:  []string{"10.1.0.0/16", "2001:db8:bad::/48"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.0.0.1":           true,
+		"10.1.2.3":           false, // denied inside an allowed network
+		"::ffff:10.1.2.3":    false, // mapped addresses are the IPv4 ones
+		"::ffff:10.2.0.1":    true,
+		"192.0.2.1":          true,
+		"192.0.2.2":          false,
+		"2001:db8::1":        true,
+		"2001:db8:bad::1":    false,
+		"2001:db9::1":        false,
+		"198.51.100.7":       false,
+		"fe80::1":            false,
+		"2001:db8:bad:1::1":  false,
+		"2001:db8:bade::1":   true,
+		"::ffff:192.0.2.1":   true,
+		"::ffff:198.51.10.1": false,
+	} {
+		if got := rules.Allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	// with no allow entries everyone not denied is let in
+	rules, err = NewIPRules(IPRulesConfig{Deny: []string{"198.51.100.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rules.Allowed(netip.MustParseAddr("192.0.2.1")) || rules.Allowed(netip.MustParseAddr("198.51.100.7")) {
+		t.Error("deny only rules don't let in just the addresses not denied")
+	}
+
+	if _, err := NewIPRules(IPRulesConfig{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("NewIPRules accepted an invalid CIDR")
+	}
+	if _, err := NewIPRules(IPRulesConfig{Deny: []string{"not an address"}}); err == nil {
+		t.Error("NewIPRules accepted an invalid address")
+	}
+}
+
+func TestClientFilter(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.TrustedProxies = []string{"10.9.0.0/16"}
+		cfg.ClientAccess = ClientAccessConfig{
+			Proxy: IPRulesConfig{Allow: []string{"192.0.2.0/24", "10.0.0.0/8"}, Deny: []string{"192.0.2.66"}},
+			Admin: IPRulesConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.6.0.0/16"}},
+		}
+	})
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		// wantProxy and wantAdmin tell whether the proxy and an admin route
+		// let the client in
+		wantProxy bool
+		wantAdmin bool
+	}{
+		{name: "allowed on the proxy only", remote: "192.0.2.1:1234", wantProxy: true},
+		{name: "denied within an allowed network", remote: "192.0.2.66:1234"},
+		{name: "not allowed", remote: "198.51.100.7:1234"},
+		{name: "allowed on both", remote: "10.0.0.1:1234", wantProxy: true, wantAdmin: true},
+		{name: "denied on the admin routes only", remote: "10.6.0.1:1234", wantProxy: true},
+		{name: "mapped address", remote: "[::ffff:10.0.0.1]:1234", wantProxy: true, wantAdmin: true},
+		{
+			// the rules apply to the client the trusted proxy forwards for
+			name:      "behind a trusted proxy",
+			remote:    "10.9.0.1:1234",
+			forwarded: "192.0.2.66",
+		},
+		{
+			name:      "forwarded by an untrusted client",
+			remote:    "192.0.2.1:1234",
+			forwarded: "10.0.0.1",
+			wantProxy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for path, want := range map[string]bool{"/essay.html": tt.wantProxy, "/stats": tt.wantAdmin} {
+				target := path
+				if path == "/essay.html" {
+					target = "/?url=" + origin.URL + path
+				}
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				req.RemoteAddr = tt.remote
+				if tt.forwarded != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwarded)
+				}
+				req.Header.Set("Authorization", "Bearer secret")
+				rec := proxy.Serve(req)
+				if got := rec.Code != http.StatusForbidden; got != want {
+					t.Errorf("GET %s = %d, want allowed %v", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package blogproxy
+
+import (
+	"context"
+	log "log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFinishedJobs is how many finished jobs are kept for /admin/jobs.
+const maxFinishedJobs = 100
+
+// Job states.
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// Jobs runs the background work of the proxy, such as link checks and
+// batch prefetches, as named jobs whose progress can be followed and which
+// can be canceled.
+type Jobs struct {
+	clock Clock
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// Job is one run of background work.
+type Job struct {
+	ID   string
+	Name string
+
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	state      string
+	startedAt  time.Time
+	finishedAt time.Time
+	done       int
+	total      int
+	err        error
+}
+
+// JobStatus is the state of a job as reported by /admin/jobs.
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func NewJobs(clock Clock) *Jobs {
+	return &Jobs{clock: clock, jobs: make(map[string]*Job)}
+}
+
+// Run runs fn as a job called name and returns its error. fn is handed the
+// job to report progress on, and a context canceled with the job or ctx.
+func (j *Jobs) Run(ctx context.Context, name string, fn func(ctx context.Context, job *Job) error) error {
+	ctx, job := j.start(ctx, name)
+	return j.finish(job, fn(ctx, job))
+}
+
+// Go is Run in the background.
+func (j *Jobs) Go(ctx context.Context, name string, fn func(ctx context.Context, job *Job) error) *Job {
+	ctx, job := j.start(ctx, name)
+	go func() { j.finish(job, fn(ctx, job)) }()
+	return job
+}
+
+func (j *Jobs) start(ctx context.Context, name string) (context.Context, *Job) {
+	ctx, cancel := context.WithCancel(ctx)
+	job := &Job{ID: newID(), Name: name, cancel: cancel, state: jobRunning, startedAt: j.clock.Now()}
+
+	j.mu.Lock()
+	j.jobs[job.ID] = job
+	j.prune()
+	j.mu.Unlock()
+	log.Info("job started", "job", job.ID, "name", name)
+	return ctx, job
+}
+
+func (j *Jobs) finish(job *Job, err error) error {
+	job.cancel()
+
+	job.mu.Lock()
+	job.finishedAt = j.clock.Now()
+	switch {
+	case job.state == jobCanceled:
+	case err != nil:
+		job.state, job.err = jobFailed, err
+	default:
+		job.state = jobDone
+	}
+	state := job.state
+	job.mu.Unlock()
+
+	log.Info("job finished", "job", job.ID, "name", job.Name, "state", state, "error", err)
+	return err
+}
+
+// prune drops the oldest finished jobs past maxFinishedJobs. j.mu must be
+// held.
+func (j *Jobs) prune() {
+	var finished []*Job
+	for _, job := range j.jobs {
+		if job.Status().State != jobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].startedAt.Before(finished[b].startedAt) })
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(j.jobs, job.ID)
+	}
+}
+
+// Cancel cancels the job with id and reports whether it was running.
+func (j *Jobs) Cancel(id string) bool {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	j.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	job.mu.Lock()
+	running := job.state == jobRunning
+	if running {
+		job.state = jobCanceled
+	}
+	job.mu.Unlock()
+	job.cancel()
+	return running
+}
+
+// Get returns the status of the job with id.
+func (j *Jobs) Get(id string) (JobStatus, bool) {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	j.mu.Unlock()
+	if !ok {
+		return JobStatus{}, false
+	}
+	return job.Status(), true
+}
+
+// List returns the status of every job, the latest first.
+func (j *Jobs) List() []JobStatus {
+	j.mu.Lock()
+	statuses := make([]JobStatus, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		statuses = append(statuses, job.Status())
+	}
+	j.mu.Unlock()
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].StartedAt.After(statuses[b].StartedAt) })
+	return statuses
+}
+
+// SetTotal sets the number of steps of the job, zero when unknown.
+func (job *Job) SetTotal(total int) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.total = total
+}
+
+// Advance records that n more steps are done.
+func (job *Job) Advance(n int) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.done += n
+}
+
+func (job *Job) Status() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := JobStatus{
+		ID:        job.ID,
+		Name:      job.Name,
+		State:     job.state,
+		StartedAt: job.startedAt,
+		Done:      job.done,
+		Total:     job.total,
+	}
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	if job.err != nil {
+		status.Error = job.err.Error()
+	}
+	return status
+}
+
+func (srv *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.jobs.List())
+}
+
+func (srv *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	status, ok := srv.jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (srv *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := srv.jobs.Get(r.PathValue("id")); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !srv.jobs.Cancel(r.PathValue("id")) {
+		http.Error(w, "job is not running", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
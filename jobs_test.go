@@ -0,0 +1,62 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	clock := newFakeClock()
+	jobs := NewJobs(clock)
+
+	err := jobs.Run(context.Background(), "count", func(ctx context.Context, job *Job) error {
+		job.SetTotal(3)
+		job.Advance(2)
+		clock.Advance(time.Minute)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	jobs.Run(context.Background(), "broken", func(ctx context.Context, job *Job) error {
+		clock.Advance(time.Minute)
+		return errors.New("origin unreachable")
+	})
+
+	started := make(chan struct{})
+	long := jobs.Go(context.Background(), "long", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	if status, _ := jobs.Get(long.ID); status.State != jobRunning {
+		t.Errorf("long job is %s, want running", status.State)
+	}
+	if !jobs.Cancel(long.ID) {
+		t.Fatal("Cancel of a running job returned false")
+	}
+	if jobs.Cancel(long.ID) {
+		t.Error("Cancel of a canceled job returned true")
+	}
+
+	list := jobs.List()
+	if len(list) != 3 {
+		t.Fatalf("List returned %d jobs, want 3", len(list))
+	}
+	latest, broken, count := list[0], list[1], list[2]
+	if latest.Name != "long" || latest.State != jobCanceled {
+		t.Errorf("latest job = %s %s, want long canceled", latest.Name, latest.State)
+	}
+	if broken.State != jobFailed || broken.Error != "origin unreachable" {
+		t.Errorf("broken job = %s %q, want failed with its error", broken.State, broken.Error)
+	}
+	if count.State != jobDone || count.Done != 2 || count.Total != 3 {
+		t.Errorf("count job = %s %d/%d, want done 2/3", count.State, count.Done, count.Total)
+	}
+	if count.FinishedAt == nil || count.FinishedAt.Sub(count.StartedAt) != time.Minute {
+		t.Errorf("count job ran from %v to %v, want a minute", count.StartedAt, count.FinishedAt)
+	}
+}
@@ -0,0 +1,140 @@
+package blogproxy
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// primaryLanguage returns the primary subtag of the most preferred language
+// of an Accept-Language header: "fr" for "fr-CH, fr;q=0.9, en;q=0.8".
+// Variants are cached per primary language only, so that regional tags
+// don't split the cache into as many copies as there are readers.
+func primaryLanguage(header string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q <= 0 || !isLanguageSubtag(primary) {
+			continue
+		}
+		choices = append(choices, choice{lang: primary, q: q})
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+func isLanguageSubtag(s string) bool {
+	if len(s) < 2 || len(s) > 8 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// languageMiddleware stores the primary language of the request in its
+// context, for the origins whose content depends on it.
+func languageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lang := primaryLanguage(r.Header.Get("Accept-Language")); lang != "" {
+			r = r.WithContext(withLanguage(r.Context(), lang))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey, lang)
+}
+
+// languageFrom returns the primary language stored by languageMiddleware,
+// empty if the client sent none.
+func languageFrom(ctx context.Context) string {
+	lang, _ := ctx.Value(languageKey).(string)
+	return lang
+}
+
+// variantSeparator joins a page name and the language of its variant in the
+// cache. It can't appear in a page name: parseTargetURL and Storage.Lookup
+// refuse control characters.
+const variantSeparator = "\x00lang="
+
+func variantPage(pageName, lang string) string {
+	return pageName + variantSeparator + lang
+}
+
+// splitVariant undoes variantPage; lang is empty for a page cached without
+// variants.
+func splitVariant(stored string) (pageName, lang string) {
+	pageName, lang, _ = strings.Cut(stored, variantSeparator)
+	return pageName, lang
+}
+
+// Variants remembers the pages whose origin sent Vary: Accept-Language, and
+// so are cached once per language.
+type Variants struct {
+	mu     sync.RWMutex
+	varies map[string]bool
+}
+
+func NewVariants() *Variants {
+	return &Variants{varies: make(map[string]bool)}
+}
+
+// Varies reports whether the content at key depends on the language.
+func (v *Variants) Varies(key string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.varies[key]
+}
+
+// Mark records that the content at key depends on the language, for pages
+// whose variants were cached by another process.
+func (v *Variants) Mark(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.varies[key] = true
+}
+
+// Learn records from the Vary header of a response whether the content at
+// key depends on the language.
+func (v *Variants) Learn(key string, header http.Header) {
+	varies := false
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Language") {
+				varies = true
+			}
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if varies {
+		v.varies[key] = true
+	} else {
+		delete(v.varies, key)
+	}
+}
@@ -0,0 +1,98 @@
+package blogproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var errOverloaded error = &domainError{msg: "too many upstream fetches", kind: ErrUnavailable}
+
+// FetchLimiter bounds the number of upstream fetches in flight, globally and
+// per host. Callers over the limit wait for a free slot up to the queue
+// timeout and are turned away after that, so a slow origin cannot pile up
+// goroutines without bound.
+type FetchLimiter struct {
+	global  chan struct{}
+	perHost int
+	timeout time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// NewFetchLimiter creates a limiter. A limit of zero disables that limit.
+func NewFetchLimiter(maxInflight, maxPerHost int, queueTimeout time.Duration) *FetchLimiter {
+	l := &FetchLimiter{
+		perHost: maxPerHost,
+		timeout: queueTimeout,
+		hosts:   make(map[string]chan struct{}),
+	}
+	if maxInflight > 0 {
+		l.global = make(chan struct{}, maxInflight)
+	}
+	return l
+}
+
+// Acquire takes a global and a per-host slot for hostName. The returned
+// release func must be called once the fetch is done.
+func (l *FetchLimiter) Acquire(ctx context.Context, hostName string) (release func(), err error) {
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	// take the host slot first so requests queued for a slow origin don't
+	// hold global slots other hosts could use
+	host := l.hostSlots(hostName)
+	if err := acquireSlot(ctx, host); err != nil {
+		return nil, err
+	}
+	if err := acquireSlot(ctx, l.global); err != nil {
+		releaseSlot(host)
+		return nil, err
+	}
+
+	return func() {
+		releaseSlot(l.global)
+		releaseSlot(host)
+	}, nil
+}
+
+func (l *FetchLimiter) hostSlots(hostName string) chan struct{} {
+	if l.perHost <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.hosts[hostName]
+	if !ok {
+		slots = make(chan struct{}, l.perHost)
+		l.hosts[hostName] = slots
+	}
+	return slots
+}
+
+func acquireSlot(ctx context.Context, slots chan struct{}) error {
+	if slots == nil {
+		return nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errOverloaded
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
@@ -0,0 +1,250 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLink is an outbound link that failed its check.
+type DeadLink struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type PageLinks struct {
+	URL     string     `json:"url"`
+	Checked int        `json:"checked"`
+	Dead    []DeadLink `json:"dead"`
+}
+
+// LinkReport is the result of a link check run.
+type LinkReport struct {
+	Running    bool        `json:"running"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at,omitempty"`
+	Pages      []PageLinks `json:"pages"`
+}
+
+// LinkChecker periodically checks the links found in cached HTML pages and
+// records the dead ones per page.
+type LinkChecker struct {
+	storage     *Storage
+	jobs        *Jobs
+	interval    time.Duration
+	concurrency int
+	client      *http.Client
+
+	mu      sync.Mutex
+	running bool
+	report  LinkReport
+}
+
+// NewLinkChecker builds the checker of cfg. The links are only checked on
+// public addresses, as they come from pages anyone can have cached.
+func NewLinkChecker(storage *Storage, cfg LinkCheckConfig, jobs *Jobs) (*LinkChecker, error) {
+	if cfg.Interval <= 0 {
+		return nil, errors.New("link_check.interval must be positive")
+	}
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(cfg.Timeout),
+		KeepAlive: 30 * time.Second,
+		Control:   publicAddressesOnly,
+	}
+	return &LinkChecker{
+		storage:     storage,
+		jobs:        jobs,
+		interval:    time.Duration(cfg.Interval),
+		concurrency: max(cfg.Concurrency, 1),
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout),
+			// no proxy from the environment, which would connect on the
+			// checker's behalf to any address
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: time.Duration(cfg.Timeout),
+				IdleConnTimeout:     90 * time.Second,
+				ForceAttemptHTTP2:   true,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}, nil
+}
+
+// Start runs a check every interval until ctx is done.
+func (l *LinkChecker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run checks all cached pages now, as a job. It returns false when a run is
+// already in progress.
+func (l *LinkChecker) Run(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.running {
+		l.mu.Unlock()
+		return false
+	}
+	l.running = true
+	l.mu.Unlock()
+
+	l.jobs.Run(ctx, "linkcheck", func(ctx context.Context, job *Job) error {
+		started := time.Now()
+		pages := l.check(ctx, job)
+
+		l.mu.Lock()
+		l.report = LinkReport{StartedAt: started, FinishedAt: time.Now(), Pages: pages}
+		l.mu.Unlock()
+		log.Info("link check finished", "pages", len(pages), "took", time.Since(started).Round(time.Second))
+		return ctx.Err()
+	})
+
+	l.mu.Lock()
+	l.running = false
+	l.mu.Unlock()
+	return true
+}
+
+func (l *LinkChecker) Report() LinkReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report := l.report
+	report.Running = l.running
+	return report
+}
+
+func (l *LinkChecker) check(ctx context.Context, job *Job) []PageLinks {
+	type pageLinks struct {
+		url   string
+		links []string
+	}
+
+	var pages []pageLinks
+	unique := make(map[string]struct{})
+	for _, entry := range l.storage.ListAll() {
+		if !isHTML(entry.Object.ContentType) {
+			continue
+		}
+		page := cacheKey(entry.HostName, entry.PageName)
+		base, err := url.Parse(page)
+		if err != nil {
+			continue
+		}
+		obj, err := entry.Object.Decoded()
+		if err != nil {
+			continue
+		}
+		var links []string
+		for _, link := range extractLinks(base, obj.Content) {
+			links = append(links, link.String())
+			unique[link.String()] = struct{}{}
+		}
+		pages = append(pages, pageLinks{url: page, links: links})
+	}
+
+	// check every distinct link once, however many pages link to it
+	job.SetTotal(len(unique))
+	results := make(map[string]*DeadLink, len(unique))
+	var mu sync.Mutex
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range l.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range work {
+				dead := l.checkLink(ctx, link)
+				mu.Lock()
+				results[link] = dead
+				mu.Unlock()
+				job.Advance(1)
+			}
+		}()
+	}
+	for link := range unique {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- link
+	}
+	close(work)
+	wg.Wait()
+
+	report := make([]PageLinks, 0, len(pages))
+	for _, page := range pages {
+		result := PageLinks{URL: page.url, Checked: len(page.links), Dead: []DeadLink{}}
+		for _, link := range page.links {
+			if dead := results[link]; dead != nil {
+				result.Dead = append(result.Dead, *dead)
+			}
+		}
+		report = append(report, result)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].URL < report[j].URL })
+	return report
+}
+
+// checkLink returns nil when link is alive. It tries HEAD first and falls
+// back to GET for servers that don't support HEAD.
+func (l *LinkChecker) checkLink(ctx context.Context, link string) *DeadLink {
+	status, err := l.request(ctx, http.MethodHead, link)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = l.request(ctx, http.MethodGet, link)
+	}
+	if err != nil {
+		return &DeadLink{URL: link, Error: err.Error()}
+	}
+	if status >= 400 {
+		return &DeadLink{URL: link, Status: status}
+	}
+	return nil
+}
+
+func (l *LinkChecker) request(ctx context.Context, method, link string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (l *LinkChecker) handleReport(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, l.Report())
+}
+
+// handleRun starts a check in the background.
+func (l *LinkChecker) handleRun(w http.ResponseWriter, r *http.Request) {
+	go l.Run(context.Background())
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,40 @@
+package blogproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkCheckerRefusesInnerAddresses(t *testing.T) {
+	var requests int
+	inner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer inner.Close()
+
+	checker, err := NewLinkChecker(nil, LinkCheckConfig{Interval: Duration(time.Hour), Timeout: Duration(time.Second)}, nil)
+	if err != nil {
+		t.Fatalf("NewLinkChecker: %v", err)
+	}
+	for _, link := range []string{inner.URL + "/admin", "http://[::1]:1/", "http://169.254.169.254/latest/meta-data/"} {
+		dead := checker.checkLink(context.Background(), link)
+		if dead == nil || !strings.Contains(dead.Error, errAddressNotPublic.Error()) {
+			t.Errorf("checkLink(%q) = %+v, want refused as not public", link, dead)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("inner server got %d requests", requests)
+	}
+}
+
+func TestLinkCheckerInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewLinkChecker(nil, LinkCheckConfig{Interval: Duration(interval)}, nil); err == nil {
+			t.Errorf("NewLinkChecker accepted an interval of %v", interval)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head><title>Down for maintenance</title></head>
+<body><p>This proxy is down for maintenance and will be back shortly.</p></body>
+</html>
+`
+
+var errMaintenance error = &domainError{msg: "proxy in maintenance", kind: ErrUnavailable}
+
+// Maintenance serves a static page with 503 for the proxy routes while it is
+// enabled, and Storage fetches nothing from the origins meanwhile.
+type Maintenance struct {
+	enabled    atomic.Bool
+	page       []byte
+	retryAfter time.Duration
+}
+
+func NewMaintenance(cfg MaintenanceConfig) (*Maintenance, error) {
+	m := &Maintenance{
+		page:       []byte(defaultMaintenancePage),
+		retryAfter: time.Duration(cfg.RetryAfter),
+	}
+	if cfg.Page != "" {
+		page, err := os.ReadFile(cfg.Page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read maintenance page: %w", err)
+		}
+		m.page = page
+	}
+	m.enabled.Store(cfg.Enabled)
+	return m, nil
+}
+
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+func (m *Maintenance) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !m.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			if m.retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(m.page)
+		})
+	}
+}
+
+type maintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (m *Maintenance) handleGet(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, maintenanceState{Enabled: m.Enabled()})
+}
+
+func (m *Maintenance) handleSet(w http.ResponseWriter, r *http.Request) {
+	var state maintenanceState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	m.enabled.Store(state.Enabled)
+	log.Info("maintenance mode changed", "enabled", state.Enabled, "client", ClientIP(r.Context()))
+	writeJSON(w, http.StatusOK, state)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,312 @@
+package blogproxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metrics is the registry exposed on /metrics.
+var metrics = NewRegistry()
+
+// Registry holds the metrics of the proxy and writes them in the Prometheus
+// text exposition format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds m, replacing any metric registered under the same name.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.metrics {
+		if existing.name() == m.name() {
+			r.metrics[i] = m
+			return
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{Name: name, Help: help, Labels: labels}}
+	r.register(c)
+	return c
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{Name: name, Help: help, Labels: labels}}
+	r.register(g)
+	return g
+}
+
+// Histogram registers a histogram with the given upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{Name: name, Help: help, Labels: labels}, buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// GaugeFunc registers an unlabeled gauge whose value is read from fn at
+// scrape time.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc: desc{Name: name, Help: help}, fn: fn})
+}
+
+// GaugeVecFunc registers a gauge with the given label names whose series
+// are set by fn at scrape time.
+func (r *Registry) GaugeVecFunc(name, help string, fn func(set func(value float64, labelValues ...string)), labels ...string) {
+	r.register(&gaugeVecFunc{desc: desc{Name: name, Help: help, Labels: labels}, fn: fn})
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	all := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].name() < all[j].name() })
+	for _, m := range all {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+type desc struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+func (d desc) name() string {
+	return d.Name
+}
+
+func (d desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.Name, d.Help, d.Name, kind)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (d desc) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(d.Labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.Labels[i]+"="+strconv.Quote(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// seriesMap is a set of float series keyed by label values.
+type seriesMap struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (s *seriesMap) add(key string, delta float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]float64)
+	}
+	s.values[key] += delta
+}
+
+func (s *seriesMap) set(key string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]float64)
+	}
+	s.values[key] = value
+}
+
+func (s *seriesMap) get(key string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *seriesMap) write(w io.Writer, d desc) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]float64, len(keys))
+	for i, key := range keys {
+		values[i] = s.values[key]
+	}
+	s.mu.Unlock()
+
+	for i, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", d.Name, d.labelString(key), formatFloat(values[i]))
+	}
+}
+
+type CounterVec struct {
+	desc
+	series seriesMap
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.series.add(labelKey(labelValues), delta)
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.series.get(labelKey(labelValues))
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w, "counter")
+	c.series.write(w, c.desc)
+}
+
+type GaugeVec struct {
+	desc
+	series seriesMap
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.series.set(labelKey(labelValues), value)
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.series.add(labelKey(labelValues), delta)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w, "gauge")
+	g.series.write(w, g.desc)
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	// counts[i] is the number of observations in bucket i, not cumulative;
+	// the last one is the +Inf bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.series == nil {
+		h.series = make(map[string]*histogram)
+	}
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = series
+	}
+	series.counts[sort.SearchFloat64s(h.buckets, value)]++
+	series.sum += value
+	series.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snapshot := make([]histogram, len(keys))
+	for i, key := range keys {
+		series := h.series[key]
+		snapshot[i] = histogram{counts: append([]uint64(nil), series.counts...), sum: series.sum, count: series.count}
+	}
+	h.mu.Unlock()
+
+	for i, key := range keys {
+		var cumulative uint64
+		for j, n := range snapshot[i].counts {
+			cumulative += n
+			bound := math.Inf(1)
+			if j < len(h.buckets) {
+				bound = h.buckets[j]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, h.labelString(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.Name, h.labelString(key), formatFloat(snapshot[i].sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.Name, h.labelString(key), snapshot[i].count)
+	}
+}
+
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.Name, formatFloat(g.fn()))
+}
+
+type gaugeVecFunc struct {
+	desc
+	fn func(set func(value float64, labelValues ...string))
+}
+
+func (g *gaugeVecFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	var series seriesMap
+	g.fn(func(value float64, labelValues ...string) {
+		series.set(labelKey(labelValues), value)
+	})
+	series.write(w, g.desc)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
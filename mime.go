@@ -0,0 +1,125 @@
+package blogproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var errUnsupportedMediaType error = &domainError{msg: "unsupported media type", kind: ErrNotAllowed}
+
+// MediaTypes is an allowlist of media types. Entries are either a full type
+// such as "text/html" or a wildcard subtype such as "image/*".
+type MediaTypes []string
+
+func (m MediaTypes) Allowed(mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, allowed := range m {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && prefix == major {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContentType verifies that content declared as contentType is allowed
+// and that the declaration agrees with what the bytes actually look like.
+func checkContentType(allowed MediaTypes, contentType string, content []byte) error {
+	declared := parseMediaType(contentType)
+	if declared == "" {
+		declared = sniffMediaType(content)
+	}
+	if !allowed.Allowed(declared) {
+		return errUnsupportedMediaType
+	}
+
+	sniffed := sniffMediaType(content)
+	if !mediaTypesMatch(declared, sniffed) {
+		return errUnsupportedMediaType
+	}
+	return nil
+}
+
+// mediaTypesMatch reports whether the sniffed type is consistent with the
+// declared one. The sniffer cannot tell CSS or SVG from plain text, so any
+// textual sniff is accepted for a textual declaration; images only need to
+// sniff as some image.
+func mediaTypesMatch(declared, sniffed string) bool {
+	if declared == sniffed {
+		return true
+	}
+	if isTextual(declared) {
+		return sniffed == "text/plain" || sniffed == "text/html" || sniffed == "text/xml"
+	}
+	if strings.HasPrefix(declared, "image/") {
+		return strings.HasPrefix(sniffed, "image/")
+	}
+	return false
+}
+
+// sniffMediaType returns the media type content looks like. On top of
+// http.DetectContentType it knows the image formats the standard sniffer
+// doesn't: AVIF, HEIC and HEIF in an ISO-BMFF ftyp box, and JPEG XL as a
+// bare codestream or in its container.
+func sniffMediaType(content []byte) string {
+	if bytes.HasPrefix(content, []byte{0xff, 0x0a}) ||
+		bytes.HasPrefix(content, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")) {
+		return "image/jxl"
+	}
+	if image := ftypImageType(content); image != "" {
+		return image
+	}
+	return parseMediaType(http.DetectContentType(content))
+}
+
+// ftypImageType returns the image type named by the brands of the ftyp box
+// content starts with, or "" when it has none of an image.
+func ftypImageType(content []byte) string {
+	if len(content) < 16 || string(content[4:8]) != "ftyp" {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(content))
+	if size < 16 || size > len(content) {
+		size = len(content)
+	}
+	// the major brand, then the compatible ones after the minor version
+	brands := []string{string(content[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(content[i:i+4]))
+	}
+
+	image := ""
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			image = "image/heic"
+		case "mif1", "msf1":
+			if image == "" {
+				image = "image/heif"
+			}
+		}
+	}
+	return image
+}
+
+func isTextual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "/xml")
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,71 @@
+package blogproxy
+
+import (
+	"errors"
+	"testing"
+)
+
+// ftyp returns the start of an ISO-BMFF file whose ftyp box has the major
+// brand and the compatible brands.
+func ftyp(major string, compatible ...string) []byte {
+	box := []byte{0, 0, 0, byte(16 + 4*len(compatible))}
+	box = append(box, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	return append(box, "\x00\x00\x00\x08mdat"...)
+}
+
+func TestCheckContentType(t *testing.T) {
+	allowed := MediaTypes{"text/html", "text/css", "image/*"}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name        string
+		contentType string
+		content     []byte
+		wantErr     bool
+	}{
+		{name: "html", contentType: "text/html; charset=utf-8", content: []byte("<html><p>hi</p></html>")},
+		{name: "css sniffed as text", contentType: "text/css", content: []byte("p { color: red }")},
+		{name: "png", contentType: "image/png", content: png},
+		{name: "png declared as jpeg", contentType: "image/jpeg", content: png},
+		{name: "undeclared html", content: []byte("<html><p>hi</p></html>")},
+		{name: "type not allowed", contentType: "application/pdf", content: []byte("%PDF-1.7"), wantErr: true},
+		{name: "html declared as image", contentType: "image/png", content: []byte("<html><p>hi</p></html>"), wantErr: true},
+		{name: "binary declared as html", contentType: "text/html", content: png, wantErr: true},
+		{name: "avif", contentType: "image/avif", content: ftyp("avif", "mif1", "miaf")},
+		{name: "avif under a heif major brand", contentType: "image/avif", content: ftyp("mif1", "avif")},
+		{name: "heic", contentType: "image/heic", content: ftyp("heic", "mif1")},
+		{name: "heif", contentType: "image/heif", content: ftyp("mif1", "heic")},
+		{name: "jxl codestream", contentType: "image/jxl", content: []byte("\xff\x0a\xfa\x7f\x01\x90")},
+		{name: "jxl container", contentType: "image/jxl", content: []byte("\x00\x00\x00\x0cJXL \r\n\x87\n\x00\x00\x00\x14ftypjxl ")},
+		{name: "mp4 declared as avif", contentType: "image/avif", content: ftyp("mp42", "isom"), wantErr: true},
+		{name: "unknown bytes declared as image", contentType: "image/avif", content: []byte("\x00\x01\x02\x03\x04"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkContentType(allowed, tt.contentType, tt.content)
+			if tt.wantErr && !errors.Is(err, errUnsupportedMediaType) {
+				t.Errorf("checkContentType = %v, want %v", err, errUnsupportedMediaType)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkContentType = %v", err)
+			}
+		})
+	}
+}
+
+func TestSniffMediaType(t *testing.T) {
+	for want, content := range map[string][]byte{
+		"image/avif": ftyp("avis"),
+		"image/heic": ftyp("heix"),
+		"image/heif": ftyp("msf1"),
+		"image/jxl":  []byte("\xff\x0a"),
+		"image/png":  []byte("\x89PNG\r\n\x1a\n"),
+		"video/mp4":  ftyp("mp42", "isom"),
+	} {
+		if got := sniffMediaType(content); got != want {
+			t.Errorf("sniffMediaType(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package blogproxy
+
+import (
+	"net/http"
+	"time"
+)
+
+type objectResponse struct {
+	URL  string `json:"url"`
+	Etag string `json:"etag"`
+	// Size is the stored size, compressed when ContentEncoding is set.
+	Size            int          `json:"size"`
+	ContentType     string       `json:"content_type"`
+	ContentEncoding string       `json:"content_encoding,omitempty"`
+	FetchedAt       time.Time    `json:"fetched_at"`
+	LastModified    *time.Time   `json:"last_modified,omitempty"`
+	ExpiresAt       time.Time    `json:"expires_at"`
+	Hits            int64        `json:"hits"`
+	Source          ObjectSource `json:"source"`
+}
+
+// handleObject returns the cache metadata of a page without its content,
+// fetching the page like the proxy route does when it isn't cached.
+func (srv *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	// metadata is for checking freshness, a stored copy defeats the point
+	w.Header().Set("Cache-Control", "no-store")
+
+	ctx := r.Context()
+	obj, source, err := srv.storage.Lookup(ctx, hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var lastModified *time.Time
+	if !obj.LastModified.IsZero() {
+		lastModified = &obj.LastModified
+	}
+	writeJSON(w, http.StatusOK, objectResponse{
+		URL:             cacheKey(hostName, pageName),
+		Etag:            obj.Etag,
+		Size:            len(obj.Content),
+		ContentType:     obj.ContentType,
+		ContentEncoding: obj.ContentEncoding,
+		FetchedAt:       obj.UpdateTime,
+		LastModified:    lastModified,
+		ExpiresAt:       obj.ExpiryTime,
+		Hits:            srv.storage.Hits(ctx, hostName, pageName),
+		Source:          source,
+	})
+}
@@ -0,0 +1,155 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+var oidcFailures = metrics.Counter(
+	"blogproxy_oidc_failures_total",
+	"Requests refused by the OIDC authentication.",
+	"reason",
+)
+
+// OIDCAuth authenticates requests by the JWT bearer tokens of an OIDC
+// issuer. The signing keys are fetched from the issuer's JWKS endpoint and
+// cached, and refreshed when a token is signed by an unknown key.
+type OIDCAuth struct {
+	cfg      OIDCConfig
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCAuth returns nil when no issuer is configured. Unless the JWKS URL
+// is configured, the issuer's discovery document is fetched to find it.
+// Token expiry is checked against clock.
+func NewOIDCAuth(ctx context.Context, cfg OIDCConfig, clock Clock) (*OIDCAuth, error) {
+	if cfg.Issuer == "" {
+		return nil, nil
+	}
+
+	verifierConfig := &oidc.Config{
+		ClientID:          cfg.Audience,
+		SkipClientIDCheck: cfg.Audience == "",
+		Now:               clock.Now,
+	}
+	var verifier *oidc.IDTokenVerifier
+	if cfg.JWKSURL != "" {
+		keySet := oidc.NewRemoteKeySet(context.Background(), cfg.JWKSURL)
+		verifier = oidc.NewVerifier(cfg.Issuer, keySet, verifierConfig)
+	} else {
+		provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover oidc issuer: %w", err)
+		}
+		verifier = provider.Verifier(verifierConfig)
+	}
+	return &OIDCAuth{cfg: cfg, verifier: verifier}, nil
+}
+
+// Claims verifies the bearer token of r and returns its claims.
+func (a *OIDCAuth) Claims(r *http.Request) (map[string]any, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errors.New("no bearer token")
+	}
+	token, err := a.verifier.Verify(r.Context(), raw)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Middleware requires a valid token satisfying the proxy rules on every
+// route but the ones exempt from authentication.
+func (a *OIDCAuth) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := a.Claims(r)
+			if err != nil {
+				oidcFailures.Inc("invalid_token")
+				log.Warn("oidc authentication failed", "client", ClientIP(r.Context()), "path", r.URL.Path, "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !a.cfg.Proxy.Match(claims, a.cfg.groupsClaim()) {
+				oidcFailures.Inc("claims")
+				log.Warn("oidc claims rejected", "client", ClientIP(r.Context()), "subject", claims["sub"], "path", r.URL.Path)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin reports whether r carries a valid token satisfying the admin
+// rules.
+func (a *OIDCAuth) IsAdmin(r *http.Request) bool {
+	if a == nil {
+		return false
+	}
+	claims, err := a.Claims(r)
+	if err != nil {
+		return false
+	}
+	return a.cfg.Admin.Match(claims, a.cfg.groupsClaim())
+}
+
+func (cfg OIDCConfig) groupsClaim() string {
+	if cfg.GroupsClaim == "" {
+		return "groups"
+	}
+	return cfg.GroupsClaim
+}
+
+// Match reports whether claims satisfy the rules: one of the groups, when
+// any are listed, and an accepted value for every listed claim.
+func (rules OIDCRules) Match(claims map[string]any, groupsClaim string) bool {
+	if len(rules.Groups) > 0 && !claimHasAny(claims[groupsClaim], rules.Groups) {
+		return false
+	}
+	for name, accepted := range rules.Claims {
+		if !claimHasAny(claims[name], accepted) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimHasAny reports whether the claim value, a string or a list of
+// strings, holds one of accepted.
+func claimHasAny(value any, accepted []string) bool {
+	switch value := value.(type) {
+	case string:
+		return slices.Contains(accepted, value)
+	case []any:
+		for _, v := range value {
+			if s, ok := v.(string); ok && slices.Contains(accepted, s) {
+				return true
+			}
+		}
+	case bool:
+		return slices.Contains(accepted, fmt.Sprint(value))
+	}
+	return false
+}
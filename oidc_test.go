@@ -0,0 +1,176 @@
+package blogproxy
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://issuer.example"
+
+// testIssuerKeys signs tokens with a key served as a local JWKS.
+type testIssuerKeys struct {
+	key     *rsa.PrivateKey
+	jwksURL string
+}
+
+func newTestIssuerKeys(t *testing.T) *testIssuerKeys {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return &testIssuerKeys{key: key, jwksURL: server.URL}
+}
+
+// token returns an RS256 JWT of claims, issued by testIssuer unless claims
+// say otherwise.
+func (k *testIssuerKeys) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	payload := map[string]any{"iss": testIssuer, "sub": "reader"}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCAuth(t *testing.T) {
+	keys := newTestIssuerKeys(t)
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.OIDC = OIDCConfig{
+			Issuer:   testIssuer,
+			JWKSURL:  keys.jwksURL,
+			Audience: "blog-proxy",
+			Proxy:    OIDCRules{Claims: map[string][]string{"email_verified": {"true"}}},
+			Admin:    OIDCRules{Groups: []string{"ops"}},
+		}
+	})
+
+	now := proxy.clock.Now()
+	valid := map[string]any{
+		"aud":            "blog-proxy",
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"email_verified": true,
+	}
+	with := func(changes map[string]any) string {
+		claims := make(map[string]any)
+		for name, value := range valid {
+			claims[name] = value
+		}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return keys.token(t, claims)
+	}
+
+	proxyPath := "/?url=" + url.QueryEscape(origin.URL+"/essay.html")
+	for _, tc := range []struct {
+		name  string
+		token string
+		// wantProxy and wantAdmin are the statuses of a proxy and an
+		// admin route
+		wantProxy int
+		wantAdmin int
+	}{
+		{
+			name:      "no token",
+			wantProxy: http.StatusUnauthorized,
+			wantAdmin: http.StatusForbidden,
+		},
+		{
+			name:      "valid token",
+			token:     with(nil),
+			wantProxy: http.StatusOK,
+			wantAdmin: http.StatusForbidden,
+		},
+		{
+			name:      "admin token",
+			token:     with(map[string]any{"groups": []string{"readers", "ops"}}),
+			wantProxy: http.StatusOK,
+			wantAdmin: http.StatusOK,
+		},
+		{
+			name:      "wrong audience",
+			token:     with(map[string]any{"aud": "other-app", "groups": []string{"ops"}}),
+			wantProxy: http.StatusUnauthorized,
+			wantAdmin: http.StatusForbidden,
+		},
+		{
+			name:      "other issuer",
+			token:     with(map[string]any{"iss": "https://other.example", "groups": []string{"ops"}}),
+			wantProxy: http.StatusUnauthorized,
+			wantAdmin: http.StatusForbidden,
+		},
+		{
+			name:      "expired token",
+			token:     with(map[string]any{"exp": now.Add(-time.Minute).Unix(), "groups": []string{"ops"}}),
+			wantProxy: http.StatusUnauthorized,
+			wantAdmin: http.StatusForbidden,
+		},
+		{
+			name:      "claim mismatch",
+			token:     with(map[string]any{"email_verified": false, "groups": []string{"ops"}}),
+			wantProxy: http.StatusForbidden,
+			wantAdmin: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.token != "" {
+				header.Set("Authorization", "Bearer "+tc.token)
+			}
+			for path, want := range map[string]int{proxyPath: tc.wantProxy, "/stats": tc.wantAdmin} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header = header.Clone()
+				if rec := proxy.Serve(req); rec.Code != want {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+
+	// tokens expire by the clock of the proxy
+	header := http.Header{"Authorization": {"Bearer " + with(nil)}}
+	proxy.clock.Advance(2 * time.Hour)
+	if rec := proxy.Get("/essay.html", header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a token expired since = %d, want 401", rec.Code)
+	}
+}
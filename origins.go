@@ -0,0 +1,207 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen error = &domainError{msg: "origin failing, circuit breaker open", kind: ErrUnavailable}
+
+// Circuit breaker states.
+const (
+	breakerOff      = "off"
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// OriginHealth tracks the fetches from every origin and trips a circuit
+// breaker for an origin failing repeatedly: its fetches then fail at once
+// until the cooldown is over, when a single fetch probes it again.
+type OriginHealth struct {
+	clock Clock
+	// failures is the number of consecutive failures opening the
+	// breaker, zero to keep it closed
+	failures int
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	origins map[string]*originHealth
+}
+
+type originHealth struct {
+	lastSuccess time.Time
+	failures    int
+	fetches     int64
+	latency     time.Duration
+	// openedAt is when the breaker opened, zero while it is closed
+	openedAt time.Time
+	// probing is set while the fetch probing a half-open breaker runs
+	probing bool
+}
+
+// OriginStatus is the health of one origin.
+type OriginStatus struct {
+	HostName            string     `json:"host"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Breaker             string     `json:"breaker"`
+	// AverageLatency is over the successful fetches since the start.
+	AverageLatency Duration `json:"average_latency"`
+	BytesToday     int64    `json:"bytes_today"`
+}
+
+func NewOriginHealth(cfg FetchConfig, clock Clock) *OriginHealth {
+	return &OriginHealth{
+		clock:    clock,
+		failures: cfg.BreakerFailures,
+		cooldown: time.Duration(cfg.BreakerCooldown),
+		origins:  make(map[string]*originHealth),
+	}
+}
+
+func (h *OriginHealth) origin(hostName string) *originHealth {
+	o, ok := h.origins[hostName]
+	if !ok {
+		o = &originHealth{}
+		h.origins[hostName] = o
+	}
+	return o
+}
+
+// Allow reports whether hostName may be fetched from, which it may unless
+// its breaker is open.
+func (h *OriginHealth) Allow(hostName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o := h.origin(hostName)
+	switch h.state(o) {
+	case breakerOpen:
+		return errCircuitOpen
+	case breakerHalfOpen:
+		if o.probing {
+			return errCircuitOpen
+		}
+		o.probing = true
+	}
+	return nil
+}
+
+// Record accounts a fetch from hostName that took took and ended with err.
+// Only the failures of the origin itself count against it: a 404 or a
+// refused content type shows it is up, and a fetch abandoned by its caller
+// says nothing either way.
+func (h *OriginHealth) Record(hostName string, took time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o := h.origin(hostName)
+	o.probing = false
+	if callerGaveUp(err) {
+		return
+	}
+	if originFailed(err) {
+		o.failures++
+		if h.failures > 0 && o.failures >= h.failures {
+			// a failed probe starts a new cooldown
+			o.openedAt = h.clock.Now()
+		}
+		return
+	}
+	o.failures = 0
+	o.openedAt = time.Time{}
+	if err == nil {
+		o.lastSuccess = h.clock.Now()
+		o.fetches++
+		o.latency += took
+	}
+}
+
+// originFailed reports whether err, from a fetch, is the origin's fault.
+func originFailed(err error) bool {
+	if errors.Is(err, errOriginTimeout) {
+		return true
+	}
+	if callerGaveUp(err) {
+		return false
+	}
+	var upstream *UpstreamError
+	return errors.As(err, &upstream) && (upstream.Status == 0 || upstream.Status >= 500)
+}
+
+// callerGaveUp reports whether err, from a fetch, is its caller going away
+// or running out of time rather than one of the fetch deadlines.
+func callerGaveUp(err error) bool {
+	if errors.Is(err, errOriginTimeout) {
+		return false
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (h *OriginHealth) state(o *originHealth) string {
+	switch {
+	case h.failures <= 0:
+		return breakerOff
+	case o.openedAt.IsZero():
+		return breakerClosed
+	case h.clock.Now().Sub(o.openedAt) < h.cooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// Status returns the health of the origins in origins and of every origin
+// fetched from, with the bytes fetched today from usage.
+func (h *OriginHealth) Status(origins []string, usage []HostBandwidth) []OriginStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bytes := make(map[string]int64, len(usage))
+	for _, u := range usage {
+		bytes[u.HostName] = u.Bytes
+	}
+	seen := make(map[string]bool)
+	var statuses []OriginStatus
+	add := func(hostName string) {
+		if seen[hostName] {
+			return
+		}
+		seen[hostName] = true
+		status := OriginStatus{HostName: hostName, Breaker: breakerOff, BytesToday: bytes[hostName]}
+		if h.failures > 0 {
+			status.Breaker = breakerClosed
+		}
+		if o, ok := h.origins[hostName]; ok {
+			status.ConsecutiveFailures = o.failures
+			status.Breaker = h.state(o)
+			if !o.lastSuccess.IsZero() {
+				lastSuccess := o.lastSuccess
+				status.LastSuccess = &lastSuccess
+			}
+			if o.fetches > 0 {
+				status.AverageLatency = Duration(o.latency / time.Duration(o.fetches))
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	for _, hostName := range origins {
+		add(hostName)
+	}
+	for hostName := range h.origins {
+		add(hostName)
+	}
+	for hostName := range bytes {
+		add(hostName)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].HostName < statuses[j].HostName })
+	return statuses
+}
+
+func (srv *Server) handleOrigins(w http.ResponseWriter, r *http.Request) {
+	s := srv.storage
+	writeJSON(w, http.StatusOK, s.health.Status(s.tenants.Origins(), s.bandwidth.Usage()))
+}
@@ -0,0 +1,115 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// partialsMaxBytes bounds the bytes kept from aborted fetches. When full,
+// new partial objects are dropped.
+const partialsMaxBytes = 64 << 20
+
+var partialFetches = metrics.Counter(
+	"blogproxy_partial_fetches_total",
+	"Aborted fetches kept and their completions, by result: kept, resumed or restarted.",
+	"result",
+)
+
+// partialObject is the beginning of a body whose fetch was cut short, with
+// what is needed to ask the origin for the rest only.
+type partialObject struct {
+	content         []byte
+	contentType     string
+	contentEncoding string
+	// validator is the ETag, or else the Last-Modified date, sent as
+	// If-Range so the rest is only sent if the object didn't change
+	validator string
+	// total is the full length, -1 when unknown
+	total int64
+}
+
+// Partials keeps the bytes of aborted fetches, so the next fetch of the
+// same object only asks the origin for the missing range.
+type Partials struct {
+	mu      sync.Mutex
+	size    int64
+	objects map[string]*partialObject
+}
+
+func NewPartials() *Partials {
+	return &Partials{objects: make(map[string]*partialObject)}
+}
+
+// Keep stores content, the first bytes of the body of resp, if the origin
+// can resume it: it must accept byte ranges and give a validator.
+func (p *Partials) Keep(key string, resp *http.Response, content []byte) {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if len(content) == 0 || validator == "" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remove(key)
+	if p.size+int64(len(content)) > partialsMaxBytes {
+		return
+	}
+	p.objects[key] = &partialObject{
+		content:         content,
+		contentType:     resp.Header.Get("Content-Type"),
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+		validator:       validator,
+		total:           resp.ContentLength,
+	}
+	p.size += int64(len(content))
+	partialFetches.Inc("kept")
+}
+
+// Take removes and returns the partial object of key.
+func (p *Partials) Take(key string) (*partialObject, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	partial, ok := p.objects[key]
+	if ok {
+		p.remove(key)
+	}
+	return partial, ok
+}
+
+func (p *Partials) remove(key string) {
+	if partial, ok := p.objects[key]; ok {
+		p.size -= int64(len(partial.content))
+		delete(p.objects, key)
+	}
+}
+
+// resumeRequest asks req for the bytes following partial.
+func (partial *partialObject) resumeRequest(req *http.Request) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(partial.content)))
+	req.Header.Set("If-Range", partial.validator)
+}
+
+// resumes reports whether resp carries the rest of partial. A 200 means the
+// object changed and came whole instead.
+func (partial *partialObject) resumes(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	// Content-Range: bytes 1000-1999/2000
+	spec, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return false
+	}
+	start, err := strconv.Atoi(first)
+	return err == nil && start == len(partial.content)
+}
@@ -0,0 +1,263 @@
+package blogproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Layout of the generated PDFs, in points on an A4 page.
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 56
+	pdfTitleSize    = 18
+	pdfBodySize     = 11
+	pdfFooterSize   = 8
+	pdfLineSpacing  = 1.45
+	pdfParagraphGap = 6
+)
+
+// helveticaWidths are the glyph widths of Helvetica for the printable ASCII
+// range, in thousandths of the font size.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// winAnsi maps the non-ASCII characters common in essays to their
+// WinAnsiEncoding codes.
+var winAnsi = map[rune]byte{
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'…': 0x85, '€': 0x80, '™': 0x99, '\u00a0': ' ',
+}
+
+// encodeWinAnsi converts s to the single byte encoding of the standard PDF
+// fonts. Characters it cannot represent become '?'.
+func encodeWinAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r < 0x7f, r >= 0xa1 && r <= 0xff:
+			out = append(out, byte(r))
+		case winAnsi[r] != 0:
+			out = append(out, winAnsi[r])
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+func textWidth(s []byte, size float64) float64 {
+	var width int
+	for _, c := range s {
+		if c >= 0x20 && c < 0x7f {
+			width += helveticaWidths[c-0x20]
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// wrapText breaks text into lines no wider than width.
+func wrapText(text string, size, width float64) [][]byte {
+	var lines [][]byte
+	var line []byte
+	for _, word := range strings.Fields(text) {
+		encoded := encodeWinAnsi(word)
+		candidate := encoded
+		if len(line) > 0 {
+			candidate = append(append(append([]byte(nil), line...), ' '), encoded...)
+		}
+		if len(line) > 0 && textWidth(candidate, size) > width {
+			lines = append(lines, line)
+			line = encoded
+			continue
+		}
+		line = candidate
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+type pdfLine struct {
+	text []byte
+	font string
+	size float64
+	// gap is extra space above the line
+	gap float64
+}
+
+// renderPDF lays out a text document over A4 pages with the source URL and
+// fetch date in the footer of every page.
+func renderPDF(title, url string, fetched time.Time, paragraphs []string) []byte {
+	width := float64(pdfPageWidth - 2*pdfMargin)
+
+	var lines []pdfLine
+	for _, line := range wrapText(title, pdfTitleSize, width) {
+		lines = append(lines, pdfLine{text: line, font: "F2", size: pdfTitleSize})
+	}
+	for i, paragraph := range paragraphs {
+		for j, line := range wrapText(paragraph, pdfBodySize, width) {
+			l := pdfLine{text: line, font: "F1", size: pdfBodySize}
+			if j == 0 {
+				l.gap = pdfParagraphGap
+				if i == 0 {
+					l.gap = pdfTitleSize
+				}
+			}
+			lines = append(lines, l)
+		}
+	}
+
+	// split the lines into pages
+	var pages [][]pdfLine
+	var page []pdfLine
+	y := float64(pdfPageHeight - pdfMargin)
+	for _, line := range lines {
+		height := line.size*pdfLineSpacing + line.gap
+		if y-height < pdfMargin && len(page) > 0 {
+			pages = append(pages, page)
+			page = nil
+			y = float64(pdfPageHeight - pdfMargin)
+			line.gap = 0
+			height = line.size * pdfLineSpacing
+		}
+		y -= height
+		page = append(page, line)
+	}
+	if len(page) > 0 || len(pages) == 0 {
+		pages = append(pages, page)
+	}
+
+	footer := fmt.Sprintf("Original: %s - fetched %s", url, fetched.UTC().Format("2 Jan 2006 15:04 MST"))
+
+	w := &pdfWriter{}
+	w.header()
+	catalog := w.reserve()
+	pagesObj := w.reserve()
+	regular := w.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	bold := w.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	var kids []string
+	for n, page := range pages {
+		var content bytes.Buffer
+		y := float64(pdfPageHeight - pdfMargin)
+		for _, line := range page {
+			y -= line.size*pdfLineSpacing + line.gap
+			fmt.Fprintf(&content, "BT /%s %g Tf %d %.2f Td (%s) Tj ET\n", line.font, line.size, pdfMargin, y, pdfEscape(line.text))
+		}
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", pdfFooterSize, pdfMargin, pdfMargin/2, pdfEscape(encodeWinAnsi(footer)))
+		number := encodeWinAnsi(fmt.Sprintf("%d / %d", n+1, len(pages)))
+		fmt.Fprintf(&content, "BT /F1 %d Tf %.2f %d Td (%s) Tj ET\n", pdfFooterSize,
+			float64(pdfPageWidth-pdfMargin)-textWidth(number, pdfFooterSize), pdfMargin/2, pdfEscape(number))
+
+		stream := w.object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+		pageObj := w.object(fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, regular, bold, stream))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+	}
+
+	w.define(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.define(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	info := w.object(fmt.Sprintf("<< /Title (%s) /Producer (blog-proxy) >>", pdfEscape(encodeWinAnsi(title))))
+	return w.finish(catalog, info)
+}
+
+func pdfEscape(s []byte) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// pdfWriter writes the objects of a PDF file and its cross-reference table.
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+	bodies  map[int]string
+}
+
+func (w *pdfWriter) header() {
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	w.bodies = make(map[int]string)
+}
+
+// reserve allocates an object number whose body is given later with define.
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, -1)
+	return len(w.offsets)
+}
+
+func (w *pdfWriter) define(id int, body string) {
+	w.bodies[id] = body
+}
+
+func (w *pdfWriter) object(body string) int {
+	id := w.reserve()
+	w.write(id, body)
+	return id
+}
+
+func (w *pdfWriter) write(id int, body string) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *pdfWriter) finish(root, info int) []byte {
+	for id := 1; id <= len(w.offsets); id++ {
+		if body, ok := w.bodies[id]; ok && w.offsets[id-1] < 0 {
+			w.write(id, body)
+		}
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, root, info, xref)
+	return w.buf.Bytes()
+}
+
+// handlePDF serves the reader-mode version of a page as a PDF.
+func (srv *Server) handlePDF(w http.ResponseWriter, r *http.Request) {
+	srv.serveRendered(w, r, "pdf", func(ctx context.Context, url string, obj Object) (Object, error) {
+		page := newReaderPage(url, obj, nil)
+		if len(page.Paragraphs) == 0 {
+			return Object{}, errors.New("page has no readable text")
+		}
+
+		paragraphs := make([]string, 0, len(page.Paragraphs))
+		for _, paragraph := range page.Paragraphs {
+			var text strings.Builder
+			for _, segment := range paragraph {
+				text.WriteString(segment.Text)
+			}
+			paragraphs = append(paragraphs, text.String())
+		}
+		return Object{
+			ContentType: "application/pdf",
+			Content:     renderPDF(page.Title, url, obj.UpdateTime, paragraphs),
+		}, nil
+	})
+}
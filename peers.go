@@ -0,0 +1,175 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// peerReplicas is the number of points each peer gets on the hash ring.
+const peerReplicas = 64
+
+const peerSecretHeader = "X-Peer-Secret"
+
+var peerFetches = metrics.Counter(
+	"blogproxy_peer_fetches_total",
+	"Objects requested from the peer owning them, by result.",
+	"result",
+)
+
+// PeerPool spreads cache ownership over the replicas of a deployment. Every
+// key has an owner picked by consistent hashing; a replica that misses on a
+// key it doesn't own asks the owner before going to the origin, so each
+// page is fetched from the origin by one replica only.
+type PeerPool struct {
+	self   string
+	secret string
+	client *http.Client
+	// version is the cache.version; replicas of different versions, as
+	// while one is rolled out, don't serve each other
+	version string
+
+	ring   []uint32
+	owners map[uint32]string
+}
+
+// NewPeerPool builds the ring. It returns nil when no peers are configured.
+// The peer routes are open to anyone knowing the secret, so peers need one.
+// Self must be one of the peers, or the replica would ask itself for the
+// keys it owns; trailing slashes don't matter.
+func NewPeerPool(cfg PeersConfig, version string) (*PeerPool, error) {
+	if len(cfg.Peers) == 0 {
+		return nil, nil
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("peers.secret is required with peers")
+	}
+	self := strings.TrimRight(cfg.Self, "/")
+	peers := make([]string, len(cfg.Peers))
+	for i, peer := range cfg.Peers {
+		peers[i] = strings.TrimRight(peer, "/")
+	}
+	if !slices.Contains(peers, self) {
+		return nil, fmt.Errorf("peers.self %q is not one of peers.peers", cfg.Self)
+	}
+
+	p := &PeerPool{
+		self:    self,
+		secret:  cfg.Secret,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		version: version,
+		owners:  make(map[uint32]string),
+	}
+	for _, peer := range peers {
+		for i := range peerReplicas {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			p.ring = append(p.ring, point)
+			p.owners[point] = peer
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i] < p.ring[j] })
+	return p, nil
+}
+
+// Owner returns the base URL of the peer owning key.
+func (p *PeerPool) Owner(key string) string {
+	point := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i] >= point })
+	if i == len(p.ring) {
+		i = 0
+	}
+	return p.owners[p.ring[i]]
+}
+
+// RemoteOwner returns the owner of key unless that is this replica.
+func (p *PeerPool) RemoteOwner(key string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	owner := p.Owner(key)
+	return owner, owner != p.self
+}
+
+// Fetch asks peer for pageName on hostName.
+func (p *PeerPool) Fetch(ctx context.Context, peer, hostName, pageName string) (Object, error) {
+	query := url.Values{"host": {hostName}, "page": {pageName}}
+	if tenant := tenantFrom(ctx); tenant != nil && tenant.Name != "" {
+		query.Set("tenant", tenant.Name)
+	}
+	if lang := languageFrom(ctx); lang != "" {
+		query.Set("lang", lang)
+	}
+	if p.version != "" {
+		query.Set("version", p.version)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/_peer/object?"+query.Encode(), nil)
+	if err != nil {
+		return Object{}, err
+	}
+	req.Header.Set(peerSecretHeader, p.secret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Object{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	var obj Object
+	if err := gob.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return Object{}, fmt.Errorf("failed to decode peer object: %w", err)
+	}
+	return obj, nil
+}
+
+// handleObject serves the peer protocol. Objects are looked up in the local
+// cache and fetched from the origin on a miss, but never forwarded to
+// another peer, nor for a replica of another cache version.
+func (p *PeerPool) handleObject(storage *Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(peerSecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(p.secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		query := r.URL.Query()
+		tenant, ok := storage.tenants.ByName(query.Get("tenant"))
+		if !ok || query.Get("version") != p.version {
+			http.NotFound(w, r)
+			return
+		}
+		ctx := context.WithValue(withTenant(r.Context(), tenant), peerRequestKey, true)
+		if lang := query.Get("lang"); lang != "" {
+			ctx = withLanguage(ctx, lang)
+		}
+		obj, _, err := storage.Lookup(ctx, query.Get("host"), query.Get("page"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := gob.NewEncoder(w).Encode(obj); err != nil {
+			log.Error("failed to write peer object", "error", err)
+		}
+	}
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	v, _ := ctx.Value(peerRequestKey).(bool)
+	return v
+}
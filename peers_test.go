@@ -0,0 +1,73 @@
+package blogproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewPeerPool(t *testing.T) {
+	if p, err := NewPeerPool(PeersConfig{}, ""); p != nil || err != nil {
+		t.Errorf("NewPeerPool without peers = %v, %v, want nil, nil", p, err)
+	}
+	if _, err := NewPeerPool(PeersConfig{Self: "http://a", Peers: []string{"http://a", "http://b"}}, ""); err == nil {
+		t.Error("NewPeerPool accepted peers without a secret")
+	}
+	if _, err := NewPeerPool(PeersConfig{Self: "http://c", Peers: []string{"http://a", "http://b"}, Secret: "s"}, ""); err == nil {
+		t.Error("NewPeerPool accepted a self that isn't one of the peers")
+	}
+	p, err := NewPeerPool(PeersConfig{Self: "http://a/", Peers: []string{"http://a", "http://b/"}, Secret: "s"}, "")
+	if err != nil {
+		t.Fatalf("NewPeerPool with trailing slashes: %v", err)
+	}
+	for _, key := range []string{"x", "y", "z"} {
+		if owner, remote := p.RemoteOwner(key); remote == (owner == "http://a") {
+			t.Errorf("RemoteOwner(%q) = %q, %v", key, owner, remote)
+		}
+	}
+}
+
+func TestPeerObjectAuth(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	storage, err := NewStorage(context.Background(), WithAllowedHosts(origin.URL))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	cfg := PeersConfig{Self: "http://a", Peers: []string{"http://a", "http://b"}, Secret: "peer-secret"}
+	peers, err := NewPeerPool(cfg, "")
+	if err != nil {
+		t.Fatalf("NewPeerPool: %v", err)
+	}
+	handler := peers.handleObject(storage)
+
+	tests := []struct {
+		name   string
+		secret string
+		query  url.Values
+		want   int
+	}{
+		{"secret", "peer-secret", nil, http.StatusOK},
+		{"no secret", "", nil, http.StatusForbidden},
+		{"wrong secret", "guess", nil, http.StatusForbidden},
+		{"secret prefix", "peer", nil, http.StatusForbidden},
+		{"unknown tenant", "peer-secret", url.Values{"tenant": {"other"}}, http.StatusNotFound},
+		{"other cache version", "peer-secret", url.Values{"version": {"v2"}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		query := url.Values{"host": {origin.URL}, "page": {"essay.html"}}
+		for key, values := range tt.query {
+			query[key] = values
+		}
+		req := httptest.NewRequest(http.MethodGet, "/_peer/object?"+query.Encode(), nil)
+		if tt.secret != "" {
+			req.Header.Set(peerSecretHeader, tt.secret)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
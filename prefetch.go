@@ -0,0 +1,140 @@
+package blogproxy
+
+import (
+	"context"
+	log "log/slog"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// prefetchQueueSize bounds the pages waiting to be scanned or prefetched.
+// Pages found while the queue is full are dropped.
+const prefetchQueueSize = 256
+
+// prefetchTask is a page to scan for links, when obj is set, or else to
+// prefetch and then scan.
+type prefetchTask struct {
+	// ctx carries the tenant and language of the reader the page was
+	// served to
+	ctx      context.Context
+	hostName string
+	pageName string
+	obj      *Object
+	depth    int
+}
+
+// Prefetcher warms the cache with the same-host articles linked from served
+// HTML pages, so following a link usually hits the cache. Pages are scanned
+// and their links fetched in the background, without counting as requests
+// for the admission policy, the hit counters or the cache events.
+type Prefetcher struct {
+	storage  *Storage
+	maxDepth int
+	queue    chan prefetchTask
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+}
+
+func NewPrefetcher(storage *Storage, cfg PrefetchConfig) *Prefetcher {
+	p := &Prefetcher{
+		storage:  storage,
+		maxDepth: cfg.MaxDepth,
+		queue:    make(chan prefetchTask, prefetchQueueSize),
+		pending:  make(map[string]struct{}),
+	}
+	for range max(cfg.Concurrency, 1) {
+		go p.worker()
+	}
+	return p
+}
+
+// Schedule queues obj, just fetched as pageName of hostName for the request
+// of ctx, to have its same-host links prefetched. depth is the number of
+// links followed to reach obj from a page a reader asked for.
+func (p *Prefetcher) Schedule(ctx context.Context, hostName, pageName string, obj Object, depth int) {
+	if depth >= p.maxDepth || !isHTML(obj.ContentType) {
+		return
+	}
+	// the prefetches outlive the request
+	ctx = context.WithoutCancel(ctx)
+	p.enqueue(prefetchTask{ctx: ctx, hostName: hostName, pageName: pageName, obj: &obj, depth: depth})
+}
+
+// key identifies the task among the pending ones: the scan of a page or
+// its prefetch, for a tenant and language.
+func (p *Prefetcher) key(task prefetchTask) string {
+	kind := "prefetch"
+	if task.obj != nil {
+		kind = "scan"
+	}
+	namespace := p.storage.tenant(task.ctx).namespace(task.hostName)
+	return kind + ":" + variantPage(cacheKey(namespace, task.pageName), languageFrom(task.ctx))
+}
+
+func (p *Prefetcher) enqueue(task prefetchTask) {
+	key := p.key(task)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[key]; ok {
+		return
+	}
+
+	select {
+	case p.queue <- task:
+		p.pending[key] = struct{}{}
+	default:
+		log.Debug("prefetch queue full", "host", task.hostName, "page", task.pageName)
+	}
+}
+
+func (p *Prefetcher) worker() {
+	for task := range p.queue {
+		p.mu.Lock()
+		delete(p.pending, p.key(task))
+		p.mu.Unlock()
+
+		if task.obj == nil {
+			obj, fetched, err := p.storage.Prefetch(task.ctx, task.hostName, task.pageName)
+			if err != nil {
+				log.Debug("prefetch failed", "host", task.hostName, "page", task.pageName, "error", err)
+				continue
+			}
+			if !fetched {
+				continue
+			}
+			log.Debug("prefetched", "host", task.hostName, "page", task.pageName, "depth", task.depth)
+			if task.depth >= p.maxDepth || !isHTML(obj.ContentType) {
+				continue
+			}
+			task.obj = &obj
+		}
+		p.scan(task)
+	}
+}
+
+// scan queues the same-host links of task.obj not cached yet for
+// prefetching.
+func (p *Prefetcher) scan(task prefetchTask) {
+	base, err := url.Parse(task.hostName + "/" + task.pageName)
+	if err != nil {
+		return
+	}
+	obj, err := task.obj.Decoded()
+	if err != nil {
+		return
+	}
+
+	for _, link := range extractLinks(base, obj.Content) {
+		if link.Scheme+"://"+link.Host != task.hostName || link.RawQuery != "" {
+			continue
+		}
+		linkPage := strings.TrimPrefix(link.Path, "/")
+		if linkPage == "" || linkPage == task.pageName || p.storage.Contains(task.ctx, task.hostName, linkPage) {
+			continue
+		}
+		p.enqueue(prefetchTask{ctx: task.ctx, hostName: task.hostName, pageName: linkPage, depth: task.depth + 1})
+	}
+}
@@ -0,0 +1,100 @@
+package blogproxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitForRequests waits until the origin got n requests for path.
+func waitForRequests(t *testing.T, origin *fakeOrigin, path string, n int) []*http.Request {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		requests := origin.Requests(path)
+		if len(requests) >= n {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("origin got %d requests for %s, want %d", len(requests), path, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	varies := http.Header{"Vary": {"Accept-Language"}}
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/index.html": {Body: `<a href="/a.html">A</a> <a href="/b.html">B</a>`, Header: varies},
+		"/a.html":     {Body: essay, Header: varies},
+		"/b.html":     {Body: `<a href="/c.html">C</a>`},
+		"/c.html":     {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Prefetch = PrefetchConfig{Enabled: true, MaxDepth: 1, Concurrency: 1}
+	})
+	french, german := http.Header{"Accept-Language": {"fr"}}, http.Header{"Accept-Language": {"de"}}
+
+	// the French readers have a.html already, so only b.html is prefetched
+	proxy.run(t, []proxyStep{
+		{Path: "/a.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/a.html", Header: french, WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/index.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/index.html", Header: french, WantStatus: http.StatusOK, WantOriginRequests: 2},
+	})
+	waitForRequests(t, origin, "/b.html", 1)
+
+	// a cache hit schedules nothing, a German reader gets a.html in German
+	proxy.run(t, []proxyStep{
+		{Path: "/index.html", Header: french, WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/index.html", Header: german, WantStatus: http.StatusOK, WantOriginRequests: 3},
+	})
+	requests := waitForRequests(t, origin, "/a.html", 3)
+	if got := requests[2].Header.Get("Accept-Language"); got != "de" {
+		t.Errorf("a.html prefetched with Accept-Language %q, want de", got)
+	}
+
+	// prefetched pages aren't counted as looked up, and their links are
+	// past the depth
+	tenant, _ := proxy.storage.tenants.ByName("test")
+	ctx := withTenant(context.Background(), tenant)
+	if hits := proxy.storage.Hits(ctx, origin.URL, "b.html"); hits != 0 {
+		t.Errorf("prefetched b.html has %d hits", hits)
+	}
+	proxy.run(t, []proxyStep{
+		{Path: "/b.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/a.html", Header: german, WantStatus: http.StatusOK, WantOriginRequests: 3},
+	})
+	if got := len(origin.Requests("/b.html")); got != 1 {
+		t.Errorf("origin got %d requests for b.html, want 1", got)
+	}
+	if got := len(origin.Requests("/c.html")); got != 0 {
+		t.Errorf("c.html, two links away, was prefetched %d times", got)
+	}
+}
+
+func TestPrefetchLeavesAdmissionAlone(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/index.html": {Body: `<a href="/a.html">A</a>`},
+		"/a.html":     {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Prefetch = PrefetchConfig{Enabled: true, MaxDepth: 1, Concurrency: 1}
+		cfg.Admission.MinRequests = 2
+	})
+
+	// a.html isn't popular enough to be admitted, so it isn't prefetched
+	proxy.run(t, []proxyStep{
+		{Path: "/index.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/index.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+	})
+	waitForRequests(t, origin, "/index.html", 2)
+	time.Sleep(10 * time.Millisecond)
+	// the first request for a.html is its first for the admission policy
+	proxy.run(t, []proxyStep{
+		{Path: "/a.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/a.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+		{Path: "/a.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+	})
+}
@@ -0,0 +1,1010 @@
+package blogproxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const essay = "<html><body><p>How to do great work.</p></body></html>"
+
+func etagOf(body string) string {
+	sum := md5.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     map[string]originPage
+		configure func(*Config)
+		steps     []proxyStep
+	}{
+		{
+			name:  "miss then hit",
+			pages: map[string]originPage{"/essay.html": {Body: essay}},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantBody:           "great work",
+					WantHeader:         map[string]string{"Content-Type": "text/html; charset=utf-8", "ETag": etagOf(essay)},
+					WantOriginRequests: 1,
+				},
+				{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "great work", WantOriginRequests: 1},
+			},
+		},
+		{
+			name:  "refetched once expired",
+			pages: map[string]originPage{"/essay.html": {Body: essay}},
+			configure: func(cfg *Config) {
+				cfg.Tenants[0].TTL = Duration(time.Hour)
+			},
+			steps: []proxyStep{
+				{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+				{Advance: 59 * time.Minute, Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+				{
+					Advance:            2 * time.Minute,
+					Origin:             map[string]originPage{"/essay.html": {Body: "<p>Revised.</p>"}},
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantBody:           "Revised.",
+					WantOriginRequests: 2,
+				},
+			},
+		},
+		{
+			name:  "stale copy served over budget until the next day",
+			pages: map[string]originPage{"/essay.html": {Body: essay}},
+			configure: func(cfg *Config) {
+				cfg.Tenants[0].TTL = Duration(time.Hour)
+				cfg.Bandwidth.DailyBytes = 1
+			},
+			steps: []proxyStep{
+				{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+				{Advance: 2 * time.Hour, Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "great work", WantOriginRequests: 1},
+				{Advance: 22 * time.Hour, Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 2},
+			},
+		},
+		{
+			name:  "conditional request",
+			pages: map[string]originPage{"/essay.html": {Body: essay}},
+			steps: []proxyStep{
+				{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+				{
+					Path:               "/essay.html",
+					Header:             http.Header{"If-Modified-Since": {"Mon, 01 Jan 2024 00:00:00 GMT"}},
+					WantStatus:         http.StatusNotModified,
+					WantOriginRequests: 1,
+				},
+			},
+		},
+		{
+			name:  "origin error is not cached",
+			pages: map[string]originPage{"/essay.html": {Status: http.StatusInternalServerError, Body: "oops"}},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					WantStatus:         http.StatusBadGateway,
+					WantHeader:         map[string]string{"Cache-Control": "no-store"},
+					WantOriginRequests: 1,
+				},
+				{
+					Origin:             map[string]originPage{"/essay.html": {Body: essay}},
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantBody:           "great work",
+					WantOriginRequests: 2,
+				},
+			},
+		},
+		{
+			name:  "missing page",
+			pages: map[string]originPage{},
+			steps: []proxyStep{
+				{Path: "/missing.html", WantStatus: http.StatusNotFound, WantOriginRequests: 1},
+			},
+		},
+		{
+			name:  "unsupported media type",
+			pages: map[string]originPage{"/app.bin": {ContentType: "application/octet-stream", Body: "\x00\x01"}},
+			steps: []proxyStep{
+				{Path: "/app.bin", WantStatus: http.StatusUnsupportedMediaType, WantOriginRequests: 1},
+			},
+		},
+		{
+			name:  "origin too slow",
+			pages: map[string]originPage{"/essay.html": {Body: essay, Latency: 500 * time.Millisecond}},
+			configure: func(cfg *Config) {
+				cfg.Fetch.ResponseHeaderTimeout = Duration(50 * time.Millisecond)
+			},
+			steps: []proxyStep{
+				{Path: "/essay.html", WantStatus: http.StatusGatewayTimeout, WantOriginRequests: 1},
+			},
+		},
+		{
+			name:  "denied path",
+			pages: map[string]originPage{"/private/notes.html": {Body: essay}},
+			configure: func(cfg *Config) {
+				cfg.DenyPaths = map[string][]string{cfg.Tenants[0].AllowedHosts[0]: {"^/private/"}}
+			},
+			steps: []proxyStep{
+				{Path: "/private/notes.html", WantStatus: http.StatusForbidden, WantOriginRequests: 0},
+			},
+		},
+		{
+			name: "cache control rules",
+			pages: map[string]originPage{"/essay.html": {
+				Body:   essay,
+				Header: http.Header{"Cache-Control": {"no-cache"}},
+			}},
+			configure: func(cfg *Config) {
+				cfg.CacheControl = []CacheControlRule{{ContentType: "text/html", MaxAge: Duration(time.Hour)}}
+			},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantHeader:         map[string]string{"Cache-Control": "public, max-age=3600"},
+					WantOriginRequests: 1,
+				},
+			},
+		},
+		{
+			name:  "cache control private with tenant api keys",
+			pages: map[string]originPage{"/essay.html": {Body: essay}},
+			configure: func(cfg *Config) {
+				cfg.Tenants[0].APIKeys = []string{"key"}
+				cfg.CacheControl = []CacheControlRule{{MaxAge: Duration(time.Hour), SMaxAge: Duration(time.Hour), SurrogateMaxAge: Duration(time.Hour)}}
+			},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantHeader:         map[string]string{"Cache-Control": "private, max-age=3600", "Surrogate-Control": ""},
+					WantOriginRequests: 1,
+				},
+			},
+		},
+		{
+			name: "response header rules",
+			pages: map[string]originPage{
+				"/essay.html":      {Body: essay},
+				"/fonts/body.woff": {ContentType: "text/plain", Body: "font"},
+			},
+			configure: func(cfg *Config) {
+				cfg.CacheControl = []CacheControlRule{{MaxAge: Duration(time.Hour)}}
+				cfg.ResponseHeaders = []HeaderRule{
+					{Headers: map[string]string{"X-Frame-Options": "DENY"}},
+					{Path: `^/fonts/`, Headers: map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}},
+					{ContentType: "text/html", Headers: map[string]string{"X-Frame-Options": "", "Link": "</fonts/body.woff>; rel=preload"}},
+				}
+			},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					WantStatus:         http.StatusOK,
+					WantHeader:         map[string]string{"Cache-Control": "public, max-age=3600", "X-Frame-Options": "", "Link": "</fonts/body.woff>; rel=preload"},
+					WantOriginRequests: 1,
+				},
+				{
+					Path:               "/fonts/body.woff",
+					WantStatus:         http.StatusOK,
+					WantHeader:         map[string]string{"Cache-Control": "public, max-age=31536000, immutable", "X-Frame-Options": "DENY", "Link": ""},
+					WantOriginRequests: 1,
+				},
+			},
+		},
+		{
+			name: "language variants",
+			pages: map[string]originPage{"/essay.html": {
+				Body:   essay,
+				Header: http.Header{"Vary": {"Accept-Language"}},
+			}},
+			steps: []proxyStep{
+				{
+					Path:               "/essay.html",
+					Header:             http.Header{"Accept-Language": {"en-GB"}},
+					WantStatus:         http.StatusOK,
+					WantHeader:         map[string]string{"Vary": "Accept-Language"},
+					WantOriginRequests: 1,
+				},
+				{
+					Path:               "/essay.html",
+					Header:             http.Header{"Accept-Language": {"fr"}},
+					WantStatus:         http.StatusOK,
+					WantOriginRequests: 2,
+				},
+				{
+					Path:               "/essay.html",
+					Header:             http.Header{"Accept-Language": {"fr-CA, en;q=0.5"}},
+					WantStatus:         http.StatusOK,
+					WantOriginRequests: 2,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newFakeOrigin(t, tt.pages)
+			newTestProxy(t, origin, tt.configure).run(t, tt.steps)
+		})
+	}
+}
+
+func TestProxyHostNotAllowed(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	other := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, nil)
+	proxy.origin = other
+
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusNotFound, WantOriginRequests: 0},
+	})
+}
+
+func TestProxySoftPurge(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, nil)
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+	})
+
+	if !proxy.storage.Purge(origin.URL, "essay.html", true) {
+		t.Fatal("soft purge found nothing cached")
+	}
+	// a revalidation already in flight: the stale copy is served meanwhile
+	key := cacheKey("test|"+origin.URL, "essay.html")
+	proxy.storage.revalidations.Start(key)
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "great work", WantOriginRequests: 1},
+	})
+	proxy.storage.revalidations.Done(key)
+
+	proxy.run(t, []proxyStep{
+		{
+			Origin:             map[string]originPage{"/essay.html": {Status: http.StatusBadGateway}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantBody:           "great work",
+			WantOriginRequests: 2,
+		},
+		{
+			Origin:             map[string]originPage{"/essay.html": {Body: "<p>Revised.</p>"}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantBody:           "Revised.",
+			WantOriginRequests: 3,
+		},
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "Revised.", WantOriginRequests: 3},
+	})
+
+	if !proxy.storage.Purge(origin.URL, "essay.html", false) {
+		t.Fatal("hard purge found nothing cached")
+	}
+	proxy.run(t, []proxyStep{
+		{
+			Origin:             map[string]originPage{"/essay.html": {Status: http.StatusBadGateway}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusBadGateway,
+			WantOriginRequests: 4,
+		},
+	})
+}
+
+func TestProxyEarlyRefresh(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Tenants[0].TTL = Duration(time.Hour)
+		cfg.Cache.EarlyRefresh = 1
+	})
+
+	// an hour from expiry, a fetch of a few milliseconds never qualifies
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+	})
+
+	// right before expiry every hit does, and is served the cached copy
+	proxy.clock.Advance(time.Hour - time.Nanosecond)
+	origin.Set("/essay.html", originPage{Body: "<p>Revised.</p>"})
+	if rec := proxy.Get("/essay.html", nil); !strings.Contains(rec.Body.String(), "great work") {
+		t.Fatalf("early refresh held up the hit: %q", rec.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(proxy.Get("/essay.html", nil).Body.String(), "Revised.") {
+		if time.Now().After(deadline) {
+			t.Fatal("page not refreshed early")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(origin.Requests("/essay.html")); got != 2 {
+		t.Errorf("origin got %d requests, want 2", got)
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, nil)
+
+	for i, want := range []objectResponse{
+		{Source: SourceOrigin, Hits: 0},
+		{Source: SourceCache, Hits: 1},
+		{Source: SourceCache, Hits: 2},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/object?url="+url.QueryEscape(origin.URL+"/essay.html"), nil)
+		rec := httptest.NewRecorder()
+		proxy.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("lookup %d: status %d", i, rec.Code)
+		}
+
+		var got objectResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Source != want.Source || got.Hits != want.Hits {
+			t.Errorf("lookup %d: source %s with %d hits, want %s with %d", i, got.Source, got.Hits, want.Source, want.Hits)
+		}
+		if got.Etag != etagOf(essay) || got.Size != len(essay) || !got.ExpiresAt.Equal(got.FetchedAt.Add(defaultTTL)) {
+			t.Errorf("lookup %d: wrong metadata %+v", i, got)
+		}
+	}
+	if got := len(origin.Requests("/essay.html")); got != 1 {
+		t.Errorf("origin got %d requests, want 1", got)
+	}
+}
+
+func TestBatchPrefetch(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/a.html": {Body: essay},
+		"/b.html": {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.BatchPrefetch.HostDelay = Duration(time.Millisecond)
+	})
+
+	urls := []string{origin.URL + "/a.html", origin.URL + "/b.html", origin.URL + "/missing.html", "not a url"}
+	body, _ := json.Marshal(urls)
+	rec := httptest.NewRecorder()
+	proxy.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/prefetch", strings.NewReader(string(body))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/prefetch = %d: %s", rec.Code, rec.Body)
+	}
+	var started batchStartResponse
+	if err := json.NewDecoder(rec.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+
+	var job batchJob
+	deadline := time.Now().Add(5 * time.Second)
+	for job.State != "done" {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not done: %+v", job)
+		}
+		time.Sleep(time.Millisecond)
+		rec := httptest.NewRecorder()
+		proxy.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/prefetch/"+started.ID, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/prefetch/%s = %d", started.ID, rec.Code)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range []string{batchDone, batchDone, batchFailed, batchFailed} {
+		if got := job.URLs[i].Status; got != want {
+			t.Errorf("%s: status %s, want %s", urls[i], got, want)
+		}
+	}
+	proxy.run(t, []proxyStep{
+		{Path: "/a.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/b.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+	})
+}
+
+func TestProxyCompressed(t *testing.T) {
+	var gzipped strings.Builder
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(essay))
+	zw.Close()
+
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: gzipped.String(), Header: http.Header{"Content-Encoding": {"gzip"}}},
+	})
+	proxy := newTestProxy(t, origin, nil)
+	proxy.run(t, []proxyStep{
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"Accept-Encoding": {"gzip, br"}},
+			WantStatus:         http.StatusOK,
+			WantBody:           gzipped.String(),
+			WantHeader:         map[string]string{"Content-Encoding": "gzip", "Vary": "Accept-Encoding", "ETag": etagOf(essay) + "-gzip"},
+			WantOriginRequests: 1,
+		},
+		{
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantBody:           essay,
+			WantHeader:         map[string]string{"Content-Encoding": "", "ETag": etagOf(essay)},
+			WantOriginRequests: 1,
+		},
+		{
+			// the validator of the identity body doesn't match the gzip one
+			Path:               "/essay.html",
+			Header:             http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etagOf(essay)}},
+			WantStatus:         http.StatusOK,
+			WantBody:           gzipped.String(),
+			WantOriginRequests: 1,
+		},
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"Accept-Encoding": {"gzip;q=0"}},
+			WantStatus:         http.StatusOK,
+			WantBody:           essay,
+			WantHeader:         map[string]string{"Content-Encoding": ""},
+			WantOriginRequests: 1,
+		},
+	})
+
+	if got := proxy.origin.Requests("/essay.html")[0].Header.Get("Accept-Encoding"); got != "gzip" {
+		t.Errorf("origin got Accept-Encoding %q, want gzip", got)
+	}
+	rec := httptest.NewRecorder()
+	proxy.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/object?url="+url.QueryEscape(origin.URL+"/essay.html"), nil))
+	var meta objectResponse
+	if err := json.NewDecoder(rec.Body).Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentEncoding != "gzip" || meta.Size != gzipped.Len() {
+		t.Errorf("stored %d bytes encoded %q, want the %d gzipped bytes", meta.Size, meta.ContentEncoding, gzipped.Len())
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+	})
+	server := httptest.NewServer(proxy.handler)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/admin/events?type=miss,hit,purge", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Advance: time.Second, Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+	})
+	proxy.storage.Purge(origin.URL, "essay.html", false)
+
+	var got []CacheEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for len(got) < 3 && scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event CacheEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, event)
+		}
+	}
+	if len(got) < 3 {
+		t.Fatalf("got %d events before the stream ended: %v", len(got), scanner.Err())
+	}
+	for i, want := range []string{eventMiss, eventHit, eventPurge} {
+		if got[i].Type != want || got[i].Tenant != "test" || got[i].URL != origin.URL+"/essay.html" {
+			t.Errorf("event %d = %+v, want %s of the essay for tenant test", i, got[i], want)
+		}
+	}
+}
+
+func TestStorageErrors(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/broken":     {Status: http.StatusInternalServerError},
+		"/image.png":  {ContentType: "image/png", Body: "\x89PNG"},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Fetch.MaxBodyBytes = int64(len(essay)) - 1
+		cfg.DenyPaths = map[string][]string{origin.URL: {"^/admin"}}
+	})
+	tenant, _ := proxy.storage.tenants.ByName("test")
+	ctx := withTenant(context.Background(), tenant)
+
+	tests := []struct {
+		hostName, pageName string
+		want               error
+		wantStatus         int
+	}{
+		{hostName: "", pageName: "essay.html", want: ErrInvalidArgument},
+		{hostName: origin.URL, pageName: "", want: ErrInvalidArgument},
+		{hostName: origin.URL, pageName: "essay.html" + variantSeparator + "fr", want: ErrInvalidArgument},
+		{hostName: "https://elsewhere.example", pageName: "essay.html", want: ErrNotAllowed},
+		{hostName: origin.URL, pageName: "admin/login", want: ErrNotAllowed},
+		{hostName: origin.URL, pageName: "image.png", want: ErrNotAllowed},
+		{hostName: origin.URL, pageName: "missing.html", want: ErrNotFound, wantStatus: http.StatusNotFound},
+		{hostName: origin.URL, pageName: "broken", wantStatus: http.StatusInternalServerError},
+		{hostName: origin.URL, pageName: "essay.html", want: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := proxy.storage.Get(ctx, tt.hostName, tt.pageName)
+		if err == nil {
+			t.Errorf("Get %s/%s succeeded", tt.hostName, tt.pageName)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Get %s/%s = %v, want %v", tt.hostName, tt.pageName, err, tt.want)
+		}
+		var upstream *UpstreamError
+		if errors.As(err, &upstream) != (tt.wantStatus != 0) || tt.wantStatus != 0 && upstream.Status != tt.wantStatus {
+			t.Errorf("Get %s/%s = %v, want an upstream status of %d", tt.hostName, tt.pageName, err, tt.wantStatus)
+		}
+	}
+}
+
+func TestProxyCORS(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.CORS = CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com", "https://*.readers.example"},
+			MaxAge:         Duration(10 * time.Minute),
+		}
+	})
+	proxy.run(t, []proxyStep{
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"Origin": {"https://app.example.com"}},
+			WantStatus:         http.StatusOK,
+			WantHeader:         map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Vary": "Origin"},
+			WantOriginRequests: 1,
+		},
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"Origin": {"https://eu.readers.example"}},
+			WantStatus:         http.StatusOK,
+			WantHeader:         map[string]string{"Access-Control-Allow-Origin": "https://eu.readers.example"},
+			WantOriginRequests: 1,
+		},
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"Origin": {"https://evil.example"}},
+			WantStatus:         http.StatusOK,
+			WantHeader:         map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+			WantOriginRequests: 1,
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodOptions, "/?url="+url.QueryEscape(origin.URL+"/essay.html"), nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "x-api-key")
+	rec := httptest.NewRecorder()
+	proxy.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight = %d, want 204", rec.Code)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Headers": "x-api-key",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("preflight header %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOriginHealth(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Status: http.StatusBadGateway}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.Fetch.BreakerFailures = 2
+		cfg.Fetch.BreakerCooldown = Duration(time.Minute)
+	})
+
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusBadGateway, WantOriginRequests: 1},
+		{Path: "/essay.html", WantStatus: http.StatusBadGateway, WantOriginRequests: 2},
+		// the breaker is open
+		{Path: "/essay.html", WantStatus: http.StatusServiceUnavailable, WantOriginRequests: 2},
+		// the cooldown is over, the probe finds the origin recovered
+		{
+			Advance:            time.Minute,
+			Origin:             map[string]originPage{"/essay.html": {Body: essay}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantOriginRequests: 3,
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/origins", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	proxy.handler.ServeHTTP(rec, req)
+	var got []OriginStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("GET /admin/origins = %d %q: %v", rec.Code, rec.Body, err)
+	}
+	var status *OriginStatus
+	for i := range got {
+		if got[i].HostName == origin.URL {
+			status = &got[i]
+		}
+	}
+	if status == nil {
+		t.Fatalf("GET /admin/origins = %+v, lacks %s", got, origin.URL)
+	}
+	if status.Breaker != breakerClosed || status.ConsecutiveFailures != 0 || status.LastSuccess == nil || status.BytesToday != int64(len(essay)) {
+		t.Errorf("status of the origin = %+v, want a closed breaker after a success fetching the essay", *status)
+	}
+}
+
+func TestProxyRefusesControlCharacters(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {
+		Body:   essay,
+		Header: http.Header{"Vary": {"Accept-Language"}},
+	}})
+	proxy := newTestProxy(t, origin, nil)
+
+	french := http.Header{"Accept-Language": {"fr"}}
+	if rec := proxy.Get("/essay.html", french); rec.Code != http.StatusOK {
+		t.Fatalf("GET /essay.html = %d", rec.Code)
+	}
+	// percent-decoded, the url names the key of the French variant
+	for _, target := range []string{"/essay.html\x00lang=fr", "/essay.html\nx", "/essay.html\x7f"} {
+		if rec := proxy.Get(target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %q = %d, want 404", target, rec.Code)
+		}
+	}
+	if got := len(origin.Requests("/essay.html")); got != 1 {
+		t.Errorf("origin got %d requests, want 1", got)
+	}
+}
+
+func TestAdminRoutesNeedAdminClient(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.ClientAccess.Admin.Allow = []string{"10.0.0.0/8"}
+	})
+	if rec := proxy.Get("/essay.html", nil); rec.Code != http.StatusOK {
+		t.Fatalf("GET /essay.html = %d", rec.Code)
+	}
+
+	purge := `{"query":"mutation { purge(url: \"` + origin.URL + `/essay.html\") }"}`
+	tests := []struct {
+		name   string
+		remote string
+		token  string
+		want   bool
+	}{
+		{"no token", "10.0.0.1:1234", "", false},
+		{"client not allowed", "192.0.2.1:1234", "secret", false},
+		{"admin", "10.0.0.1:1234", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tt.remote
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			proxy.handler.ServeHTTP(rec, req)
+			if got := rec.Code == http.StatusOK; got != tt.want {
+				t.Errorf("GET /metrics = %d", rec.Code)
+			}
+
+			req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(purge))
+			req.RemoteAddr = tt.remote
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec = httptest.NewRecorder()
+			proxy.handler.ServeHTTP(rec, req)
+			if got := strings.Contains(rec.Body.String(), `"purge":true`); got != tt.want {
+				t.Errorf("purge mutation = %d %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Maintenance.Enabled = true
+	})
+
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusServiceUnavailable, WantBody: "maintenance"},
+	})
+	for _, path := range []string{"/read", "/api/page", "/api/object", "/pdf", "/epub"} {
+		req := httptest.NewRequest(http.MethodGet, path+"?url="+url.QueryEscape(origin.URL+"/essay.html"), nil)
+		rec := httptest.NewRecorder()
+		proxy.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s in maintenance = %d, want 503", path, rec.Code)
+		}
+	}
+
+	// background fetches, and the gRPC API, go to Storage directly
+	tenant, _ := proxy.storage.tenants.ByName("test")
+	ctx := withTenant(context.Background(), tenant)
+	if _, err := proxy.storage.Get(ctx, origin.URL, "essay.html"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get in maintenance = %v, want ErrUnavailable", err)
+	}
+	if got := len(origin.Requests("/essay.html")); got != 0 {
+		t.Errorf("origin got %d requests in maintenance, want 0", got)
+	}
+
+	proxy.storage.maintenance.enabled.Store(false)
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+	})
+}
+
+func TestOriginHealthIgnoresCallers(t *testing.T) {
+	health := NewOriginHealth(FetchConfig{BreakerFailures: 2, BreakerCooldown: Duration(time.Minute)}, newFakeClock())
+	const hostName = "https://paulgraham.com"
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	for _, caller := range []context.Context{canceled, expired} {
+		// the errors Storage.fetch returns for a reader going away mid-fetch
+		err := fetchError(caller, caller, &url.Error{Op: "Get", URL: hostName, Err: caller.Err()})
+		if originFailed(err) {
+			t.Errorf("originFailed(%v) = true for the caller giving up", err)
+		}
+		for range 3 {
+			health.Record(hostName, 0, err)
+		}
+	}
+	if err := health.Allow(hostName); err != nil {
+		t.Fatalf("breaker tripped by callers giving up: %v", err)
+	}
+
+	health.Record(hostName, 0, &UpstreamError{Err: errors.New("connection refused")})
+	health.Record(hostName, 0, &UpstreamError{Status: http.StatusBadGateway})
+	if err := health.Allow(hostName); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Allow after two origin failures = %v, want errCircuitOpen", err)
+	}
+}
+
+func TestProxyLastModified(t *testing.T) {
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay, Header: http.Header{"Last-Modified": {lastModified}}},
+	})
+	proxy := newTestProxy(t, origin, nil)
+
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantHeader: map[string]string{"Last-Modified": lastModified}, WantOriginRequests: 1},
+		{
+			Path:               "/essay.html",
+			Header:             http.Header{"If-Modified-Since": {lastModified}},
+			WantStatus:         http.StatusNotModified,
+			WantOriginRequests: 1,
+		},
+		// expired, the origin answers the revalidation that it didn't change
+		{
+			Advance:            25 * time.Hour,
+			Origin:             map[string]originPage{"/essay.html": {Status: http.StatusNotModified}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantBody:           essay,
+			WantHeader:         map[string]string{"Last-Modified": lastModified},
+			WantOriginRequests: 2,
+		},
+		// fresh again
+		{Advance: time.Hour, Path: "/essay.html", WantStatus: http.StatusOK, WantBody: essay, WantOriginRequests: 2},
+	})
+	requests := origin.Requests("/essay.html")
+	if got := requests[1].Header.Get("If-Modified-Since"); got != lastModified {
+		t.Errorf("revalidation If-Modified-Since = %q, want %q", got, lastModified)
+	}
+	if got := requests[0].Header.Get("If-Modified-Since"); got != "" {
+		t.Errorf("first fetch sent If-Modified-Since %q", got)
+	}
+}
+
+func TestProxyCorruptDiskObject(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Cache.DiskDir = t.TempDir()
+	})
+	disk := proxy.storage.cache.(*TieredCache).disk
+	namespace := "test|" + origin.URL
+	for _, page := range []string{"essay.html", "gone.html"} {
+		disk.Put(namespace, page, Object{
+			ContentType: "text/html",
+			Content:     []byte("<html>bit rot</html>"),
+			Digest:      contentDigest("sha256", []byte(essay)),
+			ExpiryTime:  proxy.clock.Now().Add(time.Hour),
+		})
+	}
+
+	proxy.run(t, []proxyStep{
+		// the corrupt copy is dropped and fetched again
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: essay, WantOriginRequests: 1},
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: essay, WantOriginRequests: 1},
+		// and fails when it can't be
+		{Path: "/gone.html", WantStatus: http.StatusBadGateway, WantOriginRequests: 1},
+		{Path: "/gone.html", WantStatus: http.StatusNotFound, WantOriginRequests: 2},
+	})
+	if _, ok := disk.Get(namespace, "gone.html"); ok {
+		t.Error("the corrupt copy is still on disk")
+	}
+}
+
+func TestProxyCacheVersion(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	dir := t.TempDir()
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Cache.DiskDir = dir
+		cfg.Cache.Version = "v2"
+	})
+	old, err := NewDiskCache(dir, "v1")
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	stale := Object{
+		ContentType: "text/html",
+		Content:     []byte("<html>transformed by v1</html>"),
+		ExpiryTime:  proxy.clock.Now().Add(time.Hour),
+	}
+	namespace := "test|" + origin.URL
+	old.Put(namespace, "essay.html", stale)
+
+	proxy.run(t, []proxyStep{
+		// the copy of the previous version is not served
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: essay, WantOriginRequests: 1},
+	})
+
+	proxy.storage.cache.(*TieredCache).disk.List()
+	if _, ok := old.Get(namespace, "essay.html"); ok {
+		t.Error("the copy of the previous version is still on disk")
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := writeSnapshot(&snapshot, "v1", []CacheEntry{{HostName: namespace, PageName: "other.html", Object: stale}}); err != nil {
+		t.Fatalf("writeSnapshot: %v", err)
+	}
+	if n, err := proxy.storage.Restore(&snapshot); err != nil || n != 0 {
+		t.Errorf("Restore of a v1 snapshot = %d, %v, want 0 objects", n, err)
+	}
+}
+
+func TestProxyDebugHeaders(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Debug.Token = "debug-token-0123456789"
+		cfg.Tenants[0].TTL = Duration(time.Hour)
+		cfg.Transforms = map[string]TransformRules{origin.URL: {Strip: []string{"script"}}}
+	})
+	debug := http.Header{debugHeader: {"debug-token-0123456789"}}
+	key := "test|" + origin.URL + "/essay.html"
+
+	rec := proxy.Get("/essay.html", debug)
+	if got, want := rec.Header().Get("X-Debug-Upstream"), "origin "+origin.URL+"; took="; !strings.HasPrefix(got, want) {
+		t.Errorf("X-Debug-Upstream = %q, want it to start with %q", got, want)
+	}
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", Advance: 10 * time.Minute, Header: debug, WantStatus: http.StatusOK, WantOriginRequests: 1, WantHeader: map[string]string{
+			"X-Debug-Cache":      "hit",
+			"X-Debug-Cache-Key":  key,
+			"X-Debug-TTL":        "50m0s",
+			"X-Debug-Transforms": "strip script",
+			"X-Debug-Upstream":   "none",
+		}},
+		{Path: "/essay.html", Advance: time.Hour, Header: debug, WantStatus: http.StatusOK, WantOriginRequests: 2, WantHeader: map[string]string{
+			"X-Debug-Cache": "refresh; expired",
+			"X-Debug-TTL":   "1h0m0s",
+		}},
+		// without the token, or with a wrong one, nothing is told
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 2, WantHeader: map[string]string{
+			"X-Debug-Cache": "",
+		}},
+		{Path: "/essay.html", Header: http.Header{debugHeader: {"guess"}}, WantStatus: http.StatusOK, WantOriginRequests: 2, WantHeader: map[string]string{
+			"X-Debug-Cache": "",
+		}},
+	})
+	if got := rec.Header().Get("X-Debug-Cache"); got != "miss" {
+		t.Errorf("X-Debug-Cache of the first request = %q, want miss", got)
+	}
+}
+
+func TestProxyHostSpellings(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html":   {Body: essay},
+		"/wp-login.php": {Body: essay},
+	})
+	port := origin.URL[strings.LastIndex(origin.URL, ":")+1:]
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Tenants[0].AllowedHosts = []string{"localhost:" + port}
+		cfg.DenyPaths = map[string][]string{"http://LocalHost:" + port + "/": {`^/wp-login\.php$`}}
+	})
+
+	tests := []struct {
+		target       string
+		want         int
+		wantRequests int
+	}{
+		{"http://localhost:" + port + "/essay.html", http.StatusOK, 1},
+		// every spelling of the host shares the cached copy
+		{"http://LOCALHOST:" + port + "/essay.html", http.StatusOK, 1},
+		{"HTTP://LocalHost:" + port + "/essay.html", http.StatusOK, 1},
+		// and the deny rules
+		{"http://localhost:" + port + "/wp-login.php", http.StatusForbidden, 0},
+		{"http://LocalHost:" + port + "/wp-login.php", http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		rec := proxy.GetURL(tt.target, nil)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.target, rec.Code, tt.want)
+		}
+		_, pageName, _ := strings.Cut(strings.TrimPrefix(strings.ToLower(tt.target), "http://"), "/")
+		if got := len(origin.Requests("/" + pageName)); got != tt.wantRequests {
+			t.Errorf("GET %s: origin got %d requests, want %d", tt.target, got, tt.wantRequests)
+		}
+	}
+}
+
+func TestProxyDenyPaths(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html":   {Body: essay},
+		"/wp-login.php": {Body: essay},
+	})
+	port := origin.URL[strings.LastIndex(origin.URL, ":")+1:]
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Tenants[0].AllowedHosts = []string{"localhost:" + port}
+		cfg.DenyPaths = map[string][]string{"http://localhost:" + port: {`^/wp-login\.php$`}}
+	})
+
+	host := "http://localhost:" + port
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{host + "/wp-login.php", http.StatusForbidden},
+		{host + "/wp-login.php?x=1", http.StatusForbidden},
+		{host + "/wp-login.php#login", http.StatusForbidden},
+		{"http://LocalHost:" + port + "/wp-login.php", http.StatusForbidden},
+		{"http://LOCALHOST:" + port + "/wp-login.php?x=1", http.StatusForbidden},
+		{host + "/essay.html?next=/wp-login.php", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := proxy.GetURL(tt.target, nil); rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.target, rec.Code, tt.want)
+		}
+	}
+	if got := len(origin.Requests("/wp-login.php")); got != 0 {
+		t.Errorf("origin got %d requests for a denied path, want 0", got)
+	}
+}
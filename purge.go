@@ -0,0 +1,108 @@
+package blogproxy
+
+import (
+	log "log/slog"
+	"net/http"
+	"sync"
+)
+
+// Purge drops pageName from the cache of every tenant and reports whether
+// it was cached. A soft purge keeps the cached copies but marks them
+// stale, like a Varnish soft purge: the next request fetches the page
+// again while concurrent ones are still served the stale copy, so purging
+// a popular page doesn't send every reader to the origin at once.
+func (s *Storage) Purge(hostName, pageName string, soft bool) bool {
+	var purged bool
+	for _, tenant := range s.tenants.byName {
+		if s.purge(tenant.namespace(hostName), pageName, soft) {
+			purged = true
+		}
+	}
+	if s.variants.Varies(cacheKey(hostName, pageName)) {
+		for _, entry := range s.cache.List() {
+			_, entryHost := splitNamespace(entry.HostName)
+			if entryPage, _ := splitVariant(entry.PageName); entryHost == hostName && entryPage == pageName {
+				if s.purge(entry.HostName, entry.PageName, soft) {
+					purged = true
+				}
+			}
+		}
+	}
+	log.Info("purged object", "host", hostName, "object", pageName, "soft", soft, "cached", purged)
+	return purged
+}
+
+func (s *Storage) purge(namespace, pageName string, soft bool) bool {
+	if !soft {
+		if !s.cache.Delete(namespace, pageName) {
+			return false
+		}
+		s.publish(eventPurge, namespace, pageName, "")
+		return true
+	}
+	obj, ok := s.cache.Get(namespace, pageName)
+	if !ok {
+		return false
+	}
+	if now := s.clock.Now(); obj.ExpiryTime.After(now) {
+		obj.ExpiryTime = now
+	}
+	obj.SoftPurged = true
+	s.cache.Put(namespace, pageName, obj)
+	s.publish(eventPurge, namespace, pageName, "soft")
+	return true
+}
+
+// keySet is a set of keys safe for concurrent use.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]struct{})}
+}
+
+// Start adds key and reports whether it was absent.
+func (k *keySet) Start(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[key]; ok {
+		return false
+	}
+	k.keys[key] = struct{}{}
+	return true
+}
+
+// Done removes key.
+func (k *keySet) Done(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, key)
+}
+
+// Take removes key and reports whether it was there.
+func (k *keySet) Take(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.keys[key]
+	delete(k.keys, key)
+	return ok
+}
+
+type purgeResponse struct {
+	Purged bool `json:"purged"`
+	Soft   bool `json:"soft"`
+}
+
+// handlePurge purges the page of the url parameter, softly with soft=true.
+func (srv *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hostName, pageName, ok := parseTargetURL(query.Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	soft := query.Get("soft") == "true"
+	writeJSON(w, http.StatusOK, purgeResponse{Purged: srv.storage.Purge(hostName, pageName, soft), Soft: soft})
+}
@@ -0,0 +1,79 @@
+package blogproxy
+
+import "container/list"
+
+var quotaEvictions = metrics.Counter(
+	"blogproxy_cache_quota_evictions_total",
+	"Objects evicted from memory because their host or tenant was over its quota.",
+	"scope",
+)
+
+// Quota bounds the memory a host or a tenant may take up in the cache. Zero
+// fields mean no limit.
+type Quota struct {
+	MaxBytes   int64 `json:"max_bytes"`
+	MaxEntries int   `json:"max_entries"`
+}
+
+func (q Quota) limited() bool {
+	return q.MaxBytes > 0 || q.MaxEntries > 0
+}
+
+func (q Quota) exceeded(size int64, entries int) bool {
+	return q.MaxBytes > 0 && size > q.MaxBytes || q.MaxEntries > 0 && entries > q.MaxEntries
+}
+
+// CacheQuotas holds the quotas of hosts and tenants.
+type CacheQuotas struct {
+	// host applies to every host without an entry in hosts. A host cached
+	// for several tenants has a quota in each.
+	host    Quota
+	hosts   map[string]Quota
+	tenants map[string]Quota
+}
+
+func NewCacheQuotas(cfg CacheConfig, tenants []TenantConfig) *CacheQuotas {
+	q := &CacheQuotas{
+		host:    cfg.HostQuota,
+		hosts:   canonicalHostKeys(cfg.HostQuotas),
+		tenants: make(map[string]Quota, len(tenants)),
+	}
+	for _, tenant := range tenants {
+		q.tenants[tenant.Name] = tenant.Quota
+	}
+	return q
+}
+
+// groups returns the names and quotas of the groups an object stored under
+// the namespaced host name belongs to.
+func (q *CacheQuotas) groups(namespaced string) (names []string, quotas []Quota) {
+	if q == nil {
+		return nil, nil
+	}
+	tenant, hostName := splitNamespace(namespaced)
+
+	hostQuota, ok := q.hosts[hostName]
+	if !ok {
+		hostQuota = q.host
+	}
+	if hostQuota.limited() {
+		names = append(names, "host:"+namespaced)
+		quotas = append(quotas, hostQuota)
+	}
+	if tenantQuota := q.tenants[tenant]; tenantQuota.limited() {
+		names = append(names, "tenant:"+tenant)
+		quotas = append(quotas, tenantQuota)
+	}
+	return names, quotas
+}
+
+// lruGroup is the LRU of the objects sharing a quota, evicted independently
+// of the objects of other groups.
+type lruGroup struct {
+	name string
+	// scope is "host" or "tenant", for the metrics
+	scope string
+	quota Quota
+	size  int64
+	lru   *list.List
+}
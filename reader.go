@@ -0,0 +1,169 @@
+package blogproxy
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readableText returns the text a reader sees on the page: the extracted
+// text of HTML, plain text as is, and nothing for other content.
+func readableText(obj Object) string {
+	switch parseMediaType(obj.ContentType) {
+	case "text/html":
+		return extractText(obj.Content)
+	case "text/plain":
+		return string(obj.Content)
+	}
+	return ""
+}
+
+var readerTemplate = template.Must(template.New("reader").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 40em; margin: 2em auto; padding: 0 1em; font: 18px/1.6 Georgia, serif; }
+mark { background: #fff3a0; }
+footer { margin-top: 3em; font-size: 14px; color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Paragraphs}}<p>{{range .}}{{if .Mark}}<mark{{if .Note}} title="{{.Note}}"{{end}}>{{.Text}}</mark>{{else}}{{.Text}}{{end}}{{end}}</p>
+{{end}}<footer>Original: <a href="{{.URL}}">{{.URL}}</a>, fetched {{.Fetched}}</footer>
+</body>
+</html>
+`))
+
+type readerSegment struct {
+	Text string
+	Mark bool
+	Note string
+}
+
+type readerPage struct {
+	Title      string
+	URL        string
+	Fetched    string
+	Paragraphs [][]readerSegment
+}
+
+// newReaderPage lays out the readable text of obj as paragraphs, with the
+// annotated ranges marked.
+func newReaderPage(url string, obj Object, annotations []Annotation) readerPage {
+	page := readerPage{
+		URL:     url,
+		Fetched: obj.UpdateTime.UTC().Format(time.RFC1123),
+	}
+	if isHTML(obj.ContentType) {
+		page.Title = extractTitle(obj.Content)
+	}
+	if page.Title == "" {
+		page.Title = url
+	}
+
+	text := []rune(readableText(obj))
+	marked := make([]*Annotation, len(text))
+	for i := range annotations {
+		for pos := max(annotations[i].Start, 0); pos < min(annotations[i].End, len(text)); pos++ {
+			marked[pos] = &annotations[i]
+		}
+	}
+
+	var paragraph []readerSegment
+	var current strings.Builder
+	var currentMark *Annotation
+	flush := func() {
+		if current.Len() > 0 {
+			segment := readerSegment{Text: current.String(), Mark: currentMark != nil}
+			if currentMark != nil {
+				segment.Note = currentMark.Note
+			}
+			paragraph = append(paragraph, segment)
+			current.Reset()
+		}
+	}
+	for pos, r := range text {
+		if r == '\n' {
+			flush()
+			if len(paragraph) > 0 {
+				page.Paragraphs = append(page.Paragraphs, paragraph)
+				paragraph = nil
+			}
+			continue
+		}
+		if marked[pos] != currentMark {
+			flush()
+			currentMark = marked[pos]
+		}
+		current.WriteRune(r)
+	}
+	flush()
+	if len(paragraph) > 0 {
+		page.Paragraphs = append(page.Paragraphs, paragraph)
+	}
+	return page
+}
+
+// handleReader serves a page in reader mode: its readable text in a plain
+// layout with the page's highlights marked.
+func (srv *Server) handleReader(w http.ResponseWriter, r *http.Request) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	url := cacheKey(hostName, pageName)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	readerTemplate.Execute(w, newReaderPage(url, obj, srv.annotations.ForURL(srv.storage.tenant(r.Context()).Name, url)))
+}
+
+type pageResponse struct {
+	URL         string       `json:"url"`
+	Title       string       `json:"title,omitempty"`
+	ContentType string       `json:"content_type"`
+	Etag        string       `json:"etag"`
+	FetchedAt   time.Time    `json:"fetched_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	Text        string       `json:"text"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// handlePageJSON returns the readable text of a page along with its
+// annotations.
+func (srv *Server) handlePageJSON(w http.ResponseWriter, r *http.Request) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	url := cacheKey(hostName, pageName)
+	resp := pageResponse{
+		URL:         url,
+		ContentType: obj.ContentType,
+		Etag:        obj.Etag,
+		FetchedAt:   obj.UpdateTime,
+		ExpiresAt:   obj.ExpiryTime,
+		Text:        readableText(obj),
+		Annotations: srv.annotations.ForURL(srv.storage.tenant(r.Context()).Name, url),
+	}
+	if isHTML(obj.ContentType) {
+		resp.Title = extractTitle(obj.Content)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,148 @@
+package blogproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	log "log/slog"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// renderCacheBytes bounds the memory held by rendered artifacts.
+const renderCacheBytes = 64 << 20
+
+// maxSlowRenders bounds the audio and PDF renderings running at once; each
+// can take minutes of CPU.
+const maxSlowRenders = 2
+
+// Renders caches artifacts derived from pages, such as audio or PDF
+// versions. Entries are keyed by the version of the page they were rendered
+// from, so a changed or refreshed page is rendered again. Concurrent requests for an
+// artifact not cached yet share a single rendering.
+type Renders struct {
+	cache *MemoryCache
+	slow  chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*pendingRender
+}
+
+// pendingRender is a rendering in progress; done is closed once rendered
+// and err are set.
+type pendingRender struct {
+	done     chan struct{}
+	rendered Object
+	err      error
+}
+
+func NewRenders() *Renders {
+	return &Renders{
+		cache:   NewMemoryCache(renderCacheBytes, nil),
+		slow:    make(chan struct{}, maxSlowRenders),
+		pending: make(map[string]*pendingRender),
+	}
+}
+
+// Render returns the kind artifact of obj, calling render on a miss. A
+// caller finding the same artifact being rendered waits for it instead,
+// until ctx is done.
+func (r *Renders) Render(ctx context.Context, kind, url string, obj Object, render func() (Object, error)) (Object, error) {
+	version := renderVersion(obj)
+	if rendered, ok := r.cache.Get(kind+":"+url, version); ok {
+		return rendered, nil
+	}
+
+	key := kind + ":" + url + "\x00" + version
+	r.mu.Lock()
+	if p, ok := r.pending[key]; ok {
+		r.mu.Unlock()
+		select {
+		case <-p.done:
+			return p.rendered, p.err
+		case <-ctx.Done():
+			return Object{}, ctx.Err()
+		}
+	}
+	p := &pendingRender{done: make(chan struct{})}
+	r.pending[key] = p
+	r.mu.Unlock()
+
+	p.rendered, p.err = r.render(kind, url, version, obj, render)
+	r.mu.Lock()
+	delete(r.pending, key)
+	r.mu.Unlock()
+	close(p.done)
+	return p.rendered, p.err
+}
+
+func (r *Renders) render(kind, url, version string, obj Object, render func() (Object, error)) (Object, error) {
+	rendered, err := render()
+	if err != nil {
+		return Object{}, err
+	}
+	rendered.Etag = kind + "-" + version
+	rendered.UpdateTime = obj.UpdateTime
+	rendered.LastModified = obj.LastModified
+	r.cache.Put(kind+":"+url, version, rendered)
+	return rendered, nil
+}
+
+// renderVersion identifies what an artifact of obj is rendered from: its
+// content and the time it was fetched, which the banners and the PDFs show
+// and a revalidation moves without changing the etag.
+func renderVersion(obj Object) string {
+	return obj.Etag + "-" + obj.UpdateTime.UTC().Format("20060102T150405")
+}
+
+// acquireSlow takes one of the slots of the slow renderings, or fails with
+// errOverloaded when they are all taken. The returned release func must be
+// called once the rendering is done.
+func (r *Renders) acquireSlow() (release func(), err error) {
+	select {
+	case r.slow <- struct{}{}:
+		return func() { <-r.slow }, nil
+	default:
+		return nil, errOverloaded
+	}
+}
+
+// serveRendered answers r with the kind artifact of the page named by the
+// url query parameter.
+func (srv *Server) serveRendered(w http.ResponseWriter, r *http.Request, kind string, render func(ctx context.Context, url string, obj Object) (Object, error)) {
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	obj, err := srv.storage.Get(r.Context(), hostName, pageName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := cacheKey(hostName, pageName)
+	rendered, err := srv.renders.Render(r.Context(), kind, page, obj, func() (Object, error) {
+		release, err := srv.renders.acquireSlow()
+		if err != nil {
+			return Object{}, err
+		}
+		defer release()
+		return render(r.Context(), page, obj)
+	})
+	if errors.Is(err, errOverloaded) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Error("failed to render page", "kind", kind, "url", page, "error", err)
+		http.Error(w, "failed to render page", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", rendered.ContentType)
+	w.Header().Set("ETag", rendered.Etag)
+	http.ServeContent(w, r, path.Base(pageName), rendered.ModTime(), bytes.NewReader(rendered.Content))
+}
@@ -0,0 +1,137 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRendersShareRendering(t *testing.T) {
+	renders := NewRenders()
+	obj := Object{Etag: etagOf(essay), Content: []byte(essay)}
+
+	var calls atomic.Int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	render := func() (Object, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-finish
+		return Object{ContentType: "audio/wav", Content: []byte("audio")}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]Object, 8)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = renders.Render(context.Background(), "audio", "http://a/essay.html", obj, render)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = renders.Render(context.Background(), "audio", "http://a/essay.html", obj, render)
+		}()
+	}
+	close(finish)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("rendered %d times, want once", got)
+	}
+	for i, rendered := range results {
+		if rendered.Etag != "audio-"+renderVersion(obj) || string(rendered.Content) != "audio" {
+			t.Errorf("caller %d got %+v", i, rendered)
+		}
+	}
+}
+
+func TestRendersWaiterGivesUp(t *testing.T) {
+	renders := NewRenders()
+	obj := Object{Etag: etagOf(essay)}
+	started, finish := make(chan struct{}), make(chan struct{})
+	defer close(finish)
+	go renders.Render(context.Background(), "audio", "http://a/essay.html", obj, func() (Object, error) {
+		close(started)
+		<-finish
+		return Object{}, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := renders.Render(ctx, "audio", "http://a/essay.html", obj, func() (Object, error) {
+		t.Error("rendered again while a rendering was in progress")
+		return Object{}, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Render = %v, want the caller's cancellation", err)
+	}
+}
+
+func TestRendersSlowSlots(t *testing.T) {
+	renders := NewRenders()
+	var releases []func()
+	for i := 0; i < maxSlowRenders; i++ {
+		release, err := renders.acquireSlow()
+		if err != nil {
+			t.Fatalf("slot %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := renders.acquireSlow(); !errors.Is(err, errOverloaded) {
+		t.Fatalf("acquireSlow with every slot taken = %v, want errOverloaded", err)
+	}
+	releases[0]()
+	release, err := renders.acquireSlow()
+	if err != nil {
+		t.Fatalf("acquireSlow after a release: %v", err)
+	}
+	release()
+}
+
+func TestAudio(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		// speaks by echoing the text back
+		cfg.TTS = TTSConfig{Backend: "command", Command: []string{"cat"}, ContentType: "text/plain"}
+	})
+
+	target := "/audio?url=" + url.QueryEscape(origin.URL+"/essay.html")
+	for i := 0; i < 2; i++ {
+		rec := proxy.Serve(httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "How to do great work.") {
+			t.Fatalf("GET /audio = %d %q", rec.Code, rec.Body)
+		}
+		if got, want := rec.Header().Get("ETag"), "audio-"+etagOf(essay)+"-20240101T000000"; got != want {
+			t.Errorf("ETag = %q, want %q", got, want)
+		}
+	}
+	if got := len(origin.Requests("/essay.html")); got != 1 {
+		t.Errorf("origin got %d requests, want 1", got)
+	}
+}
+
+func TestHTTPSynthesizerTooLarge(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(make([]byte, maxAudioBytes+1))
+	}))
+	defer api.Close()
+
+	synthesizer, err := NewSynthesizer(TTSConfig{Backend: "http", URL: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := synthesizer.Synthesize(context.Background(), "text"); err == nil {
+		t.Error("Synthesize accepted audio over the limit")
+	}
+}
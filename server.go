@@ -0,0 +1,434 @@
+package blogproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Server wires the storage into the HTTP routes of the proxy.
+type Server struct {
+	cfg             Config
+	storage         *Storage
+	trusted         TrustedProxies
+	clients         *ClientFilter
+	basicAuth       *BasicAuth
+	oidc            *OIDCAuth
+	signer          *URLSigner
+	maintenance     *Maintenance
+	prefetcher      *Prefetcher
+	batchPrefetcher *BatchPrefetcher
+	annotations     *Annotations
+	renders         *Renders
+	synthesizer     Synthesizer
+	linkChecker     *LinkChecker
+	fingerprints    *Fingerprints
+	transformer     *Transformer
+	jobs            *Jobs
+	cors            *CORS
+	headerRules     HeaderRules
+	slo             *SLO
+}
+
+func NewServer(cfg Config, storage *Storage) (*Server, error) {
+	trusted, err := ParseTrustedProxies(cfg.TrustedProxies, cfg.ForwardedHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trusted proxies: %w", err)
+	}
+
+	clients, err := NewClientFilter(cfg.ClientAccess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client access rules: %w", err)
+	}
+
+	basicAuth, err := NewBasicAuth(cfg.BasicAuth, storage.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateAuth(cfg); err != nil {
+		return nil, err
+	}
+	oidcAuth, err := NewOIDCAuth(context.Background(), cfg.OIDC, storage.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := NewAnnotations(cfg.Cache.DiskDir, storage.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	synthesizer, err := NewSynthesizer(cfg.TTS)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer, err := NewTransformer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cors, err := NewCORS(cfg.CORS)
+	if err != nil {
+		return nil, err
+	}
+
+	headerRules, err := NewHeaderRules(cfg.ResponseHeaders)
+	if err != nil {
+		return nil, err
+	}
+	slo, err := NewSLO(cfg.SLO, storage.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := NewJobs(storage.clock)
+	srv := &Server{
+		cfg:             cfg,
+		storage:         storage,
+		trusted:         trusted,
+		clients:         clients,
+		basicAuth:       basicAuth,
+		oidc:            oidcAuth,
+		signer:          NewURLSigner(cfg.SignedURLs, basicAuth == nil && oidcAuth == nil, storage.clock),
+		maintenance:     storage.maintenance,
+		annotations:     annotations,
+		renders:         NewRenders(),
+		synthesizer:     synthesizer,
+		fingerprints:    NewFingerprints(),
+		transformer:     transformer,
+		batchPrefetcher: NewBatchPrefetcher(storage, cfg.BatchPrefetch, jobs),
+		jobs:            jobs,
+		cors:            cors,
+		headerRules:     headerRules,
+		slo:             slo,
+	}
+	if cfg.Prefetch.Enabled {
+		srv.prefetcher = NewPrefetcher(storage, cfg.Prefetch)
+	}
+	if cfg.LinkCheck.Enabled {
+		if srv.linkChecker, err = NewLinkChecker(storage, cfg.LinkCheck, jobs); err != nil {
+			return nil, err
+		}
+		srv.linkChecker.Start(context.Background())
+	}
+	return srv, nil
+}
+
+func (srv *Server) Handler() http.Handler {
+	router := http.NewServeMux()
+
+	router.HandleFunc("GET /health", srv.handleHealth)
+	router.Handle("POST /graphql", newGraphQLHandler(srv))
+
+	// the routes fetching from the origins are closed in maintenance
+	maintained := func(handler http.HandlerFunc) http.Handler {
+		return srv.maintenance.Middleware()(handler)
+	}
+
+	router.Handle("POST /bookmarks", maintained(srv.handleAddBookmark))
+	router.HandleFunc("GET /bookmarks", srv.handleListBookmarks)
+	router.HandleFunc("GET /bookmarks/{id}", srv.handleGetBookmark)
+	router.HandleFunc("DELETE /bookmarks/{id}", srv.handleDeleteBookmark)
+
+	router.Handle("POST /annotations", maintained(srv.handleAddAnnotation))
+	router.HandleFunc("GET /annotations", srv.handleListAnnotations)
+	router.HandleFunc("DELETE /annotations/{id}", srv.handleDeleteAnnotation)
+
+	router.Handle("GET /read", maintained(srv.handleReader))
+	router.Handle("GET /api/page", maintained(srv.handlePageJSON))
+	router.Handle("GET /api/object", maintained(srv.handleObject))
+	router.Handle("POST /api/prefetch", maintained(srv.handleStartPrefetch))
+	router.HandleFunc("GET /api/prefetch/{id}", srv.handleGetPrefetch)
+	router.Handle("GET /audio", maintained(srv.handleAudio))
+	router.Handle("GET /pdf", maintained(srv.handlePDF))
+	router.Handle("GET /epub", maintained(srv.handleEPUB))
+	router.HandleFunc("GET /versions", srv.handleVersions)
+	router.HandleFunc("GET /diff", srv.handleDiff)
+	if srv.cfg.Favicon.Enabled {
+		router.Handle("GET /favicon.ico", maintained(srv.handleFavicon))
+	}
+	// maintenance windows are planned and spend no error budget
+	router.Handle("GET /", srv.maintenance.Middleware()(srv.slo.Middleware()(http.HandlerFunc(srv.handleProxy))))
+
+	if peers := srv.storage.peers; peers != nil {
+		router.Handle("GET /_peer/object", peers.handleObject(srv.storage))
+	}
+
+	router.Handle("GET /stats", srv.adminOnly(srv.handleStats))
+	router.Handle("GET /metrics", srv.adminOnly(metrics.ServeHTTP))
+	router.Handle("GET /admin/maintenance", srv.adminOnly(srv.maintenance.handleGet))
+	router.Handle("POST /admin/maintenance", srv.adminOnly(srv.maintenance.handleSet))
+	router.Handle("POST /admin/purge", srv.adminOnly(srv.handlePurge))
+	router.Handle("POST /admin/snapshot", srv.adminOnly(srv.handleSnapshot))
+	router.Handle("POST /admin/restore", srv.adminOnly(srv.handleRestore))
+	router.Handle("GET /admin/duplicates", srv.adminOnly(srv.handleDuplicates))
+	router.Handle("GET /admin/events", srv.adminOnly(srv.handleEvents))
+	router.Handle("GET /admin/origins", srv.adminOnly(srv.handleOrigins))
+	router.Handle("GET /admin/jobs", srv.adminOnly(srv.handleListJobs))
+	router.Handle("GET /admin/jobs/{id}", srv.adminOnly(srv.handleGetJob))
+	router.Handle("POST /admin/jobs/{id}/cancel", srv.adminOnly(srv.handleCancelJob))
+	if srv.signer != nil {
+		router.Handle("POST /admin/sign", srv.adminOnly(srv.signer.handleSign))
+	}
+	if srv.linkChecker != nil {
+		router.Handle("GET /admin/linkcheck", srv.adminOnly(srv.linkChecker.handleReport))
+		router.Handle("POST /admin/linkcheck", srv.adminOnly(srv.linkChecker.handleRun))
+	}
+
+	var handler http.Handler = router
+	handler = srv.basicAuth.Middleware()(handler)
+	handler = srv.oidc.Middleware()(handler)
+	handler = srv.signer.Middleware(router)(handler)
+	handler = srv.cors.Middleware()(handler)
+	handler = languageMiddleware(handler)
+	handler = srv.storage.tenants.Middleware()(handler)
+	handler = srv.clients.Middleware()(handler)
+	return srv.trusted.Middleware()(handler)
+}
+
+func (srv *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (srv *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.storage.Stats())
+}
+
+func (srv *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	hostName, pageName, ok := parseTargetURL(r.URL.Query().Get("url"))
+	if !ok {
+		log.Error("invalid path", "path", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Info("get object", "host", hostName, "page", pageName, "client", ClientIP(ctx))
+
+	var trace *requestTrace
+	if srv.debugging(r) {
+		ctx, trace = withTrace(ctx)
+	}
+	stored, source, err := srv.storage.Lookup(ctx, hostName, pageName)
+	if trace != nil {
+		trace.writeHeaders(w.Header(), stored, err == nil, srv.storage.clock.Now())
+	}
+	if err != nil {
+		// errors must not be cached downstream, a CDN would keep serving
+		// them long after the origin has recovered
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if errors.Is(err, ErrInvalidArgument) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errPathDenied) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errOverloaded) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errBudgetExceeded) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(srv.storage.bandwidth.Reset().Sub(srv.storage.clock.Now()).Seconds())+1))
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(srv.storage.health.cooldown.Seconds())+1))
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errOriginTimeout) {
+		http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if errors.Is(err, ErrTooLarge) {
+		http.Error(w, "origin body too large", http.StatusBadGateway)
+		return
+	}
+	if errors.Is(err, errCorruptObject) || originFailed(err) {
+		// an origin down or failing is a server error, for the SLO too; the
+		// pages it doesn't have are not
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the stored copy goes out still compressed to the clients accepting
+	// its encoding, and is only decompressed for the others or to be
+	// transformed
+	transform := isHTML(stored.ContentType) && srv.transformer.Has(hostName)
+	var transforms []string
+	served := stored
+	if stored.ContentEncoding != "" && (transform || !acceptsEncoding(r, stored.ContentEncoding)) {
+		if served, err = stored.Decoded(); err != nil {
+			log.Error("failed to decode object", "host", hostName, "page", pageName, "error", err)
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+	}
+	if transform {
+		obj := served
+		url := cacheKey(hostName, pageName)
+		transformed, err := srv.renders.Render(ctx, "transform", url, obj, func() (Object, error) {
+			return srv.transformer.Transform(hostName, url, obj)
+		})
+		if err != nil {
+			log.Error("failed to transform page", "url", url, "error", err)
+		} else {
+			served = transformed
+			transforms = srv.transformer.Describe(hostName)
+		}
+	}
+	if trace != nil {
+		writeTransformHeader(w.Header(), transforms)
+	}
+
+	CacheControlPolicy(srv.cfg.CacheControl).Apply(w.Header(), hostName, served.ContentType, srv.privateResponses())
+	w.Header().Set("Content-Type", served.ContentType)
+	w.Header().Set("ETag", representationEtag(served))
+	if served.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", served.ContentEncoding)
+	}
+	if stored.ContentEncoding != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if srv.storage.variants.Varies(cacheKey(hostName, pageName)) {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	srv.cors.Apply(w.Header(), r)
+	srv.headerRules.Apply(w.Header(), hostName, pageName, served.ContentType)
+	http.ServeContent(w, r, pageName, served.ModTime(), bytes.NewReader(served.Content))
+
+	// the links of a page served from the cache were scheduled when it was
+	// fetched
+	if srv.prefetcher != nil && source == SourceOrigin {
+		srv.prefetcher.Schedule(ctx, hostName, pageName, stored, 0)
+	}
+}
+
+// representationEtag returns the ETag of obj as sent. The etag of an object
+// is the hash of its decoded content, so its encoded body gets a validator
+// of its own: conditional and range requests must not mix the bytes of two
+// representations.
+func representationEtag(obj Object) string {
+	if obj.ContentEncoding == "" {
+		return obj.Etag
+	}
+	return obj.Etag + "-" + obj.ContentEncoding
+}
+
+// privateResponses reports whether the responses depend on credentials
+// sent with the requests: a login, a signed link or the API key of a
+// tenant. A shared cache must not keep them then.
+func (srv *Server) privateResponses() bool {
+	return srv.basicAuth != nil || srv.oidc != nil || srv.signer != nil || srv.storage.tenants.HasAPIKeys()
+}
+
+// validateAuth checks the combination of the authentication settings.
+func validateAuth(cfg Config) error {
+	if len(cfg.BasicAuth.Users) > 0 && cfg.OIDC.Issuer != "" {
+		return errors.New("basic auth and oidc can't be both enabled")
+	}
+	if cfg.OIDC.Issuer != "" && len(cfg.OIDC.Admin.Groups) == 0 && len(cfg.OIDC.Admin.Claims) == 0 {
+		return errors.New("oidc admin rules must require a group or claim")
+	}
+	return nil
+}
+
+// adminOnly guards the admin routes with the configured bearer token or an
+// OIDC token satisfying the admin rules. When neither is configured the
+// admin routes are disabled.
+func (srv *Server) adminOnly(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.isAdmin(r) {
+			log.Warn("admin access denied", "path", r.URL.Path, "client", ClientIP(r.Context()))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether r comes from a client the admin address rules
+// allow and carries the admin token or an admin OIDC token. The routes
+// under adminOnly have had the client checked already, the GraphQL purge
+// mutation hasn't.
+func (srv *Server) isAdmin(r *http.Request) bool {
+	if !srv.clients.adminAllowed(ClientIP(r.Context())) {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if srv.cfg.AdminToken != "" && ok &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(srv.cfg.AdminToken)) == 1 {
+		return true
+	}
+	return srv.oidc.IsAdmin(r)
+}
+
+// parseTargetURL splits the url query parameter into the host name, scheme
+// included, and the page name. An internationalized host is given in
+// Unicode, however the url spells it. URLs with control characters, which
+// are only there once percent-decoded, are refused: the cache uses a NUL to
+// key the language variants of a page.
+func parseTargetURL(url string) (hostName, pageName string, ok bool) {
+	if hasControl(url) {
+		return "", "", false
+	}
+	var prefix string
+
+	// schemes are case-insensitive
+	if scheme := strings.ToLower(url[:min(len(url), len("https://"))]); scheme == "https://" {
+		prefix = "https://"
+		// remove prefix from x
+		url = url[len(prefix):]
+	} else if strings.HasPrefix(scheme, "http://") {
+		prefix = "http://"
+		url = url[len(prefix):]
+	} else {
+		prefix = "httpd://"
+	}
+
+	// the fragment is for the client only
+	url, _, _ = strings.Cut(url, "#")
+	pathSegments := strings.SplitN(strings.TrimRight(url, "/"), "/", 2)
+	if len(pathSegments) != 2 {
+		return "", "", false
+	}
+
+	host, ok := displayHost(pathSegments[0])
+	if !ok {
+		return "", "", false
+	}
+	hostName = canonicalHost(prefix + host)
+	pageName = pathSegments[1]
+	return hostName, pageName, true
+}
+
+// hasControl reports whether s contains an ASCII or Unicode control
+// character.
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsControl)
+}
@@ -0,0 +1,142 @@
+package blogproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var signedRequests = metrics.Counter(
+	"blogproxy_signed_requests_total",
+	"Requests carrying a signed URL, by verification result.",
+	"result",
+)
+
+// URLSigner issues and checks HMAC signed links. A valid signed link is
+// served without authentication, but only for the route and url it was
+// signed for and only until it expires.
+type URLSigner struct {
+	secret []byte
+	ttl    time.Duration
+	clock  Clock
+	// required refuses unsigned requests on the routes no other
+	// authentication protects
+	required bool
+}
+
+// NewURLSigner returns nil when no secret is configured. open tells whether
+// the proxy routes are left without authentication.
+func NewURLSigner(cfg SignedURLConfig, open bool, clock Clock) *URLSigner {
+	if cfg.Secret == "" {
+		return nil
+	}
+	return &URLSigner{
+		secret:   []byte(cfg.Secret),
+		ttl:      time.Duration(cfg.DefaultTTL),
+		clock:    clock,
+		required: cfg.Required && open,
+	}
+}
+
+// Sign returns the signed link to path for target, valid until exp.
+func (s *URLSigner) Sign(path, target string, exp time.Time) string {
+	expiry := strconv.FormatInt(exp.Unix(), 10)
+	query := url.Values{
+		"url": {target},
+		"exp": {expiry},
+		"sig": {s.signature(path, target, expiry)},
+	}
+	return path + "?" + query.Encode()
+}
+
+func (s *URLSigner) signature(path, target, expiry string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(path + "\n" + target + "\n" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a signed request.
+func (s *URLSigner) verify(r *http.Request) bool {
+	query := r.URL.Query()
+	expiry := query.Get("exp")
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || s.clock.Now().Unix() > exp {
+		return false
+	}
+	want := s.signature(r.URL.Path, query.Get("url"), expiry)
+	return hmac.Equal([]byte(query.Get("sig")), []byte(want))
+}
+
+// Middleware sends requests with a valid signature straight to
+// unauthenticated, skipping the authentication in next. Requests with an
+// invalid or expired signature are refused.
+func (s *URLSigner) Middleware(unauthenticated http.Handler) Middleware {
+	return func(next http.Handler) http.Handler {
+		if s == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !r.URL.Query().Has("sig") {
+				if s.required {
+					signedRequests.Inc("missing")
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !s.verify(r) {
+				signedRequests.Inc("invalid")
+				log.Warn("invalid signed url", "client", ClientIP(r.Context()), "path", r.URL.Path, "url", r.URL.Query().Get("url"))
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			signedRequests.Inc("valid")
+			unauthenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+type signRequest struct {
+	URL string `json:"url"`
+	// Path is the route the link is for, "/" by default.
+	Path string `json:"path"`
+	// TTL overrides the configured lifetime of the link.
+	TTL Duration `json:"ttl"`
+}
+
+type signResponse struct {
+	SignedURL string    `json:"signed_url"`
+	Expires   time.Time `json:"expires"`
+}
+
+func (s *URLSigner) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+	ttl := time.Duration(req.TTL)
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+
+	exp := s.clock.Now().Add(ttl).Truncate(time.Second)
+	writeJSON(w, http.StatusOK, signResponse{
+		SignedURL: s.Sign(req.Path, req.URL, exp),
+		Expires:   exp,
+	})
+}
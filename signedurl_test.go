@@ -0,0 +1,94 @@
+package blogproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/other.html": {Body: essay},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.SignedURLs = SignedURLConfig{Secret: "hmac key", DefaultTTL: Duration(time.Hour), Required: true}
+	})
+
+	sign := func(body string) signResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/sign", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := proxy.Serve(req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /admin/sign = %d: %s", rec.Code, rec.Body)
+		}
+		var resp signResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	get := func(link string) int {
+		t.Helper()
+		return proxy.Serve(httptest.NewRequest(http.MethodGet, link, nil)).Code
+	}
+	// tamper returns link with the query parameter key set to value
+	tamper := func(link, key, value string) string {
+		t.Helper()
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set(key, value)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	signed := sign(`{"url": "` + origin.URL + `/essay.html"}`)
+	if want := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC); !signed.Expires.Equal(want) {
+		t.Errorf("link expires %v, want %v", signed.Expires, want)
+	}
+	if code := get(signed.SignedURL); code != http.StatusOK {
+		t.Errorf("GET of the signed link = %d, want 200", code)
+	}
+	if code := get("/?url=" + url.QueryEscape(origin.URL+"/essay.html")); code != http.StatusForbidden {
+		t.Errorf("GET without a signature = %d, want 403", code)
+	}
+
+	for name, link := range map[string]string{
+		"other url":       tamper(signed.SignedURL, "url", origin.URL+"/other.html"),
+		"later expiry":    tamper(signed.SignedURL, "exp", "4102444800"),
+		"other signature": tamper(signed.SignedURL, "sig", "AAAA"),
+		"other route":     strings.Replace(signed.SignedURL, "/?", "/read?", 1),
+	} {
+		if code := get(link); code != http.StatusForbidden {
+			t.Errorf("GET of the signed link with %s = %d, want 403", name, code)
+		}
+	}
+
+	proxy.clock.Advance(time.Hour)
+	if code := get(signed.SignedURL); code != http.StatusOK {
+		t.Errorf("GET of the signed link as it expires = %d, want 200", code)
+	}
+	proxy.clock.Advance(time.Second)
+	if code := get(signed.SignedURL); code != http.StatusForbidden {
+		t.Errorf("GET of the expired link = %d, want 403", code)
+	}
+
+	// the requested lifetime overrides the configured one
+	short := sign(`{"url": "` + origin.URL + `/essay.html", "ttl": "1m"}`)
+	if want := proxy.clock.Now().Add(time.Minute); !short.Expires.Equal(want) {
+		t.Errorf("link with a ttl of 1m expires %v, want %v", short.Expires, want)
+	}
+	proxy.clock.Advance(2 * time.Minute)
+	if code := get(short.SignedURL); code != http.StatusForbidden {
+		t.Errorf("GET of the expired short link = %d, want 403", code)
+	}
+}
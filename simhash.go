@@ -0,0 +1,146 @@
+package blogproxy
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// shingleSize is the number of consecutive words hashed together.
+	shingleSize = 3
+	// minFingerprintWords skips pages too short for a meaningful
+	// fingerprint, such as index pages or redirects.
+	minFingerprintWords      = 50
+	defaultDuplicateDistance = 3
+)
+
+// simhash computes the 64-bit SimHash of text over word shingles. Texts that
+// share most of their shingles end up a few bits apart.
+func simhash(text string) (uint64, bool) {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) < minFingerprintWords {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint, true
+}
+
+// Fingerprints remembers the SimHash of each page version by etag, so
+// repeated duplicate scans only hash new content.
+type Fingerprints struct {
+	mu     sync.Mutex
+	byEtag map[string]uint64
+}
+
+func NewFingerprints() *Fingerprints {
+	return &Fingerprints{byEtag: make(map[string]uint64)}
+}
+
+type fingerprinted struct {
+	url         string
+	hostName    string
+	fingerprint uint64
+}
+
+// scan fingerprints the cached HTML and text pages, forgetting the
+// fingerprints of versions no longer cached.
+func (f *Fingerprints) scan(entries []CacheEntry) []fingerprinted {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := make(map[string]uint64, len(entries))
+	var pages []fingerprinted
+	for _, entry := range entries {
+		fingerprint, ok := f.byEtag[entry.Object.Etag]
+		if !ok {
+			obj, err := entry.Object.Decoded()
+			if err != nil {
+				continue
+			}
+			if fingerprint, ok = simhash(readableText(obj)); !ok {
+				continue
+			}
+		}
+		seen[entry.Object.Etag] = fingerprint
+		pages = append(pages, fingerprinted{
+			url:         cacheKey(entry.HostName, entry.PageName),
+			hostName:    entry.HostName,
+			fingerprint: fingerprint,
+		})
+	}
+	f.byEtag = seen
+	return pages
+}
+
+type DuplicatePair struct {
+	A        string `json:"a"`
+	B        string `json:"b"`
+	Distance int    `json:"distance"`
+}
+
+// Duplicates returns the pairs of cached pages whose fingerprints are at
+// most distance bits apart. Unless sameHost is set only pairs across
+// different hosts are reported, which is what syndicated reposts look like.
+func (f *Fingerprints) Duplicates(entries []CacheEntry, distance int, sameHost bool) []DuplicatePair {
+	pages := f.scan(entries)
+	sort.Slice(pages, func(i, j int) bool { return pages[i].url < pages[j].url })
+
+	pairs := []DuplicatePair{}
+	for i := range pages {
+		for j := i + 1; j < len(pages); j++ {
+			if !sameHost && pages[i].hostName == pages[j].hostName {
+				continue
+			}
+			d := bits.OnesCount64(pages[i].fingerprint ^ pages[j].fingerprint)
+			if d <= distance {
+				pairs = append(pairs, DuplicatePair{A: pages[i].url, B: pages[j].url, Distance: d})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Distance < pairs[j].Distance })
+	return pairs
+}
+
+// handleDuplicates lists near-duplicate cached pages. The distance
+// parameter sets the largest number of differing fingerprint bits, and
+// same_host=true also compares pages of the same host.
+func (srv *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	distance := defaultDuplicateDistance
+	if v := query.Get("distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 64 {
+			http.Error(w, "invalid distance", http.StatusBadRequest)
+			return
+		}
+		distance = d
+	}
+	sameHost := query.Get("same_host") == "true"
+
+	writeJSON(w, http.StatusOK, srv.fingerprints.Duplicates(srv.storage.ListAll(), distance, sameHost))
+}
@@ -0,0 +1,219 @@
+package blogproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sloWindows are the windows burn rates are computed over, the usual pairs
+// of multiwindow burn rate alerts: 1h and 5m, 6h and 30m, 1d and 2h.
+var sloWindows = []struct {
+	name    string
+	minutes int64
+}{
+	{"5m", 5},
+	{"30m", 30},
+	{"1h", 60},
+	{"2h", 120},
+	{"6h", 360},
+	{"1d", 1440},
+}
+
+// sloBuckets is the number of minutes of requests kept, the longest
+// window.
+const sloBuckets = 1440
+
+// healthWindow is the window of the health score, in minutes.
+const healthWindow = 5
+
+// sloBucket counts the requests of one minute.
+type sloBucket struct {
+	minute int64
+	total  uint64
+	errors uint64
+	slow   uint64
+	// bad counts the requests failed or slow
+	bad uint64
+}
+
+// SLO measures the proxied requests against the availability and latency
+// objectives. It exports, for each objective and window, the burn rate:
+// the fraction of bad requests over the fraction the objective allows, so
+// 1 spends the error budget exactly over the objective's period and 14.4
+// over an hour spends 2% of a 30 day budget. It also exports a health
+// score, the fraction of good requests over the last 5 minutes.
+type SLO struct {
+	availability     float64
+	latencyObjective float64
+	latency          time.Duration
+	clock            Clock
+
+	mu      sync.Mutex
+	buckets [sloBuckets]sloBucket
+}
+
+func NewSLO(cfg SLOConfig, clock Clock) (*SLO, error) {
+	for name, objective := range map[string]float64{"availability": cfg.Availability, "latency_objective": cfg.LatencyObjective} {
+		if objective < 0 || objective >= 1 {
+			return nil, fmt.Errorf("slo.%s must be at least 0 and below 1", name)
+		}
+	}
+	if cfg.LatencyObjective > 0 && cfg.Latency <= 0 {
+		return nil, fmt.Errorf("slo.latency must be positive")
+	}
+	s := &SLO{
+		availability:     cfg.Availability,
+		latencyObjective: cfg.LatencyObjective,
+		latency:          time.Duration(cfg.Latency),
+		clock:            clock,
+	}
+
+	metrics.GaugeVecFunc(
+		"blogproxy_slo_objective",
+		"Service level objectives of the proxied requests, by slo: availability or latency.",
+		func(set func(float64, ...string)) {
+			for slo, objective := range s.objectives() {
+				set(objective, slo)
+			}
+		},
+		"slo",
+	)
+	metrics.GaugeVecFunc(
+		"blogproxy_slo_burn_rate",
+		"Rate the error budget of an slo is spent at over a window; 1 spends it over the objective's period.",
+		func(set func(float64, ...string)) {
+			for _, window := range sloWindows {
+				total, errors, slow, _ := s.counts(window.minutes)
+				for slo, objective := range s.objectives() {
+					bad := errors
+					if slo == "latency" {
+						bad = slow
+					}
+					set(burnRate(total, bad, objective), slo, window.name)
+				}
+			}
+		},
+		"slo", "window",
+	)
+	metrics.GaugeFunc(
+		"blogproxy_health_score",
+		"Fraction of the proxied requests of the last 5 minutes answered without a server error and in time; 1 without requests.",
+		s.HealthScore,
+	)
+	return s, nil
+}
+
+func (s *SLO) objectives() map[string]float64 {
+	objectives := make(map[string]float64)
+	if s.availability > 0 {
+		objectives["availability"] = s.availability
+	}
+	if s.latencyObjective > 0 {
+		objectives["latency"] = s.latencyObjective
+	}
+	return objectives
+}
+
+func burnRate(total, bad uint64, objective float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - objective)
+}
+
+// Record counts a request answered with status after took.
+func (s *SLO) Record(status int, took time.Duration) {
+	minute := s.clock.Now().Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[minute%sloBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	failed, slow := status >= 500, s.latencyObjective > 0 && took > s.latency
+	if failed {
+		b.errors++
+	}
+	if slow {
+		b.slow++
+	}
+	if failed || slow {
+		b.bad++
+	}
+}
+
+// counts sums the requests of the last minutes, the current one included.
+func (s *SLO) counts(minutes int64) (total, errors, slow, bad uint64) {
+	now := s.clock.Now().Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.buckets {
+		if b.minute > now-minutes && b.minute <= now {
+			total += b.total
+			errors += b.errors
+			slow += b.slow
+			bad += b.bad
+		}
+	}
+	return total, errors, slow, bad
+}
+
+// HealthScore returns the fraction of good requests over the last 5
+// minutes, 1 without requests.
+func (s *SLO) HealthScore() float64 {
+	total, _, _, bad := s.counts(healthWindow)
+	if total == 0 {
+		return 1
+	}
+	return 1 - float64(bad)/float64(total)
+}
+
+// Middleware records the status and latency of the requests it serves.
+func (s *SLO) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			s.Record(sw.Status(), time.Since(start))
+		})
+	}
+}
+
+// statusWriter remembers the status of the response it writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status returns the status written, 200 when the handler wrote none.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
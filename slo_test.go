@@ -0,0 +1,110 @@
+package blogproxy
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSLO(t *testing.T) {
+	clock := newFakeClock()
+	slo, err := NewSLO(SLOConfig{Availability: 0.999, LatencyObjective: 0.99, Latency: Duration(time.Second)}, clock)
+	if err != nil {
+		t.Fatalf("NewSLO: %v", err)
+	}
+
+	// an hour ago: 1000 requests, 10 failed
+	for i := 0; i < 1000; i++ {
+		status := 200
+		if i < 10 {
+			status = 502
+		}
+		slo.Record(status, 10*time.Millisecond)
+	}
+	clock.Advance(time.Hour)
+	// now: 100 requests, 5 slow, 1 of them failed, and another failed
+	slo.Record(500, 10*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		status, took := 200, 10*time.Millisecond
+		if i < 5 {
+			took = 2 * time.Second
+		}
+		if i == 0 {
+			status = 503
+		}
+		slo.Record(status, took)
+	}
+
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	want := map[string]float64{
+		`blogproxy_slo_burn_rate{slo="availability",window="5m"}`: 2.0 / 101 / 0.001,
+		`blogproxy_slo_burn_rate{slo="latency",window="5m"}`:      5.0 / 101 / 0.01,
+		`blogproxy_slo_burn_rate{slo="availability",window="2h"}`: 12.0 / 1101 / 0.001,
+		`blogproxy_slo_objective{slo="latency"}`:                  0.99,
+		`blogproxy_health_score`:                                  1 - 6.0/101,
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		series, value, _ := strings.Cut(line, " ")
+		if w, ok := want[series]; ok {
+			if got, err := strconv.ParseFloat(value, 64); err != nil || math.Abs(got-w) > 1e-9 {
+				t.Errorf("%s = %v, want %v", series, got, w)
+			}
+			delete(want, series)
+		}
+	}
+	for series := range want {
+		t.Errorf("/metrics lacks %s", series)
+	}
+
+	if _, err := NewSLO(SLOConfig{Availability: 1}, clock); err == nil {
+		t.Error("NewSLO accepted an availability objective of 1")
+	}
+}
+
+func TestSLOCountsOriginFailures(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/down.html":  {Status: http.StatusServiceUnavailable},
+	})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		cfg.SLO = SLOConfig{Availability: 0.9}
+	})
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		// the origin not having a page is no failure of the proxy
+		{Path: "/gone.html", WantStatus: http.StatusNotFound, WantOriginRequests: 1},
+		{Path: "/down.html", WantStatus: http.StatusBadGateway, WantOriginRequests: 1},
+	})
+	// and neither is it when the origin can't be reached at all
+	origin.Close()
+	if rec := proxy.Get("/other.html", nil); rec.Code != http.StatusBadGateway {
+		t.Errorf("GET /other.html of an origin down = %d, want 502", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := proxy.Serve(req)
+	want := map[string]float64{
+		`blogproxy_slo_burn_rate{slo="availability",window="5m"}`: 2.0 / 4 / 0.1,
+		`blogproxy_health_score`:                                  1 - 2.0/4,
+	}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		series, value, _ := strings.Cut(line, " ")
+		if w, ok := want[series]; ok {
+			if got, err := strconv.ParseFloat(value, 64); err != nil || math.Abs(got-w) > 1e-9 {
+				t.Errorf("%s = %v, want %v", series, got, w)
+			}
+			delete(want, series)
+		}
+	}
+	for series := range want {
+		t.Errorf("/metrics lacks %s", series)
+	}
+}
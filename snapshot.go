@@ -0,0 +1,171 @@
+package blogproxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// snapshotIndexEntry describes one object of a snapshot in its index.json.
+type snapshotIndexEntry struct {
+	URL    string    `json:"url"`
+	Etag   string    `json:"etag"`
+	Size   int       `json:"size"`
+	Expiry time.Time `json:"expiry"`
+	File   string    `json:"file"`
+}
+
+// Snapshot writes every cached object, expired ones included, to w as a
+// gzipped tarball: an index.json listing the objects and one gob file per
+// object, in the format of the disk cache.
+func (s *Storage) Snapshot(w io.Writer) (int, error) {
+	return writeSnapshot(w, s.cacheVersion, s.cache.List())
+}
+
+// writeSnapshot writes entries, cached under version, to w in the format
+// of Snapshot.
+func writeSnapshot(w io.Writer, version string, entries []CacheEntry) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	index := make([]snapshotIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(diskRecord{HostName: entry.HostName, PageName: entry.PageName, Object: entry.Object, Version: version}); err != nil {
+			return 0, fmt.Errorf("failed to encode %s: %w", cacheKey(entry.HostName, entry.PageName), err)
+		}
+		sum := sha256.Sum256([]byte(cacheKey(entry.HostName, entry.PageName)))
+		file := path.Join("objects", hex.EncodeToString(sum[:])+".obj")
+		if err := writeTarFile(tw, file, buf.Bytes()); err != nil {
+			return 0, err
+		}
+		index = append(index, snapshotIndexEntry{
+			URL:    cacheKey(entry.HostName, entry.PageName),
+			Etag:   entry.Object.Etag,
+			Size:   len(entry.Object.Content),
+			Expiry: entry.Object.ExpiryTime,
+			File:   file,
+		})
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := writeTarFile(tw, "index.json", data); err != nil {
+		return 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return len(index), gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Restore loads the objects of a snapshot made by Snapshot into the cache,
+// replacing the cached copies of the same pages. Objects are restored as
+// they were, expiry included, and skip the admission policy. Objects of
+// another cache version are skipped. The pages cached per language are
+// marked as varying on it again, so requests find their variants before the
+// origin is next asked.
+func (s *Storage) Restore(r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var restored, stale int
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			if stale > 0 {
+				log.Info("skipped snapshot objects of another cache version", "objects", stale, "version", s.cacheVersion)
+			}
+			return restored, nil
+		}
+		if err != nil {
+			return restored, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || path.Dir(header.Name) != "objects" {
+			continue
+		}
+
+		var record diskRecord
+		if err := gob.NewDecoder(tr).Decode(&record); err != nil {
+			return restored, fmt.Errorf("failed to decode %s: %w", header.Name, err)
+		}
+		if record.Version != s.cacheVersion {
+			stale++
+			continue
+		}
+		if err := record.Object.Verify(); err != nil {
+			cacheCorrupt.Inc()
+			log.Error("skipping corrupt snapshot object", "host", record.HostName, "object", record.PageName, "error", err)
+			continue
+		}
+		s.cache.Put(record.HostName, record.PageName, record.Object)
+		if pageName, _, ok := strings.Cut(record.PageName, variantSeparator); ok {
+			_, hostName := splitNamespace(record.HostName)
+			s.variants.Mark(cacheKey(hostName, pageName))
+		}
+		restored++
+	}
+}
+
+func (srv *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := fmt.Sprintf("blog-proxy-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	// the status line is gone once the first object is written, a failure
+	// past that point can only cut the download short
+	n, err := srv.storage.Snapshot(w)
+	if err != nil {
+		log.Error("failed to write snapshot", "error", err)
+		return
+	}
+	log.Info("wrote snapshot", "objects", n)
+}
+
+type restoreResponse struct {
+	Restored int `json:"restored"`
+}
+
+func (srv *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	n, err := srv.storage.Restore(r.Body)
+	if err != nil {
+		log.Error("failed to restore snapshot", "restored", n, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info("restored snapshot", "objects", n)
+	writeJSON(w, http.StatusOK, restoreResponse{Restored: n})
+}
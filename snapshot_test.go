@@ -0,0 +1,56 @@
+package blogproxy
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/hello.html": {
+			Body:      "<p>Hello.</p>",
+			Languages: map[string]string{"fr": "<p>Bonjour.</p>"},
+			Header:    http.Header{"Vary": {"Accept-Language"}},
+		},
+		"/other.html": {Body: essay},
+	})
+	versioned := func(cfg *Config) { cfg.Cache.Version = "v2" }
+	french := http.Header{"Accept-Language": {"fr"}}
+
+	old := newTestProxy(t, origin, versioned)
+	old.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/hello.html", WantStatus: http.StatusOK, WantOriginRequests: 1},
+		{Path: "/hello.html", Header: french, WantStatus: http.StatusOK, WantBody: "Bonjour", WantOriginRequests: 2},
+	})
+	var snapshot bytes.Buffer
+	if n, err := old.storage.Snapshot(&snapshot); err != nil || n != 3 {
+		t.Fatalf("Snapshot = %d, %v, want 3 objects", n, err)
+	}
+
+	next := newTestProxy(t, origin, versioned)
+	if n, err := next.storage.Restore(&snapshot); err != nil || n != 3 {
+		t.Fatalf("Restore = %d, %v, want 3 objects", n, err)
+	}
+	// an object of another cache version is skipped
+	namespace := "test|" + origin.URL
+	var stale bytes.Buffer
+	other := Object{ContentType: "text/html", Content: []byte("<p>Cached by v1.</p>"), ExpiryTime: next.clock.Now().Add(defaultTTL)}
+	if _, err := writeSnapshot(&stale, "v1", []CacheEntry{{HostName: namespace, PageName: "other.html", Object: other}}); err != nil {
+		t.Fatalf("writeSnapshot: %v", err)
+	}
+	if n, err := next.storage.Restore(&stale); err != nil || n != 0 {
+		t.Errorf("Restore of a v1 snapshot = %d, %v, want 0 objects", n, err)
+	}
+
+	// the restored pages, both language variants included, are served
+	// without asking the origin
+	next.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "great work", WantOriginRequests: 1},
+		{Path: "/hello.html", WantStatus: http.StatusOK, WantBody: "Hello.", WantOriginRequests: 2},
+		{Path: "/hello.html", Header: french, WantStatus: http.StatusOK, WantBody: "Bonjour", WantOriginRequests: 2},
+		{Path: "/other.html", WantStatus: http.StatusOK, WantBody: "great work", WantOriginRequests: 1},
+	})
+}
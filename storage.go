@@ -0,0 +1,850 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	errHostNotAllowed  error = &domainError{msg: "host not allowed", kind: ErrNotAllowed}
+	errBodyTooLarge    error = &domainError{msg: "origin body too large", kind: ErrTooLarge}
+	errEmptyHostName   error = &domainError{msg: "host name is empty", kind: ErrInvalidArgument}
+	errEmptyPageName   error = &domainError{msg: "page name is empty", kind: ErrInvalidArgument}
+	errInvalidPageName error = &domainError{msg: "page name has control characters", kind: ErrInvalidArgument}
+)
+
+var (
+	originFetches = metrics.Counter(
+		"blogproxy_origin_fetches_total",
+		"Fetches from origins, by host and result: ok, not_modified, error, status or rejected.",
+		"host", "result",
+	)
+	originLatency = metrics.Histogram(
+		"blogproxy_origin_fetch_duration_seconds",
+		"Time to fetch an object from its origin, body included.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		"host",
+	)
+	originSize = metrics.Histogram(
+		"blogproxy_origin_response_bytes",
+		"Size of the objects fetched from origins.",
+		[]float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20},
+		"host",
+	)
+)
+
+// StorageOption tailors the Storage built by NewStorage. Options override
+// the config whatever their order.
+type StorageOption func(*storageOptions)
+
+type storageOptions struct {
+	cfg          Config
+	allowedHosts []string
+	ttl          time.Duration
+	cache        Cache
+	client       *http.Client
+	maxBodyBytes *int64
+	clock        Clock
+}
+
+// WithConfig builds the Storage from cfg, as loaded from the config file
+// and the environment. Without it, the Storage has DefaultConfig.
+func WithConfig(cfg Config) StorageOption {
+	return func(o *storageOptions) { o.cfg = cfg }
+}
+
+// WithAllowedHosts replaces the origins proxied for the requests of no
+// tenant, the allowed_hosts of the config by default. Entries are origins
+// or bare hostnames.
+func WithAllowedHosts(hosts ...string) StorageOption {
+	// not nil, even without hosts, for NewStorage to tell it was given
+	return func(o *storageOptions) { o.allowedHosts = append([]string{}, hosts...) }
+}
+
+// WithTTL sets how long the pages fetched for the requests of no tenant
+// stay fresh, 24h by default.
+func WithTTL(ttl time.Duration) StorageOption {
+	return func(o *storageOptions) { o.ttl = ttl }
+}
+
+// WithCache stores the objects in cache instead of the memory and disk
+// tiers of the config. The memory tier also counts the hits of each page,
+// which are then reported as zero.
+func WithCache(cache Cache) StorageOption {
+	return func(o *storageOptions) { o.cache = cache }
+}
+
+// WithHTTPClient fetches from the origins with client instead of one built
+// from the fetch settings of the config. The body timeout still applies.
+func WithHTTPClient(client *http.Client) StorageOption {
+	return func(o *storageOptions) { o.client = client }
+}
+
+// WithMaxBodySize sets the largest origin body fetched, as
+// fetch.max_body_bytes does.
+func WithMaxBodySize(n int64) StorageOption {
+	return func(o *storageOptions) { o.maxBodyBytes = &n }
+}
+
+// withClock reads the time from clock, for tests.
+func withClock(clock Clock) StorageOption {
+	return func(o *storageOptions) { o.clock = clock }
+}
+
+func NewStorage(ctx context.Context, opts ...StorageOption) (*Storage, error) {
+	o := storageOptions{cfg: DefaultConfig(), ttl: defaultTTL, clock: systemClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cfg, clock := o.cfg, o.clock
+	if o.maxBodyBytes != nil {
+		cfg.Fetch.MaxBodyBytes = *o.maxBodyBytes
+	}
+	if err := checkDigestAlgorithm(cfg.Cache.Digest); err != nil {
+		return nil, err
+	}
+
+	if o.allowedHosts == nil {
+		o.allowedHosts = cfg.AllowedHosts
+	}
+	allowed, err := NewAllowlist(o.allowedHosts, cfg.AllowedSchemes)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := NewTenants(cfg.Tenants, &Tenant{allowed: allowed, ttl: o.ttl}, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := NewDenyRules(cfg.DenyPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarks, err := NewBookmarks(cfg.Cache.DiskDir, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := NewHistory(cfg.Cache.DiskDir, cfg.HistoryVersions, cfg.HistoryMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bandwidth, err := NewBandwidth(cfg.Bandwidth, cfg.Cache.DiskDir, clock)
+	if err != nil {
+		return nil, err
+	}
+	bandwidth.Start(ctx)
+
+	maintenance, err := NewMaintenance(cfg.Maintenance)
+	if err != nil {
+		return nil, err
+	}
+
+	var partials *Partials
+	if cfg.Fetch.KeepPartial {
+		partials = NewPartials()
+	}
+
+	client := o.client
+	if client == nil {
+		transport, err := newOriginTransport(cfg.Fetch)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{Transport: transport}
+	}
+
+	cache, memory := o.cache, (*MemoryCache)(nil)
+	if cache == nil {
+		if cache, memory, err = newTieredStorage(cfg); err != nil {
+			return nil, err
+		}
+	}
+	peers, err := NewPeerPool(cfg.Peers, cfg.Cache.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		tenants:       tenants,
+		deny:          deny,
+		contentTypes:  cfg.AllowedContentTypes,
+		cache:         cache,
+		memory:        memory,
+		compareRate:   cfg.CompareSampleRate,
+		admission:     NewAdmission(cfg.Admission, clock),
+		peers:         peers,
+		bookmarks:     bookmarks,
+		history:       history,
+		bandwidth:     bandwidth,
+		maintenance:   maintenance,
+		health:        NewOriginHealth(cfg.Fetch, clock),
+		workers:       NewWorkerPool(cfg.Fetch.BackgroundWorkers, cfg.Fetch.BackgroundQueue),
+		client:        client,
+		partials:      partials,
+		variants:      NewVariants(),
+		revalidations: newKeySet(),
+		corrupt:       newKeySet(),
+		digest:        cfg.Cache.Digest,
+		earlyRefresh:  cfg.Cache.EarlyRefresh,
+		events:        NewCacheEvents(),
+		clock:         clock,
+		cacheVersion:  cfg.Cache.Version,
+		bodyTimeout:   time.Duration(cfg.Fetch.BodyTimeout),
+		maxBodyBytes:  cfg.Fetch.MaxBodyBytes,
+		limiter: NewFetchLimiter(
+			cfg.Fetch.MaxInflight,
+			cfg.Fetch.MaxInflightPerHost,
+			time.Duration(cfg.Fetch.QueueTimeout),
+		),
+	}
+	if tiered, ok := cache.(*TieredCache); ok && tiered.disk != nil {
+		tiered.disk.onCorrupt = func(hostName, pageName string) {
+			s.corrupt.Start(cacheKey(hostName, pageName))
+		}
+	}
+	if memory != nil {
+		demote := memory.onEvict
+		memory.onEvict = func(hostName, pageName string, obj Object) {
+			s.publish(eventEviction, hostName, pageName, "")
+			if demote != nil {
+				demote(hostName, pageName, obj)
+			}
+		}
+	}
+	return s, nil
+}
+
+// Close persists the state kept in memory between saves. Call it once the
+// servers stopped taking requests.
+func (s *Storage) Close() error {
+	return s.bandwidth.Save()
+}
+
+// newTieredStorage builds the memory and disk tiers of cfg.
+func newTieredStorage(cfg Config) (*TieredCache, *MemoryCache, error) {
+	var disk *DiskCache
+	if cfg.Cache.DiskDir != "" {
+		var err error
+		disk, err = NewDiskCache(cfg.Cache.DiskDir, cfg.Cache.Version)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	memory := NewMemoryCache(cfg.Cache.MemoryMaxBytes, NewCacheQuotas(cfg.Cache, cfg.Tenants))
+	metrics.GaugeFunc(
+		"blogproxy_cache_memory_bytes",
+		"Content bytes held in the memory tier.",
+		func() float64 { return float64(memory.Size()) },
+	)
+	metrics.GaugeFunc(
+		"blogproxy_cache_memory_deduped_bytes",
+		"Content bytes the memory tier saves by storing identical bodies once.",
+		func() float64 { return float64(memory.DedupedSize()) },
+	)
+
+	return NewTieredCache(memory, disk), memory, nil
+}
+
+type Storage struct {
+	tenants      *Tenants
+	deny         DenyRules
+	contentTypes MediaTypes
+	cache        Cache
+	memory       *MemoryCache
+	limiter      *FetchLimiter
+	compareRate  float64
+	admission    *Admission
+	peers        *PeerPool
+	bookmarks    *Bookmarks
+	history      *History
+	bandwidth    *Bandwidth
+	maintenance  *Maintenance
+	health       *OriginHealth
+	workers      *WorkerPool
+	client       *http.Client
+	bodyTimeout  time.Duration
+	maxBodyBytes int64
+	// partials is nil unless aborted fetches are kept
+	partials *Partials
+	variants *Variants
+	// revalidations holds the keys of the objects being fetched again,
+	// soft-purged or refreshed early
+	revalidations *keySet
+	// cacheVersion is the cache.version the objects are stored under
+	cacheVersion string
+	// corrupt holds the keys of the objects just dropped from disk as
+	// corrupt, until the lookup that found them fetches them again
+	corrupt      *keySet
+	digest       string
+	earlyRefresh float64
+	events       *CacheEvents
+	clock        Clock
+}
+
+// ObjectSource tells where Storage.Lookup found an object.
+type ObjectSource string
+
+const (
+	// SourceCache is the cache, a stale copy or a bookmarked snapshot.
+	SourceCache ObjectSource = "cache"
+	// SourceOrigin is a fetch, from the origin or through a peer.
+	SourceOrigin ObjectSource = "origin"
+)
+
+// Get returns pageName of hostName for the tenant of ctx, from the cache or
+// else fetched, with its content decompressed.
+func (s *Storage) Get(ctx context.Context, hostName, pageName string) (Object, error) {
+	obj, _, err := s.Lookup(ctx, hostName, pageName)
+	if err != nil {
+		return Object{}, err
+	}
+	return obj.Decoded()
+}
+
+// Lookup is Get also telling where the object came from. The object is
+// returned as stored, its content possibly compressed.
+func (s *Storage) Lookup(ctx context.Context, hostName, pageName string) (obj Object, source ObjectSource, err error) {
+	hostName, err = s.checkPage(ctx, hostName, pageName)
+	if err != nil {
+		return Object{}, "", err
+	}
+
+	namespace := s.tenant(ctx).namespace(hostName)
+	stored := s.storedPage(ctx, hostName, pageName)
+	key := cacheKey(namespace, stored)
+	s.admission.Record(key)
+
+	cached, ok := s.cache.Get(namespace, stored)
+	if ok && cached.ExpiryTime.After(s.clock.Now()) {
+		log.Debug("cache hit", "host", hostName, "object", pageName)
+		s.decide(ctx, eventHit, namespace, stored, "")
+		if s.shouldCompare() {
+			s.workers.Submit("compare", func() { s.compareWithOrigin(ctx, hostName, pageName, cached) })
+		}
+		if s.refreshEarly(cached) {
+			s.refreshInBackground(withRevalidation(ctx, cached), hostName, pageName, namespace, stored)
+		}
+		return cached, SourceCache, nil
+	}
+	if ok && cached.SoftPurged {
+		if !s.revalidations.Start(key) {
+			log.Debug("serving soft-purged object while revalidating", "host", hostName, "object", pageName)
+			s.decide(ctx, eventStale, namespace, stored, "revalidating")
+			return cached, SourceCache, nil
+		}
+		defer s.revalidations.Done(key)
+	}
+
+	fetchCtx := ctx
+	if ok {
+		fetchCtx = withRevalidation(ctx, cached)
+	}
+	obj, err = s.fetchFromPeerOrOrigin(fetchCtx, hostName, pageName)
+	// the copy on disk was found corrupt and dropped by the lookup above
+	corrupt := s.corrupt.Take(key)
+	if err != nil {
+		if ok && errors.Is(err, errBudgetExceeded) {
+			log.Info("serving stale object over budget", "host", hostName, "object", pageName)
+			s.decide(ctx, eventStale, namespace, stored, "over budget")
+			return cached, SourceCache, nil
+		}
+		if ok && errors.Is(err, errMaintenance) {
+			log.Info("serving stale object in maintenance", "host", hostName, "object", pageName)
+			s.decide(ctx, eventStale, namespace, stored, "maintenance")
+			return cached, SourceCache, nil
+		}
+		if ok && cached.SoftPurged {
+			log.Info("serving soft-purged object, revalidation failed", "host", hostName, "object", pageName, "error", err)
+			s.decide(ctx, eventStale, namespace, stored, "revalidation failed")
+			return cached, SourceCache, nil
+		}
+		if pinned, ok := s.bookmarks.Pinned(s.tenant(ctx).Name, hostName, pageName); ok {
+			log.Info("serving bookmarked snapshot", "host", hostName, "object", pageName, "error", err)
+			s.decide(ctx, eventStale, namespace, stored, "bookmarked")
+			return pinned, SourceCache, nil
+		}
+		if corrupt {
+			return Object{}, "", fmt.Errorf("%w, fetching it again failed: %w", errCorruptObject, err)
+		}
+		return Object{}, "", err
+	}
+
+	if ok {
+		reason := "expired"
+		if cached.SoftPurged {
+			reason = "soft purged"
+		}
+		s.decide(ctx, eventRefresh, namespace, stored, reason)
+	} else {
+		s.decide(ctx, eventMiss, namespace, stored, "")
+	}
+	s.store(ctx, hostName, pageName, namespace, stored, obj)
+	return obj, SourceOrigin, nil
+}
+
+// checkPage returns the canonical spelling of hostName, or why the tenant
+// of ctx may not have pageName of it.
+func (s *Storage) checkPage(ctx context.Context, hostName, pageName string) (string, error) {
+	hostName = canonicalHost(hostName)
+	if hostName == "" {
+		return "", errEmptyHostName
+	}
+	if pageName == "" {
+		return "", errEmptyPageName
+	}
+	if hasControl(pageName) {
+		return "", errInvalidPageName
+	}
+
+	tenant := s.tenant(ctx)
+	if !tenant.allowed.Allows(hostName) {
+		log.Error("host not allowed", "host", hostName, "tenant", tenant.Name)
+		return "", errHostNotAllowed
+	}
+
+	if s.deny.Denied(hostName, pageName) {
+		log.Info("path denied", "host", hostName, "page", pageName)
+		return "", errPathDenied
+	}
+	return hostName, nil
+}
+
+// Prefetch caches pageName for the tenant of ctx ahead of any request for
+// it. It is no request itself: it counts no popularity, hit or cache event,
+// and fetches nothing when the page is cached, fresh or not, or not popular
+// enough to be admitted anyway. It reports whether it fetched the object.
+func (s *Storage) Prefetch(ctx context.Context, hostName, pageName string) (obj Object, fetched bool, err error) {
+	hostName, err = s.checkPage(ctx, hostName, pageName)
+	if err != nil {
+		return Object{}, false, err
+	}
+	namespace := s.tenant(ctx).namespace(hostName)
+	stored := s.storedPage(ctx, hostName, pageName)
+	if s.contains(namespace, stored) || !s.admission.Popular(cacheKey(namespace, stored)) {
+		return Object{}, false, nil
+	}
+
+	obj, err = s.fetchFromPeerOrOrigin(ctx, hostName, pageName)
+	if err != nil {
+		return Object{}, false, err
+	}
+	s.store(ctx, hostName, pageName, namespace, stored, obj)
+	return obj, true, nil
+}
+
+// Contains reports whether pageName is cached for the tenant of ctx, fresh
+// or not, without it counting as a lookup.
+func (s *Storage) Contains(ctx context.Context, hostName, pageName string) bool {
+	hostName = canonicalHost(hostName)
+	return s.contains(s.tenant(ctx).namespace(hostName), s.storedPage(ctx, hostName, pageName))
+}
+
+func (s *Storage) contains(namespace, stored string) bool {
+	if c, ok := s.cache.(cacheContainer); ok {
+		return c.Contains(namespace, stored)
+	}
+	_, ok := s.cache.Get(namespace, stored)
+	return ok
+}
+
+// store caches obj, just fetched for pageName, under stored, the name it
+// was looked up by, and records it in the history, if admitted.
+func (s *Storage) store(ctx context.Context, hostName, pageName, namespace, stored string, obj Object) {
+	if varied := s.storedPage(ctx, hostName, pageName); varied != stored {
+		// the origin just changed its mind about varying on the language; if
+		// it does now, the object was fetched without one and is its default
+		stored = varied
+		if s.variants.Varies(cacheKey(hostName, pageName)) {
+			stored = variantPage(pageName, "")
+		}
+	}
+	key := cacheKey(namespace, stored)
+	if ok, reason := s.admission.Admit(key, len(obj.Content)); !ok {
+		admissionRejected.Inc(reason)
+		log.Debug("object not admitted", "host", hostName, "object", pageName, "reason", reason)
+		return
+	}
+	s.history.Record(namespace, stored, obj)
+	s.cache.Put(namespace, stored, obj)
+}
+
+// storedPage returns the name pageName is cached under for ctx: the page
+// name itself, or the page name with the language of ctx for the pages
+// whose origin varies on the language.
+func (s *Storage) storedPage(ctx context.Context, hostName, pageName string) string {
+	if !s.variants.Varies(cacheKey(hostName, pageName)) {
+		return pageName
+	}
+	return variantPage(pageName, languageFrom(ctx))
+}
+
+// tenant returns the tenant ctx is for.
+func (s *Storage) tenant(ctx context.Context) *Tenant {
+	if tenant := tenantFrom(ctx); tenant != nil {
+		return tenant
+	}
+	return s.tenants.fallback
+}
+
+// fetchFromPeerOrOrigin asks the peer owning pageName for it, falling back
+// to the origin when this replica is the owner or the owner can't help.
+func (s *Storage) fetchFromPeerOrOrigin(ctx context.Context, hostName, pageName string) (Object, error) {
+	trace := traceFrom(ctx)
+	owner, remote := s.peers.RemoteOwner(cacheKey(s.tenant(ctx).namespace(hostName), pageName))
+	if remote && !isPeerRequest(ctx) {
+		start := time.Now()
+		obj, err := s.peers.Fetch(ctx, owner, hostName, pageName)
+		trace.fetched("peer "+owner, time.Since(start), err)
+		if err == nil {
+			peerFetches.Inc("hit")
+			log.Debug("peer hit", "peer", owner, "host", hostName, "object", pageName)
+			return obj, nil
+		}
+		peerFetches.Inc("error")
+		log.Warn("peer fetch failed", "peer", owner, "host", hostName, "object", pageName, "error", err)
+	}
+	start := time.Now()
+	obj, err := s.fetch(ctx, hostName, pageName)
+	trace.fetched("origin "+asciiHostName(hostName), time.Since(start), err)
+	return obj, err
+}
+
+// originURL returns the URL pageName of hostName is fetched from. The page
+// name is a path, escaped or not, possibly followed by a query; escapes in
+// it are kept as they are, so "a%2Fb" stays one segment, and a fragment,
+// which is for the client only, is dropped.
+func originURL(hostName, pageName string) string {
+	scheme, host, _ := strings.Cut(asciiHostName(hostName), "://")
+	pageName, _, _ = strings.Cut(pageName, "#")
+	path, query, _ := strings.Cut(pageName, "?")
+	u := url.URL{Scheme: scheme, Host: host, RawQuery: escapeInvalid(query)}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		u.Path = "/" + unescaped
+		// only used when it is a valid escaping of Path
+		u.RawPath = "/" + path
+	} else {
+		// a stray "%" is taken literally
+		u.Path = "/" + path
+	}
+	return u.String()
+}
+
+// escapeInvalid percent-encodes the bytes of s that can't appear in a URL
+// as they are, leaving its escapes alone.
+func escapeInvalid(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c >= 0x7f || strings.IndexByte(`"<>\^`+"`{|}", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// withRevalidation has the fetches of ctx ask the origin whether cached,
+// the copy of the page they replace, is still current rather than for the
+// page itself, when the origin gave cached a Last-Modified date.
+func withRevalidation(ctx context.Context, cached Object) context.Context {
+	return context.WithValue(ctx, revalidationKey, cached)
+}
+
+func revalidationFrom(ctx context.Context) (Object, bool) {
+	cached, ok := ctx.Value(revalidationKey).(Object)
+	return cached, ok && !cached.LastModified.IsZero()
+}
+
+// fetch gets pageName from the origin, without consulting or filling the
+// cache. With a copy to revalidate in ctx, it is the copy made fresh again
+// when the origin answers it didn't change.
+func (s *Storage) fetch(ctx context.Context, hostName, pageName string) (obj Object, err error) {
+	if s.maintenance.Enabled() {
+		return Object{}, errMaintenance
+	}
+	release, err := s.limiter.Acquire(ctx, hostName)
+	if err != nil {
+		log.Warn("fetch queue full", "host", hostName, "object", pageName)
+		return Object{}, err
+	}
+	defer release()
+
+	if err := s.bandwidth.Allow(hostName); err != nil {
+		log.Warn("fetch over bandwidth budget", "host", hostName, "object", pageName)
+		return Object{}, err
+	}
+	if err := s.health.Allow(hostName); err != nil {
+		log.Warn("origin circuit breaker open", "host", hostName, "object", pageName)
+		return Object{}, err
+	}
+	var took time.Duration
+	defer func() { s.health.Record(hostName, took, err) }()
+
+	// get object from web page
+	url := originURL(hostName, pageName)
+
+	caller := ctx
+	ctx, startBody, stopBody := bodyDeadline(ctx, s.bodyTimeout)
+	defer stopBody()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	req.Header.Set("Accept-Encoding", originAcceptEncoding)
+	key := cacheKey(hostName, pageName)
+	if s.variants.Varies(key) {
+		if lang := languageFrom(ctx); lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+	}
+	partialKey := cacheKey(hostName, s.storedPage(ctx, hostName, pageName))
+	var partial *partialObject
+	if s.partials != nil {
+		if partial, _ = s.partials.Take(partialKey); partial != nil {
+			partial.resumeRequest(req)
+		}
+	}
+	cached, revalidating := revalidationFrom(ctx)
+	// a resumed fetch already carries the validator of its partial body
+	revalidating = revalidating && partial == nil
+	if revalidating {
+		req.Header.Set("If-Modified-Since", cached.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		originFetches.Inc(hostName, "error")
+		log.Error("failed to get object", "url", url, "error", err)
+		return Object{}, fetchError(caller, ctx, err)
+	}
+
+	defer resp.Body.Close()
+	startBody()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		s.variants.Learn(key, resp.Header)
+	}
+	var prefix []byte
+	if partial != nil {
+		if partial.resumes(resp) {
+			partialFetches.Inc("resumed")
+			log.Debug("resuming partial object", "url", url, "have", len(partial.content))
+			prefix = partial.content
+			resp.StatusCode = http.StatusOK
+			resp.Header.Set("Content-Type", partial.contentType)
+			resp.Header.Set("Content-Encoding", partial.contentEncoding)
+		} else {
+			partialFetches.Inc("restarted")
+		}
+	}
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		took = time.Since(start)
+		originFetches.Inc(hostName, "not_modified")
+		log.Debug("object not modified", "url", url)
+		now := s.clock.Now()
+		cached.UpdateTime = now
+		cached.ExpiryTime = now.Add(s.tenant(ctx).ttl)
+		cached.FetchDuration = took
+		cached.SoftPurged = false
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		originFetches.Inc(hostName, "status")
+		log.Error("unexpected origin status", "url", url, "status", resp.StatusCode)
+		return Object{}, &UpstreamError{Status: resp.StatusCode}
+	}
+	if s.maxBodyBytes > 0 && resp.ContentLength+int64(len(prefix)) > s.maxBodyBytes {
+		originFetches.Inc(hostName, "rejected")
+		log.Error("origin body too large", "url", url, "size", resp.ContentLength+int64(len(prefix)))
+		return Object{}, errBodyTooLarge
+	}
+
+	body := io.Reader(resp.Body)
+	if s.maxBodyBytes > 0 {
+		// a byte more than allowed tells a body too large from one that fits
+		body = io.LimitReader(resp.Body, s.maxBodyBytes-int64(len(prefix))+1)
+	}
+	content, err := io.ReadAll(body)
+	s.bandwidth.Record(hostName, int64(len(content)))
+	content = append(prefix, content...)
+	if err != nil {
+		if s.partials != nil {
+			s.partials.Keep(partialKey, resp, content)
+		}
+		originFetches.Inc(hostName, "error")
+		log.Error("failed to read object", "url", url, "error", err)
+		return Object{}, fetchError(caller, ctx, err)
+	}
+	if s.maxBodyBytes > 0 && int64(len(content)) > s.maxBodyBytes {
+		originFetches.Inc(hostName, "rejected")
+		log.Error("origin body too large", "url", url)
+		return Object{}, errBodyTooLarge
+	}
+	took = time.Since(start)
+	originLatency.Observe(took.Seconds(), hostName)
+	originSize.Observe(float64(len(content)), hostName)
+
+	attrs := resp.Header
+
+	// the content is kept compressed, but checked and hashed decompressed
+	// so that its etag doesn't depend on the encoding
+	encoding := contentEncoding(attrs.Get("Content-Encoding"))
+	plain, err := decodeContent(encoding, content, s.maxBodyBytes)
+	if errors.Is(err, errBodyTooLarge) {
+		originFetches.Inc(hostName, "rejected")
+		log.Error("origin body too large once decoded", "url", url, "content_encoding", encoding)
+		return Object{}, err
+	}
+	if err != nil {
+		originFetches.Inc(hostName, "error")
+		log.Error("failed to decode object", "url", url, "content_encoding", encoding, "error", err)
+		return Object{}, &UpstreamError{Err: err}
+	}
+
+	contentType := attrs.Get("Content-Type")
+	if err := checkContentType(s.contentTypes, contentType, plain); err != nil {
+		originFetches.Inc(hostName, "rejected")
+		log.Error("content type not allowed", "url", url, "content_type", contentType)
+		return Object{}, err
+	}
+	originFetches.Inc(hostName, "ok")
+
+	// get md5 hash of content
+	hash := md5.New()
+	hash.Write(plain)
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	// a date in the future or unparsable is no better than none
+	lastModified, err := http.ParseTime(attrs.Get("Last-Modified"))
+	now := s.clock.Now()
+	if err != nil || lastModified.After(now) {
+		lastModified = time.Time{}
+	}
+	return Object{
+		Etag:            etag,
+		ContentType:     contentType,
+		Content:         content,
+		ContentEncoding: encoding,
+		UpdateTime:      now,
+		ExpiryTime:      now.Add(s.tenant(ctx).ttl),
+		LastModified:    lastModified,
+		Digest:          contentDigest(s.digest, content),
+		FetchDuration:   took,
+	}, nil
+}
+
+// Cached returns the cached copy of pageName for the tenant of ctx, without
+// fetching it.
+func (s *Storage) Cached(ctx context.Context, hostName, pageName string) (Object, bool) {
+	return s.cache.Get(s.tenant(ctx).namespace(hostName), s.storedPage(ctx, hostName, pageName))
+}
+
+// List returns the pages of hostName cached for the tenant of ctx, or of
+// all hosts when hostName is empty.
+func (s *Storage) List(ctx context.Context, hostName string) []CacheEntry {
+	tenant := s.tenant(ctx)
+	if hostName != "" {
+		hostName = canonicalHost(hostName)
+	}
+	var entries []CacheEntry
+	for _, entry := range s.cache.List() {
+		name, entryHost := splitNamespace(entry.HostName)
+		if name != tenant.Name || hostName != "" && entryHost != hostName {
+			continue
+		}
+		entry.HostName = entryHost
+		entry.PageName, _ = splitVariant(entry.PageName)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ListAll returns every cached page whatever the tenant it was fetched for.
+// A page cached by several tenants is returned once, in its newest copy.
+func (s *Storage) ListAll() []CacheEntry {
+	var entries []CacheEntry
+	index := make(map[string]int)
+	for _, entry := range s.cache.List() {
+		_, entry.HostName = splitNamespace(entry.HostName)
+		entry.PageName, _ = splitVariant(entry.PageName)
+		key := cacheKey(entry.HostName, entry.PageName)
+		if i, ok := index[key]; ok {
+			if entry.Object.UpdateTime.After(entries[i].Object.UpdateTime) {
+				entries[i] = entry
+			}
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Hits returns the cache hits of pageName for the tenant of ctx since it
+// was last fetched.
+func (s *Storage) Hits(ctx context.Context, hostName, pageName string) int64 {
+	if s.memory == nil {
+		return 0
+	}
+	return s.memory.Hits(s.tenant(ctx).namespace(hostName), s.storedPage(ctx, hostName, pageName))
+}
+
+// Versions returns the recorded versions of pageName for the tenant of ctx.
+func (s *Storage) Versions(ctx context.Context, hostName, pageName string) []Object {
+	return s.history.Versions(s.tenant(ctx).namespace(hostName), s.storedPage(ctx, hostName, pageName))
+}
+
+// Version returns the version of pageName with etag for the tenant of ctx,
+// with its content decompressed.
+func (s *Storage) Version(ctx context.Context, hostName, pageName, etag string) (Object, bool) {
+	obj, ok := s.history.Version(s.tenant(ctx).namespace(hostName), s.storedPage(ctx, hostName, pageName), etag)
+	if !ok {
+		return Object{}, false
+	}
+	obj, err := obj.Decoded()
+	if err != nil {
+		log.Error("failed to decode version", "host", hostName, "object", pageName, "etag", etag, "error", err)
+		return Object{}, false
+	}
+	return obj, true
+}
+
+type Stats struct {
+	Objects      int64           `json:"objects"`
+	ContentBytes int64           `json:"content_bytes"`
+	MemoryBytes  int64           `json:"memory_bytes"`
+	MemoryHits   int64           `json:"memory_hits"`
+	DiskHits     int64           `json:"disk_hits"`
+	Misses       int64           `json:"misses"`
+	Bandwidth    []HostBandwidth `json:"bandwidth"`
+}
+
+func (s *Storage) Stats() Stats {
+	stats := Stats{
+		MemoryHits: int64(cacheHits.Value("memory")),
+		DiskHits:   int64(cacheHits.Value("disk")),
+		Misses:     int64(cacheMisses.Value()),
+		Bandwidth:  s.bandwidth.Usage(),
+	}
+	if s.memory != nil {
+		stats.MemoryBytes = s.memory.Size()
+	}
+	for _, entry := range s.cache.List() {
+		stats.Objects++
+		stats.ContentBytes += int64(len(entry.Object.Content))
+	}
+	return stats
+}
@@ -0,0 +1,102 @@
+package blogproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestStorageOptions(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{
+		"/essay.html": {Body: essay},
+		"/large.html": {Body: strings.Repeat("x", 2048)},
+	})
+	var fetched int
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		fetched++
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	cache := NewMemoryCache(0, nil)
+
+	ctx := context.Background()
+	storage, err := NewStorage(ctx,
+		WithMaxBodySize(1024),
+		WithConfig(DefaultConfig()),
+		WithAllowedHosts(origin.URL),
+		WithTTL(time.Hour),
+		WithCache(cache),
+		WithHTTPClient(client),
+	)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	obj, err := storage.Get(ctx, origin.URL, "essay.html")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(obj.Content) != essay || obj.ExpiryTime.Sub(obj.UpdateTime) != time.Hour {
+		t.Errorf("Get = %q expiring after %v, want the essay expiring after 1h", obj.Content, obj.ExpiryTime.Sub(obj.UpdateTime))
+	}
+	if fetched != 1 {
+		t.Errorf("the client made %d requests, want 1", fetched)
+	}
+	if _, ok := cache.Get(origin.URL, "essay.html"); !ok {
+		t.Error("the page isn't in the given cache")
+	}
+	if _, err := storage.Get(ctx, origin.URL, "large.html"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Get of a body over the max size = %v, want ErrTooLarge", err)
+	}
+	if _, err := storage.Get(ctx, "https://paulgraham.com", "greatwork.html"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Get of a default host replaced by the allowed hosts = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestStorageAllowedHostsConfig(t *testing.T) {
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay}})
+	port := origin.URL[strings.LastIndex(origin.URL, ":")+1:]
+	cfg := DefaultConfig()
+	cfg.AllowedHosts = []string{"localhost:" + port}
+	cfg.AllowedSchemes = []string{"http"}
+
+	ctx := context.Background()
+	storage, err := NewStorage(ctx, WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	if _, err := storage.Get(ctx, "http://LocalHost:"+port, "essay.html"); err != nil {
+		t.Errorf("Get of a bare allowed hostname: %v", err)
+	}
+	if _, err := storage.Get(ctx, "https://paulgraham.com", "greatwork.html"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Get of the default host replaced by allowed_hosts = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestOriginURL(t *testing.T) {
+	tests := []struct {
+		hostName, pageName, want string
+	}{
+		{"https://paulgraham.com", "greatwork.html", "https://paulgraham.com/greatwork.html"},
+		{"https://paulgraham.com", "my essay.html", "https://paulgraham.com/my%20essay.html"},
+		{"https://paulgraham.com", "my%20essay.html", "https://paulgraham.com/my%20essay.html"},
+		{"https://paulgraham.com", "a%2Fb.html", "https://paulgraham.com/a%2Fb.html"},
+		{"https://paulgraham.com", "café.html", "https://paulgraham.com/caf%C3%A9.html"},
+		{"https://paulgraham.com", "100%.html", "https://paulgraham.com/100%25.html"},
+		{"https://paulgraham.com", "essay.html#notes", "https://paulgraham.com/essay.html"},
+		{"https://paulgraham.com", "search?q=great work&page=2", "https://paulgraham.com/search?q=great%20work&page=2"},
+		{"https://bücher.example", "essays/übersicht.html", "https://xn--bcher-kva.example/essays/%C3%BCbersicht.html"},
+	}
+	for _, tt := range tests {
+		if got := originURL(tt.hostName, tt.pageName); got != tt.want {
+			t.Errorf("originURL(%q, %q) = %q, want %q", tt.hostName, tt.pageName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	log "log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTTL is how long fetched objects stay fresh unless the tenant sets
+// its own TTL.
+const defaultTTL = 24 * time.Hour
+
+var tenantRateLimited = metrics.Counter(
+	"blogproxy_tenant_rate_limited_total",
+	"Requests refused by the tenant rate limits.",
+	"tenant",
+)
+
+var validTenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Tenant is a user of a shared deployment. Each tenant has its own
+// allowlist and TTL, and its own namespace in the cache and the version
+// history, so no tenant is ever served what was fetched for another.
+type Tenant struct {
+	// Name is empty for the default tenant, which serves the requests that
+	// match no configured tenant.
+	Name    string
+	allowed Allowlist
+	ttl     time.Duration
+	limiter *rateLimiter
+}
+
+// namespace returns the host name hostName is stored under for t.
+func (t *Tenant) namespace(hostName string) string {
+	if t.Name == "" {
+		return hostName
+	}
+	return t.Name + "|" + hostName
+}
+
+// splitNamespace undoes Tenant.namespace.
+func splitNamespace(namespaced string) (tenant, hostName string) {
+	if tenant, hostName, ok := strings.Cut(namespaced, "|"); ok {
+		return tenant, hostName
+	}
+	return "", namespaced
+}
+
+// Tenants resolves requests to the configured tenants.
+type Tenants struct {
+	byName   map[string]*Tenant
+	byKey    map[[sha256.Size]byte]*Tenant
+	byHost   map[string]*Tenant
+	fallback *Tenant
+}
+
+// NewTenants builds the tenants of cfgs. Requests matching none of them are
+// served as fallback.
+func NewTenants(cfgs []TenantConfig, fallback *Tenant, clock Clock) (*Tenants, error) {
+	t := &Tenants{
+		byName:   map[string]*Tenant{"": fallback},
+		byKey:    make(map[[sha256.Size]byte]*Tenant),
+		byHost:   make(map[string]*Tenant),
+		fallback: fallback,
+	}
+	for _, cfg := range cfgs {
+		if !validTenantName.MatchString(cfg.Name) {
+			return nil, fmt.Errorf("invalid tenant name %q", cfg.Name)
+		}
+		if _, ok := t.byName[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", cfg.Name)
+		}
+
+		allowed, err := NewAllowlist(cfg.AllowedHosts, cfg.AllowedSchemes)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", cfg.Name, err)
+		}
+		tenant := &Tenant{
+			Name:    cfg.Name,
+			allowed: allowed,
+			ttl:     time.Duration(cfg.TTL),
+		}
+		if tenant.ttl <= 0 {
+			tenant.ttl = defaultTTL
+		}
+		if cfg.RateLimit > 0 {
+			tenant.limiter = newRateLimiter(cfg.RateLimit, cfg.Burst, clock)
+		}
+		t.byName[cfg.Name] = tenant
+
+		for _, key := range cfg.APIKeys {
+			t.byKey[sha256.Sum256([]byte(key))] = tenant
+		}
+		for _, host := range cfg.Hostnames {
+			host = strings.ToLower(host)
+			if other, ok := t.byHost[host]; ok {
+				return nil, fmt.Errorf("hostname %q is used by tenants %q and %q", host, other.Name, cfg.Name)
+			}
+			t.byHost[host] = tenant
+		}
+	}
+	return t, nil
+}
+
+// HasAPIKeys reports whether any tenant is picked by an API key.
+func (t *Tenants) HasAPIKeys() bool {
+	return len(t.byKey) > 0
+}
+
+// ByName returns the tenant called name, the fallback for "".
+func (t *Tenants) ByName(name string) (*Tenant, bool) {
+	tenant, ok := t.byName[name]
+	return tenant, ok
+}
+
+// Origins returns the origins any tenant may proxy, sorted.
+func (t *Tenants) Origins() []string {
+	var origins []string
+	for _, tenant := range t.byName {
+		origins = append(origins, tenant.allowed.Origins()...)
+	}
+	slices.Sort(origins)
+	return slices.Compact(origins)
+}
+
+// Resolve picks the tenant of r by its X-API-Key header, or else by the
+// hostname it was sent to. ok is false for an unknown API key.
+func (t *Tenants) Resolve(r *http.Request) (tenant *Tenant, ok bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		// keys are looked up by digest so the lookup time doesn't depend on
+		// how much of a guessed key is right
+		tenant, ok := t.byKey[sha256.Sum256([]byte(key))]
+		return tenant, ok
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tenant, ok := t.byHost[strings.ToLower(host)]; ok {
+		return tenant, true
+	}
+	return t.fallback, true
+}
+
+// Middleware stores the tenant of the request in its context and enforces
+// the tenant's rate limit.
+func (t *Tenants) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, ok := t.Resolve(r)
+			if !ok {
+				log.Warn("unknown api key", "client", ClientIP(r.Context()), "path", r.URL.Path)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if tenant.limiter != nil && !tenant.limiter.Allow() {
+				tenantRateLimited.Inc(tenant.Name)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+func withTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// tenantFrom returns the tenant stored by Tenants.Middleware, nil if there
+// is none.
+func tenantFrom(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantKey).(*Tenant)
+	return tenant
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, clock Clock) *rateLimiter {
+	b := float64(max(burst, 1))
+	return &rateLimiter{rate: rate, burst: b, clock: clock, tokens: b, last: clock.Now()}
+}
+
+func (l *rateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,38 @@
+package blogproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(2, 3, clock)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow() {
+			t.Fatalf("request %d within the burst refused", i)
+		}
+	}
+	if limiter.Allow() {
+		t.Fatal("request over the burst allowed")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if !limiter.Allow() {
+		t.Fatal("request refused after a token was refilled")
+	}
+	if limiter.Allow() {
+		t.Fatal("request allowed before the next token")
+	}
+
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow() {
+			t.Fatalf("request %d refused after the bucket refilled", i)
+		}
+	}
+	if limiter.Allow() {
+		t.Fatal("bucket refilled over the burst")
+	}
+}
@@ -0,0 +1,98 @@
+package blogproxy
+
+var (
+	cacheHits = metrics.Counter(
+		"blogproxy_cache_hits_total",
+		"Cache lookups that found an object, by tier.",
+		"tier",
+	)
+	cacheMisses = metrics.Counter(
+		"blogproxy_cache_misses_total",
+		"Cache lookups that found no object in any tier.",
+	)
+	cachePromotions = metrics.Counter(
+		"blogproxy_cache_promotions_total",
+		"Objects copied from disk into memory after a disk hit.",
+	)
+	cacheDemotions = metrics.Counter(
+		"blogproxy_cache_demotions_total",
+		"Objects evicted from memory and spilled to disk.",
+	)
+)
+
+// TieredCache keeps hot objects in memory and spills the ones evicted from
+// memory to disk. A disk hit promotes the object back into memory.
+type TieredCache struct {
+	memory *MemoryCache
+	disk   *DiskCache
+}
+
+// NewTieredCache combines memory and disk. disk may be nil, in which case
+// objects evicted from memory are dropped.
+func NewTieredCache(memory *MemoryCache, disk *DiskCache) *TieredCache {
+	c := &TieredCache{memory: memory, disk: disk}
+	if disk != nil {
+		memory.onEvict = func(hostName, pageName string, obj Object) {
+			cacheDemotions.Inc()
+			disk.Put(hostName, pageName, obj)
+		}
+	}
+	return c
+}
+
+func (c *TieredCache) Get(hostName, pageName string) (Object, bool) {
+	if obj, ok := c.memory.Get(hostName, pageName); ok {
+		cacheHits.Inc("memory")
+		return obj, true
+	}
+	if c.disk != nil {
+		if obj, ok := c.disk.Get(hostName, pageName); ok {
+			cacheHits.Inc("disk")
+			cachePromotions.Inc()
+			c.memory.Put(hostName, pageName, obj)
+			return obj, true
+		}
+	}
+	cacheMisses.Inc()
+	return Object{}, false
+}
+
+// Contains reports whether a tier holds pageName, without promoting it or
+// counting a hit or a miss.
+func (c *TieredCache) Contains(hostName, pageName string) bool {
+	return c.memory.Contains(hostName, pageName) || c.disk != nil && c.disk.Contains(hostName, pageName)
+}
+
+func (c *TieredCache) Put(hostName, pageName string, obj Object) {
+	c.memory.Put(hostName, pageName, obj)
+	if c.disk != nil {
+		// whatever the disk holds for this page is now outdated
+		c.disk.Delete(hostName, pageName)
+	}
+}
+
+func (c *TieredCache) Delete(hostName, pageName string) bool {
+	deleted := c.memory.Delete(hostName, pageName)
+	if c.disk != nil && c.disk.Delete(hostName, pageName) {
+		deleted = true
+	}
+	return deleted
+}
+
+func (c *TieredCache) List() []CacheEntry {
+	entries := c.memory.List()
+	if c.disk == nil {
+		return entries
+	}
+
+	inMemory := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		inMemory[cacheKey(entry.HostName, entry.PageName)] = struct{}{}
+	}
+	for _, entry := range c.disk.List() {
+		if _, ok := inMemory[cacheKey(entry.HostName, entry.PageName)]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,318 @@
+package blogproxy
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// transformPage is what the HTML of inject rules can refer to.
+type transformPage struct {
+	// URL is the original address of the page.
+	URL     string
+	Fetched time.Time
+}
+
+// transformStep is one rule of the pipeline, changing doc in place.
+type transformStep interface {
+	apply(doc *html.Node, page transformPage) error
+	// String describes the step, as listed in the debug headers.
+	String() string
+}
+
+// defaultBannerHTML credits the original page when the banner config has
+// no HTML of its own.
+const defaultBannerHTML = `<div class="blog-proxy-banner" style="margin:0;padding:6px 12px;border-bottom:1px solid #ccc;background:#f6f6f6;color:#333;font:13px/1.4 sans-serif">` +
+	`Mirrored from <a href="{{.URL}}">{{.URL}}</a>{{if not .Fetched.IsZero}}, fetched {{.Fetched.UTC.Format "2 January 2006"}}{{end}}.</div>`
+
+// Transformer rewrites the HTML pages of hosts according to their rules
+// before they are served.
+type Transformer struct {
+	hosts map[string][]transformStep
+
+	// indexing is nil while neither a canonical link nor noindex is added.
+	indexing transformStep
+
+	// banner is nil while the banner is disabled, bannerHosts nil while it
+	// applies to every host.
+	banner      transformStep
+	bannerHosts map[string]bool
+}
+
+// NewTransformer compiles the transforms of cfg: the rules of every host,
+// the banner and the indexing hints.
+func NewTransformer(cfg Config) (*Transformer, error) {
+	t := &Transformer{hosts: make(map[string][]transformStep)}
+	for hostName, hostRules := range cfg.Transforms {
+		steps, err := compileTransformRules(hostRules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transforms of %s: %w", hostName, err)
+		}
+		t.hosts[canonicalHost(hostName)] = steps
+	}
+
+	if cfg.Indexing.Canonical || cfg.Indexing.NoIndex {
+		t.indexing = indexingStep{canonical: cfg.Indexing.Canonical, noindex: cfg.Indexing.NoIndex}
+	}
+
+	banner := cfg.Banner
+	if banner.Enabled {
+		bannerHTML := banner.HTML
+		if bannerHTML == "" {
+			bannerHTML = defaultBannerHTML
+		}
+		step, err := compileInjectRule(InjectRule{HTML: bannerHTML})
+		if err != nil {
+			return nil, fmt.Errorf("invalid banner: %w", err)
+		}
+		t.banner = bannerStep{step}
+		if len(banner.Hosts) > 0 {
+			t.bannerHosts = make(map[string]bool, len(banner.Hosts))
+			for _, hostName := range banner.Hosts {
+				t.bannerHosts[canonicalHost(hostName)] = true
+			}
+		}
+	}
+	return t, nil
+}
+
+func compileTransformRules(rules TransformRules) ([]transformStep, error) {
+	var steps []transformStep
+	for _, selector := range rules.Strip {
+		sel, err := cascadia.Compile(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+		steps = append(steps, stripStep{selector: sel, source: selector})
+	}
+	for _, rule := range rules.Inject {
+		step, err := compileInjectRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Has reports whether hostName has any rules.
+func (t *Transformer) Has(hostName string) bool {
+	return len(t.steps(hostName)) > 0
+}
+
+// Describe lists the rules of hostName in the order they apply.
+func (t *Transformer) Describe(hostName string) []string {
+	var names []string
+	for _, step := range t.steps(hostName) {
+		names = append(names, step.String())
+	}
+	return names
+}
+
+// steps returns the rules of hostName. The built-in steps come last so
+// strip rules can't remove what they add.
+func (t *Transformer) steps(hostName string) []transformStep {
+	steps := slices.Clip(t.hosts[hostName])
+	if t.indexing != nil {
+		steps = append(steps, t.indexing)
+	}
+	if t.banner != nil && (t.bannerHosts == nil || t.bannerHosts[hostName]) {
+		steps = append(steps, t.banner)
+	}
+	return steps
+}
+
+// Transform applies the rules of hostName to obj, an HTML page originally
+// at url.
+func (t *Transformer) Transform(hostName, url string, obj Object) (Object, error) {
+	doc, err := html.Parse(bytes.NewReader(obj.Content))
+	if err != nil {
+		return Object{}, err
+	}
+	page := transformPage{URL: url, Fetched: obj.UpdateTime}
+	for _, step := range t.steps(hostName) {
+		if err := step.apply(doc, page); err != nil {
+			return Object{}, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return Object{}, err
+	}
+	return Object{ContentType: obj.ContentType, Content: buf.Bytes()}, nil
+}
+
+// stripStep removes every element matching selector.
+type stripStep struct {
+	selector cascadia.Selector
+	source   string
+}
+
+func (s stripStep) String() string {
+	return "strip " + s.source
+}
+
+func (s stripStep) apply(doc *html.Node, page transformPage) error {
+	for _, node := range s.selector.MatchAll(doc) {
+		// a match inside an earlier match is already gone with it
+		if node.Parent != nil {
+			node.Parent.RemoveChild(node)
+		}
+	}
+	return nil
+}
+
+// injectStep inserts HTML relative to the first element matching selector.
+type injectStep struct {
+	selector cascadia.Selector
+	source   string
+	position string
+	tmpl     *template.Template
+}
+
+func (s injectStep) String() string {
+	return "inject " + s.position + " " + s.source
+}
+
+// bannerStep is the injectStep of the banner.
+type bannerStep struct {
+	injectStep
+}
+
+func (s bannerStep) String() string {
+	return "banner"
+}
+
+func compileInjectRule(rule InjectRule) (injectStep, error) {
+	selector := rule.Selector
+	if selector == "" {
+		selector = "body"
+	}
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return injectStep{}, fmt.Errorf("invalid selector %q: %w", selector, err)
+	}
+	position := rule.Position
+	switch position {
+	case "":
+		position = "prepend"
+	case "prepend", "append", "before", "after":
+	default:
+		return injectStep{}, fmt.Errorf("invalid inject position %q", position)
+	}
+	tmpl, err := template.New("inject").Parse(rule.HTML)
+	if err != nil {
+		return injectStep{}, fmt.Errorf("invalid inject html: %w", err)
+	}
+	return injectStep{selector: sel, source: selector, position: position, tmpl: tmpl}, nil
+}
+
+func (s injectStep) apply(doc *html.Node, page transformPage) error {
+	target := s.selector.MatchFirst(doc)
+	if target == nil {
+		return nil
+	}
+	parent := target
+	if s.position == "before" || s.position == "after" {
+		parent = target.Parent
+		if parent == nil {
+			return nil
+		}
+	}
+
+	var buf strings.Builder
+	if err := s.tmpl.Execute(&buf, page); err != nil {
+		return err
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(buf.String()), parent)
+	if err != nil {
+		return err
+	}
+
+	for i, node := range nodes {
+		switch s.position {
+		case "prepend":
+			// keep the fragment in order ahead of the existing children
+			var ref *html.Node
+			if i == 0 {
+				ref = target.FirstChild
+			} else {
+				ref = nodes[i-1].NextSibling
+			}
+			target.InsertBefore(node, ref)
+		case "append":
+			target.AppendChild(node)
+		case "before":
+			parent.InsertBefore(node, target)
+		case "after":
+			var ref *html.Node
+			if i == 0 {
+				ref = target.NextSibling
+			} else {
+				ref = nodes[i-1].NextSibling
+			}
+			parent.InsertBefore(node, ref)
+		}
+	}
+	return nil
+}
+
+var (
+	headSelector      = cascadia.MustCompile("head")
+	canonicalSelector = cascadia.MustCompile(`link[rel~="canonical" i]`)
+	robotsSelector    = cascadia.MustCompile(`meta[name="robots" i]`)
+)
+
+// indexingStep points search engines at the original page: it replaces
+// the canonical link of the page with one to the original URL and, with
+// noindex, asks them not to index the proxied copy.
+type indexingStep struct {
+	canonical bool
+	noindex   bool
+}
+
+func (s indexingStep) String() string {
+	var hints []string
+	if s.canonical {
+		hints = append(hints, "canonical")
+	}
+	if s.noindex {
+		hints = append(hints, "noindex")
+	}
+	return "indexing " + strings.Join(hints, " ")
+}
+
+func (s indexingStep) apply(doc *html.Node, page transformPage) error {
+	head := headSelector.MatchFirst(doc)
+	if head == nil {
+		return nil
+	}
+	if s.canonical {
+		for _, node := range canonicalSelector.MatchAll(doc) {
+			node.Parent.RemoveChild(node)
+		}
+		head.AppendChild(&html.Node{
+			Type: html.ElementNode,
+			Data: "link",
+			Attr: []html.Attribute{{Key: "rel", Val: "canonical"}, {Key: "href", Val: page.URL}},
+		})
+	}
+	if s.noindex {
+		for _, node := range robotsSelector.MatchAll(doc) {
+			node.Parent.RemoveChild(node)
+		}
+		head.AppendChild(&html.Node{
+			Type: html.ElementNode,
+			Data: "meta",
+			Attr: []html.Attribute{{Key: "name", Val: "robots"}, {Key: "content", Val: "noindex"}},
+		})
+	}
+	return nil
+}
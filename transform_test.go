@@ -0,0 +1,269 @@
+package blogproxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const transformTestPage = `<html><head><title>Essay</title></head><body>
+<nav id="top"><a href="/">Home</a></nav>
+<div class="ad banner">Buy now</div>
+<article>
+<p>First paragraph.</p>
+<div class="ad">Inline ad</div>
+<p data-sponsored="yes">Sponsored paragraph.</p>
+<aside><div class="ad">Nested ad</div></aside>
+</article>
+</body></html>`
+
+func transform(t *testing.T, rules TransformRules) string {
+	t.Helper()
+	transformer, err := NewTransformer(Config{Transforms: map[string]TransformRules{"https://example.com": rules}})
+	if err != nil {
+		t.Fatalf("NewTransformer: %v", err)
+	}
+	obj := Object{
+		ContentType: "text/html",
+		Content:     []byte(transformTestPage),
+		UpdateTime:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	out, err := transformer.Transform("https://example.com", "https://example.com/essay.html", obj)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	return string(out.Content)
+}
+
+func TestTransformStripSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		removed  []string
+		kept     []string
+	}{
+		{
+			name:     "element",
+			selector: "nav",
+			removed:  []string{"Home"},
+			kept:     []string{"Buy now", "First paragraph."},
+		},
+		{
+			name:     "id",
+			selector: "#top",
+			removed:  []string{"Home"},
+			kept:     []string{"Buy now"},
+		},
+		{
+			name:     "class matches every element carrying it",
+			selector: ".ad",
+			removed:  []string{"Buy now", "Inline ad", "Nested ad"},
+			kept:     []string{"First paragraph.", "Sponsored paragraph."},
+		},
+		{
+			name:     "compound class",
+			selector: ".ad.banner",
+			removed:  []string{"Buy now"},
+			kept:     []string{"Inline ad", "Nested ad"},
+		},
+		{
+			name:     "child combinator",
+			selector: "article > .ad",
+			removed:  []string{"Inline ad"},
+			kept:     []string{"Buy now", "Nested ad"},
+		},
+		{
+			name:     "descendant combinator",
+			selector: "article .ad",
+			removed:  []string{"Inline ad", "Nested ad"},
+			kept:     []string{"Buy now"},
+		},
+		{
+			name:     "attribute",
+			selector: "[data-sponsored=yes]",
+			removed:  []string{"Sponsored paragraph."},
+			kept:     []string{"First paragraph."},
+		},
+		{
+			name:     "selector list",
+			selector: "nav, aside",
+			removed:  []string{"Home", "Nested ad"},
+			kept:     []string{"Inline ad"},
+		},
+		{
+			name:     "no match",
+			selector: "footer",
+			kept:     []string{"Home", "Buy now", "Nested ad"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := transform(t, TransformRules{Strip: []string{tt.selector}})
+			for _, text := range tt.removed {
+				if strings.Contains(out, text) {
+					t.Errorf("%q still contains %q:\n%s", tt.selector, text, out)
+				}
+			}
+			for _, text := range tt.kept {
+				if !strings.Contains(out, text) {
+					t.Errorf("%q removed %q:\n%s", tt.selector, text, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformInject(t *testing.T) {
+	tests := []struct {
+		name string
+		rule InjectRule
+		want string
+	}{
+		{
+			name: "prepend to body by default",
+			rule: InjectRule{HTML: `<p id="x">Banner</p>`},
+			want: `<body><p id="x">Banner</p>`,
+		},
+		{
+			name: "append",
+			rule: InjectRule{Selector: "article", Position: "append", HTML: `<p>End</p>`},
+			want: `</aside>
+<p>End</p></article>`,
+		},
+		{
+			name: "before first match only",
+			rule: InjectRule{Selector: ".ad", Position: "before", HTML: `<hr/>`},
+			want: `</nav>
+<hr/><div class="ad banner">`,
+		},
+		{
+			name: "after keeps fragment order",
+			rule: InjectRule{Selector: "nav", Position: "after", HTML: `<i>1</i><i>2</i>`},
+			want: `</nav><i>1</i><i>2</i>`,
+		},
+		{
+			name: "template fields are escaped",
+			rule: InjectRule{HTML: `<a href="{{.URL}}">{{.Fetched.Format "2006-01-02"}}</a>`},
+			want: `<a href="https://example.com/essay.html">2024-03-01</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := transform(t, TransformRules{Inject: []InjectRule{tt.rule}})
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("output lacks %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestTransformInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules TransformRules
+	}{
+		{"bad strip selector", TransformRules{Strip: []string{"div["}}},
+		{"bad inject selector", TransformRules{Inject: []InjectRule{{Selector: ">>"}}}},
+		{"bad position", TransformRules{Inject: []InjectRule{{Position: "inside"}}}},
+		{"bad template", TransformRules{Inject: []InjectRule{{HTML: "{{.URL"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTransformer(Config{Transforms: map[string]TransformRules{"https://example.com": tt.rules}}); err == nil {
+				t.Error("NewTransformer accepted invalid rules")
+			}
+		})
+	}
+}
+
+func TestTransformerHas(t *testing.T) {
+	transformer, err := NewTransformer(Config{Transforms: map[string]TransformRules{
+		"https://example.com": {Strip: []string{"nav"}},
+		"https://empty.com":   {},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hostName, want := range map[string]bool{
+		"https://example.com": true,
+		"https://empty.com":   false,
+		"https://other.com":   false,
+	} {
+		if got := transformer.Has(hostName); got != want {
+			t.Errorf("Has(%q) = %v, want %v", hostName, got, want)
+		}
+	}
+}
+
+func TestTransformBanner(t *testing.T) {
+	transformer, err := NewTransformer(Config{
+		Transforms: map[string]TransformRules{"https://example.com": {Strip: []string{"div"}}},
+		Banner:     BannerConfig{Enabled: true, Hosts: []string{"https://example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transformer.Has("https://other.com") {
+		t.Error("banner applied to a host it is not configured for")
+	}
+
+	obj := Object{
+		ContentType: "text/html",
+		Content:     []byte(transformTestPage),
+		UpdateTime:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	out, err := transformer.Transform("https://example.com", "https://example.com/essay.html", obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<a href="https://example.com/essay.html">https://example.com/essay.html</a>, fetched 1 March 2024.</div>`
+	if !strings.Contains(string(out.Content), want) {
+		t.Errorf("output lacks banner %q:\n%s", want, out.Content)
+	}
+}
+
+func TestTransformIndexing(t *testing.T) {
+	transformer, err := NewTransformer(Config{Indexing: IndexingConfig{Canonical: true, NoIndex: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transformer.Has("https://example.com") {
+		t.Fatal("indexing hints not applied to every host")
+	}
+
+	obj := Object{
+		ContentType: "text/html",
+		Content: []byte(`<html><head><link rel="Canonical" href="https://example.com/old.html">` +
+			`<meta name="ROBOTS" content="index"></head><body></body></html>`),
+	}
+	out, err := transformer.Transform("https://example.com", "https://example.com/essay.html", obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<head><link rel="canonical" href="https://example.com/essay.html"/><meta name="robots" content="noindex"/></head>`
+	if !strings.Contains(string(out.Content), want) {
+		t.Errorf("output lacks %q:\n%s", want, out.Content)
+	}
+}
+
+func TestTransformBannerAfterRevalidation(t *testing.T) {
+	lastModified := http.Header{"Last-Modified": {"Mon, 01 Jan 2024 00:00:00 GMT"}}
+	origin := newFakeOrigin(t, map[string]originPage{"/essay.html": {Body: essay, Header: lastModified}})
+	proxy := newTestProxy(t, origin, func(cfg *Config) {
+		cfg.Banner = BannerConfig{Enabled: true, Hosts: []string{origin.URL}}
+	})
+	proxy.run(t, []proxyStep{
+		{Path: "/essay.html", WantStatus: http.StatusOK, WantBody: "fetched 1 January 2024", WantOriginRequests: 1},
+		// the page is still the same, but the banner tells it was checked
+		{
+			Advance:            48 * time.Hour,
+			Origin:             map[string]originPage{"/essay.html": {Status: http.StatusNotModified, Header: lastModified}},
+			Path:               "/essay.html",
+			WantStatus:         http.StatusOK,
+			WantBody:           "fetched 3 January 2024",
+			WantHeader:         map[string]string{"Last-Modified": "Mon, 01 Jan 2024 00:00:00 GMT"},
+			WantOriginRequests: 2,
+		},
+	})
+}
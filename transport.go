@@ -0,0 +1,181 @@
+package blogproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+)
+
+var errOriginTimeout error = &domainError{msg: "origin timed out", kind: ErrTimeout}
+
+// newOriginTransport builds the transport used for origin fetches, with each
+// phase of a request bounded on its own. Origins listed in cfg.Hosts get a
+// transport of their own with their settings applied.
+func newOriginTransport(cfg FetchConfig) (http.RoundTripper, error) {
+	fallback, err := newHostTransport(cfg, HostFetchConfig{})
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Hosts) == 0 {
+		return fallback, nil
+	}
+
+	hosts := make(hostTransports, len(cfg.Hosts)+1)
+	hosts[""] = fallback
+	for hostName, hostCfg := range cfg.Hosts {
+		transport, err := newHostTransport(cfg, hostCfg)
+		if err != nil {
+			return nil, fmt.Errorf("fetch.hosts %s: %w", hostName, err)
+		}
+		// requests carry internationalized hosts in punycode
+		hosts[asciiHostName(canonicalHost(hostName))] = transport
+	}
+	return hosts, nil
+}
+
+func newHostTransport(cfg FetchConfig, hostCfg HostFetchConfig) (*http.Transport, error) {
+	var network string
+	switch hostCfg.IPFamily {
+	case "":
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	default:
+		return nil, fmt.Errorf("unknown ip_family %q, want ipv4 or ipv6", hostCfg.IPFamily)
+	}
+	tlsConfig := &tls.Config{}
+	switch hostCfg.MinTLSVersion {
+	case "":
+	case "1.0":
+		tlsConfig.MinVersion = tls.VersionTLS10
+	case "1.1":
+		tlsConfig.MinVersion = tls.VersionTLS11
+	case "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unknown min_tls_version %q", hostCfg.MinTLSVersion)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(cfg.DialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	dial := dialer.DialContext
+	if network != "" {
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	maxIdle := max(cfg.MaxInflightPerHost, http.DefaultMaxIdleConnsPerHost)
+	if hostCfg.MaxIdleConns > 0 {
+		maxIdle = hostCfg.MaxIdleConns
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout),
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   maxIdle,
+		ForceAttemptHTTP2:     !hostCfg.DisableHTTP2,
+	}
+	if hostCfg.DisableHTTP2 {
+		// a non-nil empty map keeps the transport from upgrading to HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport, nil
+}
+
+var errAddressNotPublic = errors.New("address not public")
+
+// publicAddressesOnly is a net.Dialer Control refusing to connect to
+// loopback, private, link-local, multicast and unspecified addresses. It
+// runs once the host is resolved, so a public name resolving to an inner
+// address is refused too. It guards the connections to hosts found in
+// content, which unlike the origins are not on any allowlist.
+func publicAddressesOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%w: %s", errAddressNotPublic, addr)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// hostTransports routes each request to the transport of its origin, the
+// one keyed "" being for the origins without settings of their own.
+type hostTransports map[string]*http.Transport
+
+func (h hostTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := h[asciiHostName(canonicalHost(req.URL.Scheme+"://"+req.URL.Host))]; ok {
+		return transport.RoundTrip(req)
+	}
+	return h[""].RoundTrip(req)
+}
+
+// bodyDeadline cancels the returned context once timeout has passed since
+// start is called, bounding the body read that follows the headers. The
+// returned stop must be called when the body has been read.
+func bodyDeadline(ctx context.Context, timeout time.Duration) (bodyCtx context.Context, start, stop func()) {
+	bodyCtx, cancel := context.WithCancelCause(ctx)
+	var timer *time.Timer
+	start = func() {
+		if timeout > 0 {
+			timer = time.AfterFunc(timeout, func() { cancel(errOriginTimeout) })
+		}
+	}
+	stop = func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+	}
+	return bodyCtx, start, stop
+}
+
+// fetchError returns the error of a fetch that failed with err, ctx being
+// the context of the fetch and caller that of its caller: the caller's own
+// cancellation or deadline, errOriginTimeout for the fetch deadlines, or an
+// UpstreamError.
+func fetchError(caller, ctx context.Context, err error) error {
+	if callerErr := caller.Err(); callerErr != nil {
+		return &UpstreamError{Err: callerErr}
+	}
+	if isTimeout(ctx, err) {
+		return errOriginTimeout
+	}
+	return &UpstreamError{Err: err}
+}
+
+// isTimeout reports whether err is one of the fetch deadlines running out.
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(context.Cause(ctx), errOriginTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,55 @@
+package blogproxy
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginTransportHosts(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	origin.EnableHTTP2 = true
+	origin.StartTLS()
+	t.Cleanup(origin.Close)
+
+	cfg := DefaultConfig().Fetch
+	cfg.Hosts = map[string]HostFetchConfig{
+		origin.URL: {DisableHTTP2: true, MinTLSVersion: "1.2"},
+	}
+	transport, err := newOriginTransport(cfg)
+	if err != nil {
+		t.Fatalf("newOriginTransport: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(origin.Certificate())
+	for _, hostTransport := range transport.(hostTransports) {
+		hostTransport.TLSClientConfig.RootCAs = roots
+	}
+
+	proto := func(transport http.RoundTripper) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip: %v", err)
+		}
+		defer resp.Body.Close()
+		return resp.Proto
+	}
+	if got := proto(transport); got != "HTTP/1.1" {
+		t.Errorf("origin with http2 disabled spoke %s", got)
+	}
+	if got := proto(transport.(hostTransports)[""]); got != "HTTP/2.0" {
+		t.Errorf("default transport spoke %s, want HTTP/2.0", got)
+	}
+
+	for _, hostCfg := range []HostFetchConfig{{IPFamily: "ipv5"}, {MinTLSVersion: "2.0"}} {
+		cfg.Hosts = map[string]HostFetchConfig{"https://example.com": hostCfg}
+		if _, err := newOriginTransport(cfg); err == nil {
+			t.Errorf("newOriginTransport accepted %+v", hostCfg)
+		}
+	}
+}
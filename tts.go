@@ -0,0 +1,143 @@
+package blogproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// synthesisTimeout bounds a single text-to-speech rendering.
+const synthesisTimeout = 5 * time.Minute
+
+// maxAudioBytes bounds the audio read from a speech API.
+const maxAudioBytes = 32 << 20
+
+// Synthesizer turns text into speech.
+type Synthesizer interface {
+	// Synthesize returns the audio and its content type.
+	Synthesize(ctx context.Context, text string) (audio []byte, contentType string, err error)
+}
+
+// NewSynthesizer creates the configured text-to-speech backend. It returns
+// nil when text-to-speech is not configured.
+func NewSynthesizer(cfg TTSConfig) (Synthesizer, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "command":
+		if len(cfg.Command) == 0 {
+			return nil, errors.New("tts command is empty")
+		}
+		return &commandSynthesizer{command: cfg.Command, contentType: cfg.ContentType}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("tts url is empty")
+		}
+		return &httpSynthesizer{
+			url:         cfg.URL,
+			apiKey:      cfg.APIKey,
+			voice:       cfg.Voice,
+			contentType: cfg.ContentType,
+			client:      &http.Client{Timeout: synthesisTimeout},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown tts backend %q", cfg.Backend)
+}
+
+// commandSynthesizer runs a local program, such as espeak-ng or piper, that
+// reads text on stdin and writes audio to stdout.
+type commandSynthesizer struct {
+	command     []string
+	contentType string
+}
+
+func (c *commandSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	audio, err := cmd.Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("tts command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return audio, c.contentType, nil
+}
+
+// httpSynthesizer posts the text as JSON to a speech API and takes the
+// response body as the audio.
+type httpSynthesizer struct {
+	url         string
+	apiKey      string
+	voice       string
+	contentType string
+	client      *http.Client
+}
+
+func (h *httpSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	body, err := json.Marshal(map[string]string{"text": text, "voice": h.voice})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("tts request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("tts service responded with %s", resp.Status)
+	}
+
+	// a byte more than allowed tells audio too large from audio that fits
+	audio, err := io.ReadAll(io.LimitReader(resp.Body, maxAudioBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read tts response: %w", err)
+	}
+	if len(audio) > maxAudioBytes {
+		return nil, "", fmt.Errorf("tts response larger than %d bytes", maxAudioBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = h.contentType
+	}
+	return audio, contentType, nil
+}
+
+// handleAudio serves the article text of a page as speech.
+func (srv *Server) handleAudio(w http.ResponseWriter, r *http.Request) {
+	if srv.synthesizer == nil {
+		http.Error(w, "text-to-speech is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	srv.serveRendered(w, r, "audio", func(ctx context.Context, url string, obj Object) (Object, error) {
+		text := readableText(obj)
+		if text == "" {
+			return Object{}, errors.New("page has no readable text")
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, synthesisTimeout)
+		defer cancel()
+		audio, contentType, err := srv.synthesizer.Synthesize(ctx, text)
+		if err != nil {
+			return Object{}, err
+		}
+		return Object{ContentType: contentType, Content: audio}, nil
+	})
+}
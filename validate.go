@@ -0,0 +1,346 @@
+package blogproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validationReport collects the problems found in a config.
+type validationReport struct {
+	errors   []string
+	warnings []string
+}
+
+func (r *validationReport) errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *validationReport) warnf(format string, args ...any) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *validationReport) check(err error) {
+	if err != nil {
+		r.errorf("%v", err)
+	}
+}
+
+// RunValidateConfig implements the validate-config command: it parses the
+// config, reports its problems and returns the exit code, non-zero when
+// there are errors, or warnings with -strict.
+func RunValidateConfig(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "config file to validate")
+	strict := flags.Bool("strict", false, "fail on warnings, such as unknown or unused keys")
+	connect := flags.Bool("connect", false, "test-connect to origins, peers, the oidc issuer and the disk cache")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	report := &validationReport{}
+	if *path == "" {
+		report.warnf("no config file given, validating the defaults")
+	}
+	cfg, keys := parseConfigFile(*path, report)
+	if len(report.errors) == 0 {
+		validateConfig(cfg, keys, report)
+		if *connect {
+			testConnections(cfg, report)
+		}
+	}
+
+	for _, msg := range report.errors {
+		fmt.Fprintln(stdout, "error:", msg)
+	}
+	for _, msg := range report.warnings {
+		fmt.Fprintln(stdout, "warning:", msg)
+	}
+	if len(report.errors) > 0 || *strict && len(report.warnings) > 0 {
+		fmt.Fprintf(stdout, "config invalid: %d errors, %d warnings\n", len(report.errors), len(report.warnings))
+		return 1
+	}
+	fmt.Fprintln(stdout, "config ok")
+	return 0
+}
+
+// parseConfigFile loads the config at path like LoadConfig does, reporting
+// the keys that don't match any setting. keys holds the dotted paths of
+// every key set in the file.
+func parseConfigFile(path string, report *validationReport) (cfg Config, keys map[string]bool) {
+	keys = make(map[string]bool)
+	if path == "" {
+		return DefaultConfig(), keys
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		report.check(err)
+		return cfg, keys
+	}
+
+	data, _ := os.ReadFile(path)
+	var raw any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		report.check(err)
+		return cfg, keys
+	}
+	walkConfigKeys(raw, reflect.TypeOf(Config{}), "", keys, report)
+	return cfg, keys
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// walkConfigKeys records the keys of raw, the decoded JSON of a value of
+// type t, under prefix, and reports the ones t has no field for.
+func walkConfigKeys(raw any, t reflect.Type, prefix string, keys map[string]bool, report *validationReport) {
+	if reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[name] = field.Type
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := prefix + name
+			fieldType, ok := fields[name]
+			if !ok {
+				report.warnf("unknown key %s", key)
+				continue
+			}
+			keys[key] = true
+			walkConfigKeys(obj[name], fieldType, key+".", keys, report)
+		}
+	case reflect.Slice:
+		if list, ok := raw.([]any); ok {
+			for i, elem := range list {
+				walkConfigKeys(elem, t.Elem(), fmt.Sprintf("%s%d.", prefix, i), keys, report)
+			}
+		}
+	case reflect.Map:
+		if obj, ok := raw.(map[string]any); ok {
+			for name, elem := range obj {
+				walkConfigKeys(elem, t.Elem(), prefix+name+".", keys, report)
+			}
+		}
+	}
+}
+
+// validateConfig builds the parts of the proxy that parse their settings,
+// without starting anything, and lints settings that have no effect.
+func validateConfig(cfg Config, keys map[string]bool, report *validationReport) {
+	_, err := NewDenyRules(cfg.DenyPaths)
+	report.check(err)
+	_, err = ParseTrustedProxies(cfg.TrustedProxies, cfg.ForwardedHeader)
+	report.check(err)
+	_, err = NewClientFilter(cfg.ClientAccess)
+	report.check(err)
+	_, err = NewAllowlist(cfg.AllowedHosts, cfg.AllowedSchemes)
+	report.check(err)
+	_, err = NewTenants(cfg.Tenants, &Tenant{}, systemClock{})
+	report.check(err)
+	_, err = NewBasicAuth(cfg.BasicAuth, systemClock{})
+	report.check(err)
+	report.check(validateAuth(cfg))
+	_, err = NewMaintenance(cfg.Maintenance)
+	report.check(err)
+	_, err = NewSynthesizer(cfg.TTS)
+	report.check(err)
+	_, err = newOriginTransport(cfg.Fetch)
+	report.check(err)
+	_, err = NewCORS(cfg.CORS)
+	report.check(err)
+	_, err = NewHeaderRules(cfg.ResponseHeaders)
+	report.check(err)
+	_, err = NewTransformer(cfg)
+	report.check(err)
+	report.check(checkDigestAlgorithm(cfg.Cache.Digest))
+	_, err = NewSLO(cfg.SLO, systemClock{})
+	report.check(err)
+	if cfg.LinkCheck.Enabled {
+		_, err = NewLinkChecker(nil, cfg.LinkCheck, nil)
+		report.check(err)
+	}
+
+	if cfg.CompareSampleRate < 0 || cfg.CompareSampleRate > 1 {
+		report.errorf("compare_sample_rate must be between 0 and 1")
+	}
+	_, err = NewPeerPool(cfg.Peers, cfg.Cache.Version)
+	report.check(err)
+	for _, tenant := range cfg.Tenants {
+		if len(tenant.APIKeys) == 0 && len(tenant.Hostnames) == 0 {
+			report.warnf("tenant %q has neither api_keys nor hostnames and can't be reached", tenant.Name)
+		}
+		if len(tenant.AllowedHosts) == 0 {
+			report.warnf("tenant %q has no allowed_hosts", tenant.Name)
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	unused := func(prefix, reason string) {
+		for _, key := range sorted {
+			if strings.HasPrefix(key, prefix) {
+				report.warnf("%s has no effect: %s", key, reason)
+			}
+		}
+	}
+	if !cfg.Prefetch.Enabled {
+		unused("prefetch.max_depth", "prefetch is disabled")
+		unused("prefetch.concurrency", "prefetch is disabled")
+	}
+	if !cfg.LinkCheck.Enabled {
+		unused("link_check.interval", "link checking is disabled")
+		unused("link_check.timeout", "link checking is disabled")
+		unused("link_check.concurrency", "link checking is disabled")
+	}
+	if cfg.Fetch.BreakerFailures == 0 {
+		unused("fetch.breaker_cooldown", "the circuit breaker is off")
+	}
+	if cfg.Handoff.Socket == "" {
+		unused("handoff.timeout", "handoff.socket is empty")
+	}
+	if !cfg.Favicon.Enabled {
+		unused("favicon.default_host", "favicon passthrough is disabled")
+	}
+	if !cfg.Banner.Enabled {
+		unused("banner.hosts", "the banner is disabled")
+		unused("banner.html", "the banner is disabled")
+	}
+	if cfg.TTS.Backend != "command" {
+		unused("tts.command", "tts backend is not command")
+	}
+	if cfg.TTS.Backend != "http" {
+		unused("tts.url", "tts backend is not http")
+		unused("tts.api_key", "tts backend is not http")
+	}
+	if len(cfg.Peers.Peers) == 0 {
+		unused("peers.self", "no peers are configured")
+		unused("peers.secret", "no peers are configured")
+	}
+	if cfg.OIDC.Issuer == "" {
+		for _, key := range []string{"jwks_url", "audience", "groups_claim", "proxy", "admin"} {
+			unused("oidc."+key, "oidc.issuer is empty")
+		}
+	}
+	if len(cfg.BasicAuth.Users) == 0 {
+		for _, key := range []string{"realm", "max_failures", "lockout_window"} {
+			unused("basic_auth."+key, "no basic auth users are configured")
+		}
+	}
+	if token := cfg.Debug.Token; token != "" && len(token) < 16 {
+		report.warnf("debug.token is shorter than 16 characters and easy to guess")
+	}
+	if cfg.SignedURLs.Secret == "" {
+		unused("signed_urls.default_ttl", "signed_urls.secret is empty")
+		unused("signed_urls.required", "signed_urls.secret is empty")
+	}
+}
+
+// connectTimeout bounds every connection test.
+const connectTimeout = 10 * time.Second
+
+// testConnections checks that the backends the config points at can be
+// reached.
+func testConnections(cfg Config, report *validationReport) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	client := &http.Client{Timeout: connectTimeout}
+
+	var origins []string
+	addOrigins := func(hosts, schemes []string) {
+		for _, origin := range hosts {
+			if !strings.Contains(origin, "://") {
+				// a bare hostname is probed over its preferred scheme
+				scheme := "https"
+				if len(schemes) > 0 {
+					scheme = schemes[0]
+				}
+				origin = scheme + "://" + origin
+			}
+			origins = append(origins, origin)
+		}
+	}
+	addOrigins(cfg.AllowedHosts, cfg.AllowedSchemes)
+	for _, tenant := range cfg.Tenants {
+		addOrigins(tenant.AllowedHosts, tenant.AllowedSchemes)
+	}
+	slices.Sort(origins)
+	for _, origin := range slices.Compact(origins) {
+		probe(ctx, client, http.MethodHead, origin+"/", "origin "+origin, report)
+	}
+	for _, peer := range cfg.Peers.Peers {
+		probe(ctx, client, http.MethodGet, strings.TrimRight(peer, "/")+"/health", "peer "+peer, report)
+	}
+
+	if cfg.OIDC.Issuer != "" {
+		_, err := NewOIDCAuth(ctx, cfg.OIDC, systemClock{})
+		report.check(err)
+		if cfg.OIDC.JWKSURL != "" {
+			probe(ctx, client, http.MethodGet, cfg.OIDC.JWKSURL, "oidc jwks", report)
+		}
+	}
+
+	if dir := cfg.Cache.DiskDir; dir != "" {
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			report.warnf("cache.disk_dir %s doesn't exist yet and will be created", dir)
+			return
+		}
+		f, err := os.CreateTemp(dir, ".validate-*")
+		if err != nil {
+			report.errorf("cache.disk_dir %s is not writable: %v", dir, err)
+			return
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, method, url, what string, report *validationReport) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		report.errorf("%s: %v", what, err)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		report.errorf("%s is unreachable: %v", what, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		report.warnf("%s responded with %s", what, resp.Status)
+	}
+}
@@ -0,0 +1,50 @@
+package blogproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+// validationErrors returns the errors validateConfig finds in cfg.
+func validationErrors(cfg Config) []string {
+	report := &validationReport{}
+	validateConfig(cfg, map[string]bool{}, report)
+	return report.errors
+}
+
+func TestValidateConfig(t *testing.T) {
+	if errs := validationErrors(DefaultConfig()); len(errs) > 0 {
+		t.Fatalf("the defaults are invalid: %v", errs)
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      string
+	}{
+		{
+			name: "invalid selector",
+			configure: func(cfg *Config) {
+				cfg.Transforms = map[string]TransformRules{"https://example.com": {Strip: []string{"div["}}}
+			},
+			want: "div[",
+		},
+		{
+			name: "self not a peer",
+			configure: func(cfg *Config) {
+				cfg.Peers = PeersConfig{Self: "http://c", Peers: []string{"http://a", "http://b"}, Secret: "s"}
+			},
+			want: "peers.self",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.configure(&cfg)
+			errs := validationErrors(cfg)
+			if len(errs) == 0 || !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+				t.Errorf("validateConfig = %q, want an error about %q", errs, tt.want)
+			}
+		})
+	}
+}
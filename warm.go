@@ -0,0 +1,176 @@
+package blogproxy
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunWarm implements the warm command: it reads an access log, or the logs
+// of the proxy itself, and requests the most requested urls from a running
+// proxy, so a fresh instance or a new cache namespace starts warm.
+func RunWarm(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	logPath := flags.String("log", "", "access log, in common or combined format, or proxy log to replay; - for stdin")
+	addr := flags.String("target", "", "base url of the proxy to warm, e.g. http://localhost:9080")
+	apiKey := flags.String("api-key", "", "X-API-Key sent with every request")
+	top := flags.Int("top", 100, "number of urls to request, the most requested first")
+	concurrency := flags.Int("concurrency", 4, "maximum requests in flight")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *logPath == "" || *addr == "" || *top <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(stdout, "warm needs -log, -target, a positive -top and a positive -concurrency")
+		return 2
+	}
+
+	in := os.Stdin
+	if *logPath != "-" {
+		f, err := os.Open(*logPath)
+		if err != nil {
+			fmt.Fprintln(stdout, "error:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	urls, err := topURLs(in, *top)
+	if err != nil {
+		fmt.Fprintln(stdout, "error:", err)
+		return 1
+	}
+	if len(urls) == 0 {
+		fmt.Fprintln(stdout, "error: no proxied urls in", *logPath)
+		return 1
+	}
+
+	target := &remoteBenchTarget{base: strings.TrimRight(*addr, "/"), apiKey: *apiKey, client: &http.Client{Timeout: time.Minute}}
+	start := time.Now()
+	results := warm(context.Background(), target, urls, *concurrency)
+	writeWarmReport(stdout, results, time.Since(start))
+	return 0
+}
+
+// topURLs returns the n urls requested the most in the log read from r,
+// the most requested first.
+func topURLs(r io.Reader, n int) ([]string, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if target, ok := loggedURL(scanner.Text()); ok {
+			counts[target]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(counts))
+	for target := range counts {
+		urls = append(urls, target)
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		if counts[urls[i]] != counts[urls[j]] {
+			return counts[urls[i]] > counts[urls[j]]
+		}
+		return urls[i] < urls[j]
+	})
+	return urls[:min(n, len(urls))], nil
+}
+
+// loggedURL returns the proxied url of a log line: the url parameter of a
+// successful request in an access log, or the host and page of a
+// "get object" line of the proxy's logs.
+func loggedURL(line string) (string, bool) {
+	if strings.Contains(line, `msg="get object"`) {
+		host, ok := logValue(line, "host")
+		if !ok {
+			return "", false
+		}
+		page, ok := logValue(line, "page")
+		if !ok {
+			return "", false
+		}
+		return host + "/" + page, true
+	}
+
+	// host ident user [time] "GET /?url=... HTTP/1.1" status size ...
+	_, request, ok := strings.Cut(line, `"GET `)
+	if !ok {
+		return "", false
+	}
+	request, rest, ok := strings.Cut(request, `" `)
+	if !ok {
+		return "", false
+	}
+	path, _, _ := strings.Cut(request, " ")
+	status, _, _ := strings.Cut(rest, " ")
+	if code, err := strconv.Atoi(status); err != nil || code >= 400 {
+		return "", false
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", false
+	}
+	target := u.Query().Get("url")
+	if _, _, ok := parseTargetURL(target); !ok {
+		return "", false
+	}
+	return target, true
+}
+
+// logValue returns the value of key in a line of the text log handler,
+// unquoting it when it was quoted.
+func logValue(line, key string) (string, bool) {
+	i := strings.Index(line, " "+key+"=")
+	if i < 0 {
+		return "", false
+	}
+	value := line[i+len(key)+2:]
+	if strings.HasPrefix(value, `"`) {
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return "", false
+		}
+		value, err = strconv.Unquote(quoted)
+		return value, err == nil
+	}
+	value, _, _ = strings.Cut(value, " ")
+	return value, value != ""
+}
+
+// warm requests every url once, concurrency at a time.
+func warm(ctx context.Context, target benchTarget, urls []string, concurrency int) []benchResult {
+	results := make([]benchResult, len(urls))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			status, err := target.Get(ctx, u)
+			results[i] = benchResult{status: status, latency: time.Since(start), err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func writeWarmReport(w io.Writer, results []benchResult, took time.Duration) {
+	fmt.Fprintf(w, "warmed %d urls in %s\n", len(results), took.Round(time.Millisecond))
+	writeStatuses(w, results)
+}
@@ -0,0 +1,35 @@
+package blogproxy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTopURLs(t *testing.T) {
+	log := strings.Join([]string{
+		`10.0.0.1 - - [14/Oct/2026:10:00:00 +0000] "GET /?url=https%3A%2F%2Fpaulgraham.com%2Fgreatwork.html HTTP/1.1" 200 5120 "-" "curl/8.0"`,
+		`10.0.0.2 - - [14/Oct/2026:10:00:01 +0000] "GET /?url=https%3A%2F%2Fpaulgraham.com%2Fgreatwork.html HTTP/1.1" 304 0`,
+		`10.0.0.3 - - [14/Oct/2026:10:00:02 +0000] "GET /?url=https%3A%2F%2Fpaulgraham.com%2Fmissing.html HTTP/1.1" 404 9`,
+		`10.0.0.3 - - [14/Oct/2026:10:00:03 +0000] "GET /metrics HTTP/1.1" 200 900`,
+		`10.0.0.4 - - [14/Oct/2026:10:00:04 +0000] "POST /admin/purge HTTP/1.1" 200 2`,
+		`time=2026-10-14T10:00:05Z level=INFO msg="get object" host=https://paulgraham.com page=hwh.html client=10.0.0.5`,
+		`time=2026-10-14T10:00:06Z level=INFO msg="get object" host=https://paulgraham.com page="my essay.html" client=10.0.0.5`,
+		`time=2026-10-14T10:00:07Z level=INFO msg="get object" host=https://paulgraham.com page=hwh.html client=10.0.0.6`,
+		`time=2026-10-14T10:00:08Z level=DEBUG msg="cache hit" host=https://paulgraham.com object=hwh.html`,
+		`not a log line`,
+	}, "\n")
+
+	got, err := topURLs(strings.NewReader(log), 3)
+	if err != nil {
+		t.Fatalf("topURLs: %v", err)
+	}
+	want := []string{
+		"https://paulgraham.com/greatwork.html",
+		"https://paulgraham.com/hwh.html",
+		"https://paulgraham.com/my essay.html",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("topURLs = %q, want %q", got, want)
+	}
+}
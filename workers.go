@@ -0,0 +1,68 @@
+package blogproxy
+
+import (
+	log "log/slog"
+	"sync/atomic"
+)
+
+var workerTasks = metrics.Counter(
+	"blogproxy_worker_tasks_total",
+	"Background tasks submitted to the worker pool, by kind and result: queued or dropped.",
+	"kind", "result",
+)
+
+// WorkerPool runs the background work requests spawn, such as early
+// refreshes and comparisons with the origin, on a fixed number of
+// goroutines. Tasks wait in a bounded queue; when it is full they are
+// dropped rather than piling up under load.
+type WorkerPool struct {
+	size  int
+	queue chan func()
+	busy  atomic.Int64
+}
+
+func NewWorkerPool(size, queueSize int) *WorkerPool {
+	p := &WorkerPool{size: max(size, 1), queue: make(chan func(), max(queueSize, 0))}
+	for range p.size {
+		go p.work()
+	}
+
+	metrics.GaugeFunc(
+		"blogproxy_worker_queue_depth",
+		"Background tasks waiting for a worker.",
+		func() float64 { return float64(len(p.queue)) },
+	)
+	metrics.GaugeFunc(
+		"blogproxy_worker_busy",
+		"Workers running a background task.",
+		func() float64 { return float64(p.busy.Load()) },
+	)
+	metrics.GaugeFunc(
+		"blogproxy_worker_utilization",
+		"Fraction of the workers running a background task.",
+		func() float64 { return float64(p.busy.Load()) / float64(p.size) },
+	)
+	return p
+}
+
+// Submit queues fn to run on a worker. It reports false, and drops fn,
+// when the queue is full.
+func (p *WorkerPool) Submit(kind string, fn func()) bool {
+	select {
+	case p.queue <- fn:
+		workerTasks.Inc(kind, "queued")
+		return true
+	default:
+		workerTasks.Inc(kind, "dropped")
+		log.Warn("worker queue full, dropping task", "kind", kind)
+		return false
+	}
+}
+
+func (p *WorkerPool) work() {
+	for fn := range p.queue {
+		p.busy.Add(1)
+		fn()
+		p.busy.Add(-1)
+	}
+}
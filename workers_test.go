@@ -0,0 +1,23 @@
+package blogproxy
+
+import "testing"
+
+func TestWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	running, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{}, 2)
+
+	if !pool.Submit("test", func() { close(running); <-release; done <- struct{}{} }) {
+		t.Fatal("Submit to an idle pool dropped the task")
+	}
+	<-running
+	if !pool.Submit("test", func() { done <- struct{}{} }) {
+		t.Fatal("Submit with room in the queue dropped the task")
+	}
+	if pool.Submit("test", func() { t.Error("a task past the queue ran") }) {
+		t.Error("Submit to a full queue queued the task")
+	}
+	close(release)
+	<-done
+	<-done
+}